@@ -50,16 +50,16 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
-	// wait for shim-logs to be available before starting
-	log.Info().Msg("wait for shim-logs binary to be available")
+	// wait for zlogs to be available before starting
+	log.Info().Msg("wait for zlogs binary to be available")
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxElapsedTime = 0 //forever
 	_ = backoff.RetryNotify(func() error {
-		_, err := exec.LookPath("shim-logs")
+		_, err := exec.LookPath("zlogs")
 		return err
 		// return fmt.Errorf("wait forever")
 	}, bo, func(err error, d time.Duration) {
-		log.Warn().Err(err).Msgf("shim-logs binary not found, retying in %s", d.String())
+		log.Warn().Err(err).Msgf("zlogs binary not found, retying in %s", d.String())
 	})
 
 	if err := os.MkdirAll(moduleRoot, 0750); err != nil {
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"github.com/threefoldtech/zos/pkg/app"
 	"github.com/threefoldtech/zos/pkg/environment"
 	"github.com/threefoldtech/zos/pkg/provision/explorer"
+	"github.com/threefoldtech/zos/pkg/provision/local"
 	"github.com/threefoldtech/zos/pkg/provision/primitives"
 	"github.com/threefoldtech/zos/pkg/provision/primitives/cache"
 
@@ -36,13 +38,27 @@ func main() {
 	var (
 		msgBrokerCon string
 		storageDir   string
+		localDir     string
+		cacheBackend string
+		quotaPolicy  string
+		workers      int
+		gracePeriod  time.Duration
 		debug        bool
+		reportOnly   bool
+		cascade      bool
 		ver          bool
 	)
 
 	flag.StringVar(&storageDir, "root", "/var/cache/modules/provisiond", "root path of the module")
 	flag.StringVar(&msgBrokerCon, "broker", "unix:///var/run/redis.sock", "connection string to the message broker")
+	flag.StringVar(&localDir, "local", "", "read reservations from this local directory instead of the explorer. reservations can also be pushed over the unix socket <local>/provision.sock")
+	flag.StringVar(&cacheBackend, "cache", "fs", "backend of the local reservation cache, 'fs' or 'bolt'. switching to 'bolt' migrates the reservations of the 'fs' cache, which can't be used anymore afterwards")
+	flag.StringVar(&quotaPolicy, "quota", "", "path of the file with the quotas of the users of the node, defaults to <root>/quota.toml. users are not limited if the file doesn't exist")
+	flag.IntVar(&workers, "workers", 4, "number of reservations to process concurrently")
+	flag.DurationVar(&gracePeriod, "grace-period", 24*time.Hour, "how long the volumes and 0-db namespaces of an expired reservation are kept. only used with -local, the explorer reservations are removed as soon as their capacity pool runs out")
 	flag.BoolVar(&debug, "debug", false, "enable debug logging")
+	flag.BoolVar(&reportOnly, "janitor-report", false, "only log the lingering resources found by the janitor instead of deleting them")
+	flag.BoolVar(&cascade, "cascade", false, "decommission the reservations using a network or volume when it is decommissioned, instead of delaying its decommission until they are removed")
 	flag.BoolVar(&ver, "v", false, "show version and exit")
 
 	flag.Parse()
@@ -105,26 +121,121 @@ func main() {
 		log.Error().Err(err).Msgf("networkd is not ready yet")
 	})
 
-	// to get reservation from tnodb
-	e, err := app.ExplorerClient()
+	// to route the reservation types registered by other daemons to them
+	external, err := provision.NewExternalProvisioners(filepath.Join(storageDir, "external.json"), zbusCl)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to instantiate BCDB client")
+		log.Fatal().Err(err).Msg("failed to load external provisioners")
 	}
 
 	// keep track of resource units reserved and amount of workloads provisionned
-	statser := &primitives.Counters{}
+	statser := &primitives.Counters{External: external}
 
 	// to store reservation locally on the node
-	localStore, err := cache.NewFSStore(filepath.Join(storageDir, "reservations"))
+	var localStore interface {
+		provision.ReservationCache
+		provision.ReservationExpirer
+	}
+
+	switch cacheBackend {
+	case "fs":
+		localStore, err = cache.NewFSStore(filepath.Join(storageDir, "reservations"))
+	case "bolt":
+		localStore, err = cache.NewBoltStore(
+			filepath.Join(storageDir, "reservations.db"),
+			filepath.Join(storageDir, "reservations"),
+		)
+	default:
+		err = fmt.Errorf("unknown cache backend '%s'", cacheBackend)
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create local reservation store")
 	}
 	// update stats from the local reservation cache
 	localStore.Sync(statser)
 
-	provisioner := primitives.NewProvisioner(localStore, zbusCl)
+	// to verify reservation signatures and decrypt secrets
+	users, err := primitives.NewUserKeys(filepath.Join(storageDir, "users"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create user keys cache")
+	}
+
+	provisioner := primitives.NewProvisioner(localStore, users, zbusCl)
+
+	// to limit what a single user can reserve on the node
+	if len(quotaPolicy) == 0 {
+		quotaPolicy = filepath.Join(storageDir, "quota.toml")
+	}
+
+	var quota provision.QuotaChecker
+	if _, err := os.Stat(quotaPolicy); err == nil {
+		quota, err = primitives.NewQuotaChecker(statser, quotaPolicy)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load quota policy")
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatal().Err(err).Msg("failed to check quota policy")
+	} else {
+		log.Info().Str("path", quotaPolicy).Msg("no quota policy, users are not limited")
+	}
+
+	// to recover reservations interrupted by a crash
+	journal, err := provision.NewJournal(filepath.Join(storageDir, "journal"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create reservation journal")
+	}
+
+	var (
+		puller   provision.ReservationPoller
+		feedback provision.Feedbacker
+		localSrv *local.Server
+		// only the local reservations expire on the node
+		expiryWarnings []time.Duration
+	)
+
+	if len(localDir) != 0 {
+		// explorer-less node, reservations are read from a local directory
+		log.Info().Str("dir", localDir).Msg("using local reservation source")
+		store, err := local.NewStore(localDir, primitives.ProvisionOrder)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create local reservation source")
+		}
+
+		results, err := local.NewFeedback(localDir)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create local feedback")
+		}
+
+		puller = store
+		feedback = results
+		localSrv = local.NewServer(store, results)
+		expiryWarnings = provision.DefaultExpiryWarnings
+	} else {
+		// to get reservation from tnodb
+		e, err := app.ExplorerClient()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to instantiate BCDB client")
+		}
+
+		puller = explorer.NewPoller(e, primitives.WorkloadToProvisionType, primitives.ProvisionOrder)
+		feedback = explorer.NewFeedback(e, primitives.ResultToSchemaType)
+	}
+
+	// queue the feedback on disk so nothing is lost while
+	// the explorer is not reachable
+	outbox, err := provision.NewOutbox(filepath.Join(storageDir, "outbox"), feedback)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create feedback outbox")
+	}
+
+	// to record the resources consumed by each user over time
+	meter, err := primitives.NewMeter(filepath.Join(storageDir, "metering"), nodeID.Identity(), localStore, statser, identity)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create usage meter")
+	}
+
+	janitor := provision.NewJanitor(zbusCl, puller, localStore)
+	janitor.ReportOnly = reportOnly
 
-	puller := explorer.NewPoller(e, primitives.WorkloadToProvisionType, primitives.ProvisionOrder)
 	engine, err := provision.New(provision.EngineOps{
 		NodeID: nodeID.Identity(),
 		Cache:  localStore,
@@ -134,11 +245,26 @@ func main() {
 		),
 		Provisioners:   provisioner.Provisioners,
 		Decomissioners: provisioner.Decommissioners,
-		Feedback:       explorer.NewFeedback(e, primitives.ResultToSchemaType),
+		Updaters:       provisioner.Updaters,
+		RetryDeadlines: primitives.RetryDeadlines,
+		GracePeriod:    gracePeriod,
+		Persistent:     primitives.PersistentTypes,
+		ExpiryWarnings: expiryWarnings,
+		Feedback:       outbox,
 		Signer:         identity,
+		Users:          users,
 		Statser:        statser,
+		Capacity:       primitives.NewCapacityChecker(statser, localStore, zbusCl),
+		Quota:          quota,
+		Meter:          meter,
+		External:       external,
 		ZbusCl:         zbusCl,
-		Janitor:        provision.NewJanitor(zbusCl, puller),
+		Janitor:        janitor,
+		Journal:        journal,
+		Workers:        workers,
+		Keys:           primitives.ReservationKeys,
+		Dependencies:   primitives.ReservationDependencies,
+		Cascade:        cascade,
 	})
 
 	if err != nil {
@@ -160,6 +286,18 @@ func main() {
 	// call the runtime upgrade before running engine
 	provisioner.RuntimeUpgrade(ctx)
 
+	go outbox.Run(ctx)
+	go meter.Run(ctx)
+
+	if localSrv != nil {
+		go func() {
+			socket := filepath.Join(localDir, "provision.sock")
+			if err := localSrv.Serve(ctx, socket); err != nil {
+				log.Error().Err(err).Msg("local reservation server stopped")
+			}
+		}()
+	}
+
 	go func() {
 		if err := server.Run(ctx); err != nil && err != context.Canceled {
 			log.Fatal().Err(err).Msg("unexpected error")
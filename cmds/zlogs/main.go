@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/containerd/containerd/runtime/v2/logging"
+	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/container/logger"
+	"github.com/threefoldtech/zos/pkg/version"
+)
+
+// zlogs is the logging binary started by containerd for every container
+// task created by contd. It forwards the output of the task to the logs
+// backends of the container
+func main() {
+	app.Initialize()
+
+	var (
+		root string
+		ver  bool
+	)
+
+	flag.StringVar(&root, "root", "/var/cache/modules/contd", "root working directory of contd")
+	flag.BoolVar(&ver, "v", false, "show version and exit")
+
+	flag.Parse()
+	if ver {
+		version.ShowAndExit(false)
+	}
+
+	logging.Run(func(ctx context.Context, config *logging.Config, ready func() error) error {
+		return logger.Run(ctx, root, config, ready)
+	})
+}
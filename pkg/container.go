@@ -4,6 +4,9 @@ package pkg
 //go:generate zbusc -module container -version 0.0.1 -name container -package stubs github.com/threefoldtech/zos/pkg+ContainerModule stubs/container_stub.go
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/threefoldtech/zos/pkg/container/logger"
 	"github.com/threefoldtech/zos/pkg/container/stats"
 )
@@ -13,9 +16,10 @@ type ContainerID string
 
 // NetworkInfo defines a network configuration for a container
 type NetworkInfo struct {
-	// Currently a container can only join one (and only one)
-	// network namespace that has to be pre defined on the node
-	// for the container tenant
+	// A container joins one network namespace that has to be
+	// pre defined on the node for the container tenant. The
+	// namespace can be attached to several networks, one
+	// interface per network
 
 	// Containers don't need to know about anything about bridges,
 	// IPs, wireguards since this is all is only known by the network
@@ -51,10 +55,149 @@ type Container struct {
 	CPU uint
 	// Memory limit in bytes
 	Memory uint64
+	// IO limits of the container disks
+	IO IOLimits
+	// Pids is the maximum number of processes in the container, 0 doesn't limit it
+	Pids int64
+	// Swap is the amount of swap in bytes the container can use on top
+	// of its memory. 0 disables swap, nil doesn't limit it
+	Swap *uint64
 	// Logs backends
 	Logs []logger.Logs
 	// Stats container metrics backend
 	Stats []stats.Stats
+	// Job runs the entrypoint to completion. The container is not
+	// restarted when its entrypoint exits, instead the exit code and
+	// the tail of its output are reported to provisiond. The output
+	// of a job is kept on the node, the Logs backends are not used
+	Job bool
+	// HealthCheck optional probe of the container health
+	HealthCheck *HealthCheck
+	// Restart policy of the container, restart on exit
+	// with the default number of retries if not set.
+	// Jobs ignore the restart policy
+	Restart RestartPolicy
+	// RestartStatus reports the restarts of the container,
+	// it is only set by Inspect
+	RestartStatus RestartStatus
+}
+
+// RestartMode defines when a container is restarted after its entrypoint exits
+type RestartMode string
+
+const (
+	// RestartOnExit restarts the container whenever its entrypoint
+	// exits, up to MaxRetries times in a row. This is the default
+	RestartOnExit RestartMode = "on-exit"
+	// RestartOnFailure restarts the container when its entrypoint exits
+	// with a non zero code, up to MaxRetries times in a row
+	RestartOnFailure RestartMode = "on-failure"
+	// RestartAlways always restarts the container
+	RestartAlways RestartMode = "always"
+	// RestartNever never restarts the container, it stays stopped
+	RestartNever RestartMode = "never"
+)
+
+// RestartPolicy defines how a container is restarted. Restarts are
+// delayed with an exponential backoff
+type RestartPolicy struct {
+	// Mode of the policy, on-exit if not set
+	Mode RestartMode
+	// MaxRetries is the number of restarts in a row after which a
+	// failing container is deleted and its reservation decommissioned.
+	// Only used by on-exit and on-failure, uses the default if 0
+	MaxRetries uint
+}
+
+// RestartStatus reports the restarts of a container
+type RestartStatus struct {
+	// Count is the number of times the container was restarted
+	Count uint
+	// Exited is true if the entrypoint of the container exited at least once
+	Exited bool
+	// ExitCode of the last exit of the entrypoint
+	ExitCode uint32
+	// ExitedAt is when the entrypoint last exited
+	ExitedAt time.Time
+}
+
+// IOLimits are the block IO limits of a container. They apply to each
+// of the disks of the node. A zero value means no limit
+type IOLimits struct {
+	// ReadBps is the maximum read rate in bytes per second
+	ReadBps uint64
+	// WriteBps is the maximum write rate in bytes per second
+	WriteBps uint64
+	// ReadIOPS is the maximum read rate in IO per second
+	ReadIOPS uint64
+	// WriteIOPS is the maximum write rate in IO per second
+	WriteIOPS uint64
+}
+
+// HealthCheck defines how the health of a container is probed. Exactly
+// one of Exec, TCP or HTTP must be set.
+// When Threshold probes fail in a row, the container is restarted. If it
+// is still unhealthy after a few restarts its reservation is decommissioned
+type HealthCheck struct {
+	// Exec command run inside the container, the container is
+	// healthy if the command exits with 0
+	Exec string
+	// TCP address (host:port) dialed from the network namespace
+	// of the container, the container is healthy if it accepts
+	// the connection
+	TCP string
+	// HTTP url requested from the network namespace of the container,
+	// the container is healthy if the response status is 2xx or 3xx
+	HTTP string
+	// Interval between two probes
+	Interval time.Duration
+	// Timeout of a single probe
+	Timeout time.Duration
+	// Threshold is the number of consecutive failed probes
+	// after which the container is unhealthy
+	Threshold uint
+}
+
+// JobResult is the outcome of a container running as a job
+type JobResult struct {
+	// ExitCode of the entrypoint
+	ExitCode uint32 `json:"exit_code"`
+	// ExitedAt is when the entrypoint exited
+	ExitedAt time.Time `json:"exited_at"`
+	// Output is the tail of the stdout and stderr of the
+	// entrypoint, in the order they were written
+	Output string `json:"output"`
+}
+
+// AccessTokenMaxLifetime is how far in the future the
+// expiry of an access token can be when it is used
+const AccessTokenMaxLifetime = time.Hour
+
+// AccessToken grants access to a running workload. It is signed by the
+// owner of the reservation with the key used to sign the reservation
+type AccessToken struct {
+	// Reservation is the id of the reservation the token gives access to
+	Reservation string `json:"reservation"`
+	// Expiry is when the token stops being valid, tokens expiring more
+	// than AccessTokenMaxLifetime in the future are refused
+	Expiry time.Time `json:"expiry"`
+	// Signature of SignedBytes by the reservation owner
+	Signature []byte `json:"signature"`
+}
+
+// SignedBytes returns the content of the token covered by the signature
+func (t AccessToken) SignedBytes() []byte {
+	return []byte(fmt.Sprintf("%s:%d", t.Reservation, t.Expiry.Unix()))
+}
+
+// ExecSession is a process executed inside a container. The streams of
+// the process are served on a unix socket, see pkg/container/session for
+// the protocol. The process is started when the first client connects
+type ExecSession struct {
+	// ID of the process, used to attach to it again
+	ID string
+	// Socket is the path of the unix socket serving the process streams
+	Socket string
 }
 
 // ContainerModule defines rpc interface to containerd
@@ -75,4 +218,21 @@ type ContainerModule interface {
 	// Inspect, return information about the container, given its container id
 	Inspect(ns string, id ContainerID) (Container, error)
 	Delete(ns string, id ContainerID) error
+
+	// Update changes the cpu and memory limits of a container. The new
+	// limits are applied to the running task without restarting it
+	Update(ns string, id ContainerID, cpu uint, memory uint64) error
+
+	// Exec runs cmd inside the running container id. env is added to the
+	// environment of the container, tty allocates a terminal for the process.
+	// token must be signed by the owner of the container reservation
+	Exec(ns string, id ContainerID, token AccessToken, cmd []string, env []string, tty bool) (ExecSession, error)
+	// Attach returns the session of the process exec running
+	// inside the container id, to reconnect to its streams
+	Attach(ns string, id ContainerID, token AccessToken, exec string) (ExecSession, error)
+
+	// Console returns the last logs of the container id, kept by its console
+	// logs backend. The logs of a stopped container can still be read until
+	// it is deleted. token must be signed by the owner of the container reservation
+	Console(ns string, id ContainerID, token AccessToken) (string, error)
 }
@@ -0,0 +1,80 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/container/logger"
+)
+
+// consoleTimeout is how long reading the console of a running container can take
+const consoleTimeout = 10 * time.Second
+
+// hasConsole checks if logs has a console backend
+func hasConsole(logs []logger.Logs) bool {
+	for _, l := range logs {
+		if l.Type == logger.ConsoleType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readConsole returns the console of container id. The console of a running
+// container is served by its logging process, the console of a stopped
+// container is the one saved by the logging process when it exited
+func readConsole(root, ns, id string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", logger.ConsoleSocket(root, ns, id), consoleTimeout)
+	if err == nil {
+		defer conn.Close()
+		if err := conn.SetReadDeadline(time.Now().Add(consoleTimeout)); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(conn)
+	}
+
+	data, err := ioutil.ReadFile(logger.ConsolePath(root, ns, id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("container %s has no console", id)
+	}
+
+	return data, err
+}
+
+// removeLogs deletes the logs kept on the node for container id
+func (c *Module) removeLogs(ns, id string) {
+	files, err := filepath.Glob(logger.FilePath(c.root, ns, id) + "*")
+	if err != nil {
+		log.Error().Err(err).Str("id", id).Msg("failed to list logs files")
+	}
+
+	files = append(files, logger.ConsolePath(c.root, ns, id))
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Str("path", file).Msg("failed to remove logs file")
+		}
+	}
+}
+
+// Console returns the last logs of the container id
+func (c *Module) Console(ns string, id pkg.ContainerID, token pkg.AccessToken) (string, error) {
+	log.Info().Str("id", string(id)).Str("ns", ns).Msg("read container console")
+
+	if err := c.verifyToken(id, token); err != nil {
+		return "", err
+	}
+
+	data, err := readConsole(c.root, ns, string(id))
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,50 @@
+package container
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/container/logger"
+)
+
+func TestReadConsole(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "console-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	_, err = readConsole(root, "ns", "container")
+	require.Error(err)
+
+	// console saved by the logging process of a stopped container
+	path := logger.ConsolePath(root, "ns", "container")
+	require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(ioutil.WriteFile(path, []byte("saved"), 0600))
+
+	data, err := readConsole(root, "ns", "container")
+	require.NoError(err)
+	require.Equal("saved", string(data))
+
+	// console served by the logging process of a running container
+	listener, err := net.Listen("unix", logger.ConsoleSocket(root, "ns", "container"))
+	require.NoError(err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("running"))
+		conn.Close()
+	}()
+
+	data, err = readConsole(root, "ns", "container")
+	require.NoError(err)
+	require.Equal("running", string(data))
+}
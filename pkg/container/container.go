@@ -6,12 +6,12 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
 
+	"encoding/json"
 	"fmt"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -36,15 +36,19 @@ import (
 
 const (
 	containerdSock = "/run/containerd/containerd.sock"
-	binaryLogsShim = "/bin/shim-logs"
+	binaryLogsShim = "/bin/zlogs"
 )
 
 const (
 	defaultMemory = 256 * 1024 * 1204 // 256MiB
 	defaultCPU    = 1
 
+	// failuresBeforeDestroy is the number of times an unhealthy
+	// container is restarted before it is deleted
 	failuresBeforeDestroy = 4
-	restartDelay          = 2 * time.Second
+	// restartDelay is the delay before the first restart of a container,
+	// it doubles with every restart in a row
+	restartDelay = 2 * time.Second
 )
 
 var (
@@ -74,6 +78,12 @@ type Module struct {
 	root       string
 	client     zbus.Client
 	failures   *cache.Cache
+
+	healthM sync.Mutex
+	health  map[string]healthEntry
+
+	sessionsM sync.Mutex
+	sessions  map[string]*execSession
 }
 
 // New return an new pkg.ContainerModule
@@ -88,12 +98,18 @@ func New(client zbus.Client, root string, containerd string) *Module {
 		client:     client,
 		// values are cached only for 1 minute. purge cache every 20 second
 		failures: cache.New(time.Minute, 20*time.Second),
+		health:   make(map[string]healthEntry),
+		sessions: make(map[string]*execSession),
 	}
 
 	if err := module.upgrade(); err != nil {
 		log.Error().Err(err).Msg("failed to update containers configurations")
 	}
 
+	if err := module.resumeHealthChecks(); err != nil {
+		log.Error().Err(err).Msg("failed to start containers health checks")
+	}
+
 	return module
 }
 
@@ -165,6 +181,26 @@ func (c *Module) Run(ns string, data pkg.Container) (id pkg.ContainerID, err err
 		data.Logs = []logger.Logs{}
 	}
 
+	// the console keeps the last logs of every container
+	// so they can be read back even without other backends
+	if !hasConsole(data.Logs) {
+		console, err := logger.New(logger.ConsoleType, logger.LogsConsole{})
+		if err != nil {
+			return id, err
+		}
+		data.Logs = append(data.Logs, console)
+	}
+
+	if data.HealthCheck != nil {
+		if err := healthCheckDefaults(data.HealthCheck); err != nil {
+			return id, err
+		}
+	}
+
+	if err := restartPolicyDefaults(&data.Restart); err != nil {
+		return id, err
+	}
+
 	// we never allow any container to boot without a network namespace
 	if data.Network.Namespace == "" {
 		return "", fmt.Errorf("cannot create container without network namespace")
@@ -184,6 +220,9 @@ func (c *Module) Run(ns string, data pkg.Container) (id pkg.ContainerID, err err
 		withMounts(data.Mounts),
 		WithMemoryLimit(data.Memory),
 		WithCPUCount(data.CPU),
+		WithSwapLimit(data.Memory, data.Swap),
+		WithPidsLimit(data.Pids),
+		WithIOLimits(data.IO),
 	}
 
 	if data.WorkingDir != "" {
@@ -191,6 +230,9 @@ func (c *Module) Run(ns string, data pkg.Container) (id pkg.ContainerID, err err
 	}
 
 	if data.Interactive {
+		if data.Job {
+			return id, fmt.Errorf("a job cannot be interactive")
+		}
 		opts = append(opts, withCoreX())
 	} else {
 		args, err := shlex.Split(data.Entrypoint)
@@ -206,14 +248,33 @@ func (c *Module) Run(ns string, data pkg.Container) (id pkg.ContainerID, err err
 		Str("data", fmt.Sprintf("%+v", data)).
 		Msgf("create new container")
 
-	container, err := client.NewContainer(
-		ctx,
-		data.Name,
+	containerOpts := []containerd.NewContainerOpts{
 		containerd.WithNewSpec(opts...),
 		// this ensure that the container/task will be restarted automatically
 		// if it gets killed for whatever reason (mostly OOM killer)
-		restart.WithBinaryLogURI(binaryLogsShim, nil),
-	)
+		restart.WithBinaryLogURI(binaryLogsShim, c.logsArgs()),
+	}
+
+	labels, err := restartLabels(data.Restart, restartState{StartedAt: time.Now()})
+	if err != nil {
+		return id, err
+	}
+
+	if data.Job {
+		labels[jobLabel] = "true"
+	}
+
+	if data.HealthCheck != nil {
+		check, err := json.Marshal(data.HealthCheck)
+		if err != nil {
+			return id, err
+		}
+		labels[healthCheckLabel] = string(check)
+	}
+
+	containerOpts = append(containerOpts, containerd.WithContainerLabels(labels))
+
+	container, err := client.NewContainer(ctx, data.Name, containerOpts...)
 
 	if err != nil {
 		return id, err
@@ -241,13 +302,12 @@ func (c *Module) Run(ns string, data pkg.Container) (id pkg.ContainerID, err err
 	}()
 
 	// creating logs config directories
-	cfgs := path.Join(c.root, "config", ns)
-	if err = os.MkdirAll(cfgs, 0755); err != nil {
+	confpath := logger.ConfigPath(c.root, ns, container.ID())
+	if err = os.MkdirAll(filepath.Dir(confpath), 0755); err != nil {
 		return id, err
 	}
 
 	// creating and serializing logs settings for external logger
-	confpath := path.Join(cfgs, fmt.Sprintf("%s-logs.json", container.ID()))
 	log.Info().Str("cfg", confpath).Msg("writing logs settings")
 
 	err = logger.Serialize(confpath, data.Logs)
@@ -274,20 +334,49 @@ func (c *Module) Run(ns string, data pkg.Container) (id pkg.ContainerID, err err
 		}
 	}
 
-	if err := c.ensureTask(ctx, container); err != nil {
+	creator, err := c.logsIO()
+	if err != nil {
+		return id, err
+	}
+
+	if data.Job {
+		// the output of a job is kept in a file so it can
+		// be reported once the job exits
+		output := c.jobOutputPath(ns, container.ID())
+		if err = os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return id, err
+		}
+		creator = cio.LogFile(output)
+	}
+
+	if err := c.ensureTask(ctx, container, creator); err != nil {
 		return id, err
 	}
 
+	if data.HealthCheck != nil {
+		c.startHealthCheck(ns, container.ID(), *data.HealthCheck)
+	}
+
 	return pkg.ContainerID(container.ID()), nil
 }
 
-func (c *Module) ensureTask(ctx context.Context, container containerd.Container) error {
-	uri, err := url.Parse("binary://" + binaryLogsShim)
+// logsArgs are the arguments of the external logging process
+func (c *Module) logsArgs() map[string]string {
+	return map[string]string{"-root": c.root}
+}
+
+// logsIO sends the output of the task to the external logging process
+func (c *Module) logsIO() (cio.Creator, error) {
+	uri, err := cio.LogURIGenerator("binary", binaryLogsShim, c.logsArgs())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	log.Info().Str("loguri", uri.String()).Msg("external logging process")
+	return cio.LogURI(uri), nil
+}
+
+func (c *Module) ensureTask(ctx context.Context, container containerd.Container, creator cio.Creator) error {
 	task, err := container.Task(ctx, nil)
 
 	if err != nil && !errdefs.IsNotFound(err) {
@@ -301,7 +390,7 @@ func (c *Module) ensureTask(ctx context.Context, container containerd.Container)
 	}
 
 	//and finally create a new task
-	task, err = container.NewTask(ctx, cio.LogURI(uri))
+	task, err = container.NewTask(ctx, creator)
 	if err != nil {
 		return err
 	}
@@ -323,7 +412,12 @@ func (c *Module) start(ns, id string) error {
 		return err
 	}
 
-	return c.ensureTask(ctx, container)
+	creator, err := c.logsIO()
+	if err != nil {
+		return err
+	}
+
+	return c.ensureTask(ctx, container, creator)
 }
 
 // Inspect returns the detail about a running container
@@ -351,10 +445,32 @@ func (c *Module) Inspect(ns string, id pkg.ContainerID) (result pkg.Container, e
 	result.RootFS = spec.Root.Path
 	result.Name = container.ID()
 
+	if spec.Linux != nil {
+		limitsFromSpec(spec.Linux.Resources, &result)
+	}
+
 	if spec.Root.Path == "/usr/lib/corex" {
 		result.Interactive = true
 	}
 
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return result, err
+	}
+	result.Job = labels[jobLabel] == "true"
+
+	result.HealthCheck, err = healthCheckFromLabels(labels)
+	if err != nil {
+		return result, err
+	}
+
+	var state restartState
+	result.Restart, state, err = restartFromLabels(labels)
+	if err != nil {
+		return result, err
+	}
+	result.RestartStatus = state.RestartStatus
+
 	if process := spec.Process; process != nil {
 		result.Entrypoint = strings.Join(process.Args, " ")
 		result.Env = process.Env
@@ -382,6 +498,61 @@ func (c *Module) Inspect(ns string, id pkg.ContainerID) (result pkg.Container, e
 	return
 }
 
+// Update changes the cpu and memory limits of a container
+func (c *Module) Update(ns string, id pkg.ContainerID, cpu uint, memory uint64) error {
+	log.Info().Str("id", string(id)).Str("ns", ns).Uint("cpu", cpu).Uint64("memory", memory).Msg("update container")
+
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+
+	container, err := client.LoadContainer(ctx, string(id))
+	if err != nil {
+		return err
+	}
+
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the swap limit includes the memory, so it changes with it
+	var current pkg.Container
+	limitsFromSpec(spec.Linux.Resources, &current)
+
+	if spec.Linux.Resources != nil {
+		// WithCPUCount only sets the cpu limits if not already set
+		spec.Linux.Resources.CPU = nil
+	}
+
+	// update the stored spec so the limits are kept
+	// when the task is restarted
+	err = container.Update(ctx, containerd.UpdateContainerOpts(
+		containerd.WithSpec(spec, WithMemoryLimit(memory), WithCPUCount(cpu), WithSwapLimit(memory, current.Swap)),
+	))
+	if err != nil {
+		return errors.Wrap(err, "failed to update container spec")
+	}
+
+	task, err := container.Task(ctx, nil)
+	if errdefs.IsNotFound(err) {
+		// container is not running, new limits will be used on next start
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := task.Update(ctx, containerd.WithResources(spec.Linux.Resources)); err != nil {
+		return errors.Wrap(err, "failed to update container task resources")
+	}
+
+	return nil
+}
+
 // ListNS list the name of all the container namespaces
 func (c *Module) ListNS() ([]string, error) {
 	log.Info().Msg("list namespaces")
@@ -443,6 +614,7 @@ func (c *Module) Delete(ns string, id pkg.ContainerID) error {
 	// mark this container as perminant down. so the watcher
 	// does not try to restart it again
 	c.failures.Set(string(id), permanent, cache.DefaultExpiration)
+	c.stopHealthCheck(string(id))
 
 	task, err := container.Task(ctx, nil)
 	if err == nil {
@@ -481,6 +653,12 @@ func (c *Module) Delete(ns string, id pkg.ContainerID) error {
 		}
 	}
 
+	if err := os.Remove(c.jobOutputPath(ns, string(id))); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Str("id", string(id)).Msg("failed to remove job output")
+	}
+
+	c.removeLogs(ns, string(id))
+
 	return container.Delete(ctx)
 }
 
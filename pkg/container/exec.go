@@ -0,0 +1,363 @@
+package container
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/container/session"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+const (
+	// execStartTimeout is how long a process waits for a client
+	// to connect to its session before it is dropped
+	execStartTimeout = time.Minute
+	// execOutputTimeout is how long the output of a process is
+	// forwarded after it exited
+	execOutputTimeout = 5 * time.Second
+)
+
+// execSession serves the streams of a process executed inside a container.
+// Only one client is attached at a time, the last one to connect. The
+// output of the process is dropped while no client is attached
+type execSession struct {
+	ns        string
+	container string
+	id        string
+	socket    string
+
+	client   *containerd.Client
+	process  containerd.Process
+	listener net.Listener
+	stdin    *io.PipeWriter
+	started  chan struct{}
+
+	m    sync.Mutex
+	conn net.Conn
+}
+
+// output is the writer of the stream of the process
+type output struct {
+	session *execSession
+	stream  session.Stream
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.session.m.Lock()
+	defer o.session.m.Unlock()
+
+	if o.session.conn == nil {
+		return len(p), nil
+	}
+
+	for written := 0; written < len(p); written += session.MaxFrameSize {
+		end := written + session.MaxFrameSize
+		if end > len(p) {
+			end = len(p)
+		}
+
+		if err := session.WriteFrame(o.session.conn, o.stream, p[written:end]); err != nil {
+			// the client is gone, the process keeps running
+			o.session.conn.Close()
+			o.session.conn = nil
+			break
+		}
+	}
+
+	return len(p), nil
+}
+
+// attach makes conn the client of the session
+func (s *execSession) attach(conn net.Conn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = conn
+}
+
+// detach closes conn if it is still the client of the session
+func (s *execSession) detach(conn net.Conn) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	conn.Close()
+	if s.conn == conn {
+		s.conn = nil
+	}
+}
+
+// accept attaches the clients connecting to the session until the listener is closed
+func (s *execSession) accept(ctx context.Context) {
+	var once sync.Once
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.attach(conn)
+		once.Do(func() {
+			if err := s.process.Start(ctx); err != nil {
+				log.Error().Err(err).Str("exec", s.id).Msg("failed to start process")
+				s.detach(conn)
+				return
+			}
+			close(s.started)
+		})
+
+		go s.input(ctx, conn)
+	}
+}
+
+// input forwards the frames sent by the client conn to the process
+func (s *execSession) input(ctx context.Context, conn net.Conn) {
+	defer s.detach(conn)
+
+	for {
+		stream, data, err := session.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+
+		switch stream {
+		case session.Stdin:
+			if len(data) == 0 {
+				s.stdin.Close()
+				if err := s.process.CloseIO(ctx, containerd.WithStdinCloser); err != nil {
+					log.Debug().Err(err).Str("exec", s.id).Msg("failed to close process stdin")
+				}
+				continue
+			}
+
+			if _, err := s.stdin.Write(data); err != nil {
+				log.Debug().Err(err).Str("exec", s.id).Msg("failed to write to process stdin")
+			}
+		case session.Resize:
+			if len(data) != 4 {
+				continue
+			}
+
+			width := binary.BigEndian.Uint16(data[0:2])
+			height := binary.BigEndian.Uint16(data[2:4])
+			if err := s.process.Resize(ctx, uint32(width), uint32(height)); err != nil {
+				log.Debug().Err(err).Str("exec", s.id).Msg("failed to resize process terminal")
+			}
+		}
+	}
+}
+
+// exit sends the exit code to the attached client and closes the session
+func (s *execSession) exit(code uint32) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn == nil {
+		return
+	}
+
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], code)
+	if err := session.WriteFrame(s.conn, session.Exit, data[:]); err != nil {
+		log.Debug().Err(err).Str("exec", s.id).Msg("failed to send exit code")
+	}
+
+	s.conn.Close()
+	s.conn = nil
+}
+
+// serve the session until the process exits, or no client
+// connects in time to start it
+func (c *Module) serve(s *execSession, exitC <-chan containerd.ExitStatus) {
+	log := log.With().Str("container", s.container).Str("exec", s.id).Logger()
+	ctx := namespaces.WithNamespace(context.Background(), s.ns)
+
+	go s.accept(ctx)
+
+	select {
+	case <-s.started:
+		status := <-exitC
+		code, _, err := status.Result()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get process exit status")
+		}
+
+		log.Info().Uint32("exit-code", code).Msg("process exited")
+
+		// wait for the last output to be forwarded before sending the
+		// exit code, unless a child of the process keeps it open
+		copied := make(chan struct{})
+		go func() {
+			s.process.IO().Wait()
+			close(copied)
+		}()
+
+		select {
+		case <-copied:
+		case <-time.After(execOutputTimeout):
+		}
+
+		s.exit(code)
+	case <-time.After(execStartTimeout):
+		log.Info().Msg("no client attached to process, dropping it")
+	}
+
+	c.sessionsM.Lock()
+	delete(c.sessions, s.id)
+	c.sessionsM.Unlock()
+
+	s.listener.Close()
+	s.stdin.Close()
+	if _, err := s.process.Delete(ctx, containerd.WithProcessKill); err != nil {
+		log.Debug().Err(err).Msg("failed to delete process")
+	}
+	s.client.Close()
+	os.Remove(s.socket)
+}
+
+// verifyToken asks provisiond to check that token is signed by the owner of
+// the reservation of container id. Containers are named after their reservation
+func (c *Module) verifyToken(id pkg.ContainerID, token pkg.AccessToken) error {
+	stub := stubs.NewProvisionStub(c.client)
+	if err := stub.VerifyToken(string(id), token); err != nil {
+		return errors.Wrap(err, "access denied")
+	}
+
+	return nil
+}
+
+// Exec runs cmd inside the container id, the process is started
+// when the first client connects to the socket of the session
+func (c *Module) Exec(ns string, id pkg.ContainerID, token pkg.AccessToken, cmd []string, env []string, tty bool) (result pkg.ExecSession, err error) {
+	log.Info().Str("id", string(id)).Str("ns", ns).Strs("cmd", cmd).Msg("exec in container")
+
+	if len(cmd) == 0 {
+		return result, fmt.Errorf("command is required")
+	}
+
+	if err := c.verifyToken(id, token); err != nil {
+		return result, err
+	}
+
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return result, err
+	}
+	// the client is used by the session until the process exits
+	defer func() {
+		if err != nil {
+			client.Close()
+		}
+	}()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+
+	container, err := client.LoadContainer(ctx, string(id))
+	if err != nil {
+		return result, err
+	}
+
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return result, errors.Wrap(err, "container is not running")
+	}
+
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	process := *spec.Process
+	process.Args = cmd
+	process.Env = append(append([]string{}, process.Env...), env...)
+	process.Terminal = tty
+
+	dir := filepath.Join(c.root, "exec")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return result, err
+	}
+
+	execID := fmt.Sprintf("exec-%d", time.Now().UnixNano())
+	stdin, stdinWriter := io.Pipe()
+	s := &execSession{
+		ns:        ns,
+		container: string(id),
+		id:        execID,
+		socket:    filepath.Join(dir, execID+".sock"),
+		client:    client,
+		stdin:     stdinWriter,
+		started:   make(chan struct{}),
+	}
+
+	opts := []cio.Opt{
+		cio.WithStreams(stdin, &output{session: s, stream: session.Stdout}, &output{session: s, stream: session.Stderr}),
+	}
+	if tty {
+		opts = append(opts, cio.WithTerminal)
+	}
+
+	s.process, err = task.Exec(ctx, execID, &process, cio.NewCreator(opts...))
+	if err != nil {
+		return result, errors.Wrap(err, "failed to create process")
+	}
+
+	defer func() {
+		if err != nil {
+			s.process.Delete(ctx)
+		}
+	}()
+
+	exitC, err := s.process.Wait(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	s.listener, err = net.Listen("unix", s.socket)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to listen on session socket")
+	}
+
+	c.sessionsM.Lock()
+	c.sessions[execID] = s
+	c.sessionsM.Unlock()
+
+	go c.serve(s, exitC)
+
+	return pkg.ExecSession{ID: execID, Socket: s.socket}, nil
+}
+
+// Attach returns the session of the process exec running inside
+// the container id. Connecting to the session socket detaches
+// the client currently connected
+func (c *Module) Attach(ns string, id pkg.ContainerID, token pkg.AccessToken, exec string) (pkg.ExecSession, error) {
+	log.Info().Str("id", string(id)).Str("ns", ns).Str("exec", exec).Msg("attach to container process")
+
+	if err := c.verifyToken(id, token); err != nil {
+		return pkg.ExecSession{}, err
+	}
+
+	c.sessionsM.Lock()
+	defer c.sessionsM.Unlock()
+
+	s, ok := c.sessions[exec]
+	if !ok || s.ns != ns || s.container != string(id) {
+		return pkg.ExecSession{}, fmt.Errorf("process %s is not running in container %s", exec, id)
+	}
+
+	return pkg.ExecSession{ID: s.id, Socket: s.socket}, nil
+}
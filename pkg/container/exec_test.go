@@ -0,0 +1,43 @@
+package container
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/container/session"
+)
+
+func TestExecSessionOutput(t *testing.T) {
+	require := require.New(t)
+
+	s := &execSession{}
+	stdout := &output{session: s, stream: session.Stdout}
+
+	// output is dropped while no client is attached
+	n, err := stdout.Write([]byte("lost"))
+	require.NoError(err)
+	require.Equal(4, n)
+
+	conn, client := net.Pipe()
+	s.attach(conn)
+
+	go func() {
+		_, _ = stdout.Write([]byte("hello"))
+		s.exit(3)
+	}()
+
+	stream, data, err := session.ReadFrame(client)
+	require.NoError(err)
+	require.Equal(session.Stdout, stream)
+	require.Equal("hello", string(data))
+
+	stream, data, err = session.ReadFrame(client)
+	require.NoError(err)
+	require.Equal(session.Exit, stream)
+	require.Equal([]byte{0, 0, 0, 3}, data)
+
+	// the session is closed after the exit code
+	_, _, err = session.ReadFrame(client)
+	require.Error(err)
+}
@@ -0,0 +1,466 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/google/shlex"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+const (
+	// healthCheckLabel holds the json encoded health check of a container
+	healthCheckLabel = "zos.healthcheck"
+
+	defaultHealthInterval  = 10 * time.Second
+	defaultHealthTimeout   = 5 * time.Second
+	defaultHealthThreshold = 3
+)
+
+// errTaskNotRunning is returned by a probe when the container task is not
+// running, for example while it is being restarted. It is not counted as a failure
+var errTaskNotRunning = errors.New("container task is not running")
+
+type healthEntry struct {
+	monitor *healthMonitor
+	cancel  context.CancelFunc
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// healthMonitor probes a container periodically. After Threshold failed
+// probes in a row, the container is restarted. If the container is still
+// unhealthy after failuresBeforeDestroy restarts, it is destroyed
+type healthMonitor struct {
+	check   pkg.HealthCheck
+	probe   func(ctx context.Context) error
+	restart func() error
+	destroy func(reason error)
+
+	m       sync.Mutex
+	lastErr error
+}
+
+func (h *healthMonitor) run(ctx context.Context) {
+	var failures, restarts uint
+
+	ticker := time.NewTicker(h.check.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		probeCtx, cancel := context.WithTimeout(ctx, h.check.Timeout)
+		err := h.probe(probeCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, errTaskNotRunning) {
+			continue
+		}
+
+		h.setError(err)
+		if err == nil {
+			failures, restarts = 0, 0
+			continue
+		}
+
+		failures++
+		log.Debug().Err(err).Uint("failures", failures).Msg("health check failed")
+		if failures < h.check.Threshold {
+			continue
+		}
+
+		failures = 0
+		restarts++
+		if restarts >= failuresBeforeDestroy {
+			h.destroy(errors.Wrap(err, "container is unhealthy"))
+			return
+		}
+
+		if err := h.restart(); err != nil {
+			log.Error().Err(err).Msg("failed to restart unhealthy container")
+		}
+	}
+}
+
+func (h *healthMonitor) setError(err error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.lastErr = err
+}
+
+// Err returns the error of the last probe, nil if the container is healthy
+func (h *healthMonitor) Err() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.lastErr
+}
+
+// healthCheckDefaults validates the health check and sets
+// the default values of the fields that are not set
+func healthCheckDefaults(check *pkg.HealthCheck) error {
+	probes := 0
+	for _, probe := range []string{check.Exec, check.TCP, check.HTTP} {
+		if len(probe) != 0 {
+			probes++
+		}
+	}
+
+	if probes != 1 {
+		return fmt.Errorf("health check must define exactly one of exec, tcp or http")
+	}
+
+	if check.Interval == 0 {
+		check.Interval = defaultHealthInterval
+	}
+
+	if check.Timeout == 0 {
+		check.Timeout = defaultHealthTimeout
+	}
+
+	if check.Threshold == 0 {
+		check.Threshold = defaultHealthThreshold
+	}
+
+	return nil
+}
+
+// startHealthCheck starts monitoring the health of container id. It
+// replaces any monitor already running for this container
+func (c *Module) startHealthCheck(ns, id string, check pkg.HealthCheck) {
+	log := log.With().Str("namespace", ns).Str("container", id).Logger()
+
+	monitor := &healthMonitor{
+		check: check,
+		probe: c.prober(ns, id, check),
+		restart: func() error {
+			log.Info().Msg("restarting unhealthy container")
+			return c.kill(ns, id)
+		},
+		destroy: func(reason error) {
+			log.Info().Err(reason).Msg("deleting unhealthy container")
+			stub := stubs.NewProvisionStub(c.client)
+			if err := stub.DecommissionCached(id, reason.Error()); err != nil {
+				log.Error().Err(err).Msg("failed to decommission reservation")
+			}
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.healthM.Lock()
+	if running, ok := c.health[id]; ok {
+		running.cancel()
+	}
+	c.health[id] = healthEntry{monitor: monitor, cancel: cancel}
+	c.healthM.Unlock()
+
+	log.Debug().Msg("starting health check")
+	go monitor.run(ctx)
+}
+
+// stopHealthCheck stops monitoring the health of container id
+func (c *Module) stopHealthCheck(id string) {
+	c.healthM.Lock()
+	defer c.healthM.Unlock()
+
+	if running, ok := c.health[id]; ok {
+		running.cancel()
+		delete(c.health, id)
+	}
+}
+
+// healthError returns the last health check error of container id
+func (c *Module) healthError(id string) error {
+	c.healthM.Lock()
+	defer c.healthM.Unlock()
+
+	running, ok := c.health[id]
+	if !ok {
+		return nil
+	}
+
+	return running.monitor.Err()
+}
+
+// resumeHealthChecks starts the monitors of all the containers that
+// have a health check. Needed after contd restarts
+func (c *Module) resumeHealthChecks() error {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	nss, err := client.NamespaceService().List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, ns := range nss {
+		ctx := namespaces.WithNamespace(context.Background(), ns)
+		containers, err := client.Containers(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("namespace", ns).Msg("failed to list containers")
+			continue
+		}
+
+		for _, container := range containers {
+			labels, err := container.Labels(ctx)
+			if err != nil {
+				log.Error().Err(err).Str("container", container.ID()).Msg("failed to get container labels")
+				continue
+			}
+
+			check, err := healthCheckFromLabels(labels)
+			if err != nil {
+				log.Error().Err(err).Str("container", container.ID()).Msg("invalid container health check")
+				continue
+			} else if check == nil {
+				continue
+			}
+
+			c.startHealthCheck(ns, container.ID(), *check)
+		}
+	}
+
+	return nil
+}
+
+// healthCheckFromLabels decodes the health check stored in the container
+// labels, it returns nil if the container has no health check
+func healthCheckFromLabels(labels map[string]string) (*pkg.HealthCheck, error) {
+	data, ok := labels[healthCheckLabel]
+	if !ok {
+		return nil, nil
+	}
+
+	var check pkg.HealthCheck
+	if err := json.Unmarshal([]byte(data), &check); err != nil {
+		return nil, errors.Wrap(err, "failed to decode health check")
+	}
+
+	return &check, nil
+}
+
+// kill the task of container id. The watcher then restarts the container
+func (c *Module) kill(ns, id string) error {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	return task.Kill(ctx, syscall.SIGKILL)
+}
+
+// prober returns the probe function of the health check of container id
+func (c *Module) prober(namespace, id string, check pkg.HealthCheck) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		client, err := containerd.New(c.containerd)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		ctx = namespaces.WithNamespace(ctx, namespace)
+		container, err := client.LoadContainer(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		task, err := container.Task(ctx, nil)
+		if errdefs.IsNotFound(err) {
+			return errTaskNotRunning
+		} else if err != nil {
+			return err
+		}
+
+		status, err := task.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		if status.Status != containerd.Running {
+			return errTaskNotRunning
+		}
+
+		spec, err := container.Spec(ctx)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case len(check.Exec) != 0:
+			return execProbe(ctx, task, spec, check.Exec)
+		case len(check.TCP) != 0:
+			return tcpProbe(ctx, netnsDialer(spec), check.TCP)
+		default:
+			return httpProbe(ctx, netnsDialer(spec), check.HTTP)
+		}
+	}
+}
+
+// execProbe runs command inside the container, it fails if the
+// command does not exit with 0 before the context is done
+func execProbe(ctx context.Context, task containerd.Task, spec *specs.Spec, command string) error {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return errors.Wrap(err, "invalid health check command")
+	}
+
+	process := *spec.Process
+	process.Terminal = false
+	process.Args = args
+
+	execID := fmt.Sprintf("healthcheck-%d", time.Now().UnixNano())
+	exec, err := task.Exec(ctx, execID, &process, cio.NullIO)
+	if err != nil {
+		return errors.Wrap(err, "failed to create health check process")
+	}
+
+	// the probe context can be done already, so the clean up uses its own context
+	cleanup, cancel := context.WithTimeout(context.Background(), defaultHealthTimeout)
+	defer cancel()
+	if ns, ok := namespaces.Namespace(ctx); ok {
+		cleanup = namespaces.WithNamespace(cleanup, ns)
+	}
+	defer func() {
+		if _, err := exec.Delete(cleanup, containerd.WithProcessKill); err != nil {
+			log.Debug().Err(err).Msg("failed to delete health check process")
+		}
+	}()
+
+	exitC, err := exec.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := exec.Start(ctx); err != nil {
+		return errors.Wrap(err, "failed to start health check process")
+	}
+
+	select {
+	case status := <-exitC:
+		code, _, err := status.Result()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return fmt.Errorf("health check command exited with code %d", code)
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "health check command did not exit in time")
+	}
+}
+
+// netnsDialer returns a dialer that opens connections from
+// the network namespace of the container
+func netnsDialer(spec *specs.Spec) dialFunc {
+	var path string
+	if spec.Linux != nil {
+		for _, namespace := range spec.Linux.Namespaces {
+			if namespace.Type == specs.NetworkNamespace {
+				path = namespace.Path
+			}
+		}
+	}
+
+	return func(ctx context.Context, network, address string) (conn net.Conn, err error) {
+		if len(path) == 0 {
+			return nil, fmt.Errorf("container has no network namespace")
+		}
+
+		netNS, err := ns.GetNS(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get container network namespace")
+		}
+		defer netNS.Close()
+
+		err = netNS.Do(func(_ ns.NetNS) error {
+			// the socket must be created by this thread, so no
+			// parallel dialing
+			dialer := net.Dialer{FallbackDelay: -1}
+			conn, err = dialer.DialContext(ctx, network, address)
+			return err
+		})
+
+		return conn, err
+	}
+}
+
+// tcpProbe fails if no connection can be opened to address
+func tcpProbe(ctx context.Context, dial dialFunc, address string) error {
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %s", address)
+	}
+
+	return conn.Close()
+}
+
+// httpProbe fails if the status of the response to a GET on url is not 2xx or 3xx
+func httpProbe(ctx context.Context, dial dialFunc, url string) error {
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+		},
+		// redirects are a valid response, not followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "invalid health check url")
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %s", url)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check of %s returned status %s", url, response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestHealthCheckDefaults(t *testing.T) {
+	require := require.New(t)
+
+	check := pkg.HealthCheck{TCP: "127.0.0.1:80"}
+	require.NoError(healthCheckDefaults(&check))
+	require.Equal(defaultHealthInterval, check.Interval)
+	require.Equal(defaultHealthTimeout, check.Timeout)
+	require.EqualValues(defaultHealthThreshold, check.Threshold)
+
+	check = pkg.HealthCheck{TCP: "127.0.0.1:80", Interval: time.Minute}
+	require.NoError(healthCheckDefaults(&check))
+	require.Equal(time.Minute, check.Interval)
+
+	require.Error(healthCheckDefaults(&pkg.HealthCheck{}))
+	require.Error(healthCheckDefaults(&pkg.HealthCheck{Exec: "true", TCP: "127.0.0.1:80"}))
+}
+
+func TestHealthMonitor(t *testing.T) {
+	require := require.New(t)
+
+	var (
+		probes   = 0
+		restarts = 0
+		reason   error
+		done     = make(chan struct{})
+	)
+
+	monitor := healthMonitor{
+		check: pkg.HealthCheck{
+			Interval:  time.Millisecond,
+			Timeout:   time.Second,
+			Threshold: 2,
+		},
+		probe: func(ctx context.Context) error {
+			probes++
+			switch {
+			case probes <= 2:
+				// healthy for the first probes
+				return nil
+			case probes%3 == 0:
+				// restarting probes are not counted
+				return errTaskNotRunning
+			}
+			return fmt.Errorf("probe failed")
+		},
+		restart: func() error {
+			restarts++
+			return nil
+		},
+		destroy: func(err error) {
+			reason = err
+			close(done)
+		},
+	}
+
+	go monitor.run(context.Background())
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unhealthy container was never destroyed")
+	}
+
+	require.Equal(failuresBeforeDestroy-1, restarts)
+	require.EqualError(reason, "container is unhealthy: probe failed")
+	require.EqualError(monitor.Err(), "probe failed")
+}
+
+func TestHealthMonitorCancel(t *testing.T) {
+	monitor := healthMonitor{
+		check: pkg.HealthCheck{
+			Interval:  time.Millisecond,
+			Timeout:   time.Second,
+			Threshold: 1,
+		},
+		probe: func(ctx context.Context) error {
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		monitor.run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("monitor did not stop")
+	}
+}
+
+func dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+func TestTCPProbe(t *testing.T) {
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+
+	address := listener.Addr().String()
+	require.NoError(tcpProbe(context.Background(), dial, address))
+
+	listener.Close()
+	require.Error(tcpProbe(context.Background(), dial, address))
+}
+
+func TestHTTPProbe(t *testing.T) {
+	require := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthy":
+			w.WriteHeader(http.StatusOK)
+		case "/redirect":
+			http.Redirect(w, r, "/unhealthy", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	require.NoError(httpProbe(ctx, dial, server.URL+"/healthy"))
+	require.NoError(httpProbe(ctx, dial, server.URL+"/redirect"))
+	require.Error(httpProbe(ctx, dial, server.URL+"/unhealthy"))
+}
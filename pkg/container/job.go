@@ -0,0 +1,105 @@
+package container
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+const (
+	// jobLabel marks the containers running as jobs
+	jobLabel = "zos.job"
+	// jobOutputTail is how much of the output of a job is reported
+	jobOutputTail = 4 * 1024
+)
+
+// jobOutputPath is the file where the stdout and stderr
+// of the job container id are written
+func (c *Module) jobOutputPath(ns, id string) string {
+	return filepath.Join(c.root, "jobs", ns, id+".log")
+}
+
+// isJob checks if the container id runs as a job
+func (c *Module) isJob(ns, id string) (bool, error) {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return labels[jobLabel] == "true", nil
+}
+
+// handleJobExit reports the exit of a job to provisiond. provisiond
+// then decommissions the reservation which deletes the container
+func (c *Module) handleJobExit(ns string, event *events.TaskExit) {
+	log := log.With().
+		Str("namespace", ns).
+		Str("container", event.ContainerID).
+		Uint32("exit-code", event.ExitStatus).Logger()
+
+	log.Info().Msg("job exited")
+
+	output, err := tail(c.jobOutputPath(ns, event.ContainerID), jobOutputTail)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read job output")
+	}
+
+	result := pkg.JobResult{
+		ExitCode: event.ExitStatus,
+		ExitedAt: event.ExitedAt,
+		Output:   output,
+	}
+
+	stub := stubs.NewProvisionStub(c.client)
+	if err := stub.JobExited(event.ContainerID, result); err != nil {
+		log.Error().Err(err).Msg("failed to report job exit")
+	}
+}
+
+// tail returns the last size bytes of the file at path
+func tail(path string, size int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if info.Size() > size {
+		if _, err := file.Seek(info.Size()-size, 0); err != nil {
+			return "", errors.Wrap(err, "failed to seek to the end of the file")
+		}
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
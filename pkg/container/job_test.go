@@ -0,0 +1,30 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "job")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "output.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello world"), 0644))
+
+	output, err := tail(path, 5)
+	require.NoError(t, err)
+	require.Equal(t, "world", output)
+
+	output, err = tail(path, 1024)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", output)
+
+	_, err = tail(filepath.Join(dir, "missing.log"), 5)
+	require.Error(t, err)
+}
@@ -0,0 +1,210 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultFileMaxSize  = 10 * 1024 * 1024 // 10MiB
+	defaultFileMaxFiles = 5
+)
+
+// RotatingFile is a writer to a file that is rotated when it reaches its
+// maximum size. Rotated files are renamed with a numbered suffix, the
+// most recent being .1, and only the last maxFiles files are kept.
+//
+// The files are opened, renamed and removed relative to their directory
+// and symlinks are never followed, so a file in a directory writable by
+// the container can't be used to write elsewhere on the node
+type RotatingFile struct {
+	dir      *os.File
+	name     string
+	maxSize  int64
+	maxFiles int
+
+	m    sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens the file backend config, logs are appended
+// to the file if it already exists
+func NewRotatingFile(config LogsFile) (*RotatingFile, error) {
+	if config.MaxSize == 0 {
+		config.MaxSize = defaultFileMaxSize
+	}
+
+	if config.MaxFiles == 0 {
+		config.MaxFiles = defaultFileMaxFiles
+	}
+
+	var (
+		dir  *os.File
+		name string
+		err  error
+	)
+
+	if config.Root != "" {
+		dir, name, err = openBeneath(config.Root, config.Path)
+	} else {
+		dir, name, err = openDir(config.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f := &RotatingFile{
+		dir:      dir,
+		name:     name,
+		maxSize:  int64(config.MaxSize),
+		maxFiles: int(config.MaxFiles),
+	}
+
+	if err := f.open(); err != nil {
+		dir.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// openDir opens the directory of the absolute path, creating it if needed
+func openDir(path string) (*os.File, string, error) {
+	if !filepath.IsAbs(path) {
+		return nil, "", fmt.Errorf("logs file path '%s' must be absolute", path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, "", err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return dir, filepath.Base(path), nil
+}
+
+// openBeneath opens the directory of path inside root, creating the missing
+// directories. path is relative to root, and none of its components can be
+// a symlink so the directory is always inside root
+func openBeneath(root, path string) (*os.File, string, error) {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return nil, "", fmt.Errorf("logs file path '%s' must be a file inside '%s'", path, root)
+	}
+
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to open '%s'", root)
+	}
+
+	parts := strings.Split(clean, "/")
+	for _, part := range parts[:len(parts)-1] {
+		if err := unix.Mkdirat(fd, part, 0755); err != nil && err != unix.EEXIST {
+			unix.Close(fd)
+			return nil, "", errors.Wrapf(err, "failed to create directory '%s'", part)
+		}
+
+		next, err := unix.Openat(fd, part, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "failed to open directory '%s'", part)
+		}
+		fd = next
+	}
+
+	dir := os.NewFile(uintptr(fd), filepath.Join(root, filepath.Dir(clean)))
+	return dir, parts[len(parts)-1], nil
+}
+
+func (f *RotatingFile) open() error {
+	fd, err := unix.Openat(
+		int(f.dir.Fd()),
+		f.name,
+		unix.O_CREAT|unix.O_WRONLY|unix.O_APPEND|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC,
+		0644,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open logs file '%s'", f.name)
+	}
+
+	file := os.NewFile(uintptr(fd), filepath.Join(f.dir.Name(), f.name))
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return fmt.Errorf("logs file '%s' is not a regular file", file.Name())
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotated returns the name of the rotated file n
+func (f *RotatingFile) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", f.name, n)
+}
+
+// rotate closes the current file, shifts the rotated files
+// and opens a new empty file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	dir := int(f.dir.Fd())
+	if err := unix.Unlinkat(dir, f.rotated(f.maxFiles), 0); err != nil && err != unix.ENOENT {
+		return errors.Wrapf(err, "failed to remove '%s'", f.rotated(f.maxFiles))
+	}
+
+	for n := f.maxFiles - 1; n > 0; n-- {
+		if err := unix.Renameat(dir, f.rotated(n), dir, f.rotated(n+1)); err != nil && err != unix.ENOENT {
+			return errors.Wrapf(err, "failed to rename '%s'", f.rotated(n))
+		}
+	}
+
+	if err := unix.Renameat(dir, f.name, dir, f.rotated(1)); err != nil {
+		return errors.Wrapf(err, "failed to rename '%s'", f.name)
+	}
+
+	return f.open()
+}
+
+// Write p to the file, the file is rotated first if p does not fit in it
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close the file
+func (f *RotatingFile) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.dir.Close()
+	return f.file.Close()
+}
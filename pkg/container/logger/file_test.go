@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatingFile(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "logs-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "logs", "container.log")
+	_, err = NewRotatingFile(LogsFile{Path: "container.log"})
+	require.Error(err)
+
+	file, err := NewRotatingFile(LogsFile{Path: path, MaxSize: 10, MaxFiles: 2})
+	require.NoError(err)
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(err)
+	}
+	require.NoError(file.Close())
+
+	read := func(path string) string {
+		data, err := ioutil.ReadFile(path)
+		require.NoError(err)
+		return string(data)
+	}
+
+	require.Equal("fourth\n", read(path))
+	require.Equal("third\n", read(path+".1"))
+	require.Equal("second\n", read(path+".2"))
+	require.NoFileExists(path + ".3")
+
+	// logs are appended to the existing file
+	file, err = NewRotatingFile(LogsFile{Path: path, MaxSize: 10, MaxFiles: 2})
+	require.NoError(err)
+	_, err = file.Write([]byte("fifth"))
+	require.NoError(err)
+	require.NoError(file.Close())
+	require.Equal("fourth\n", read(path+".1"))
+	require.Equal("fifth", read(path))
+}
+
+func TestRotatingFileBeneath(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "logs-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	volume := filepath.Join(root, "volume")
+	host := filepath.Join(root, "host")
+	require.NoError(os.MkdirAll(volume, 0755))
+	require.NoError(os.MkdirAll(host, 0755))
+	require.NoError(ioutil.WriteFile(filepath.Join(host, "keys"), []byte("secret"), 0644))
+
+	// the container plants symlinks to the host in its volume
+	require.NoError(os.Symlink(host, filepath.Join(volume, "logs")))
+	require.NoError(os.Symlink(filepath.Join(host, "keys"), filepath.Join(volume, "app.log")))
+
+	for _, path := range []string{"logs/keys", "app.log", "../host/keys", "/keys", "."} {
+		_, err := NewRotatingFile(LogsFile{Root: volume, Path: path})
+		require.Error(err, path)
+	}
+
+	file, err := NewRotatingFile(LogsFile{Root: volume, Path: "var/log/app.log", MaxSize: 10, MaxFiles: 2})
+	require.NoError(err)
+
+	// a rotated file replaced by a symlink is renamed, not followed
+	rotated := filepath.Join(volume, "var", "log", "app.log.1")
+	require.NoError(os.Symlink(filepath.Join(host, "keys"), rotated))
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, err := file.Write([]byte(line))
+		require.NoError(err)
+	}
+	require.NoError(file.Close())
+
+	data, err := ioutil.ReadFile(filepath.Join(host, "keys"))
+	require.NoError(err)
+	require.Equal("secret", string(data))
+
+	data, err = ioutil.ReadFile(filepath.Join(volume, "var", "log", "app.log"))
+	require.NoError(err)
+	require.Equal("third\n", string(data))
+}
@@ -2,6 +2,7 @@ package logger
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 )
 
@@ -14,10 +15,12 @@ const FileType = "file"
 // ConsoleType defines console logger type name
 const ConsoleType = "console"
 
-// Logs defines a custom backend with variable settings
+// Logs defines a custom backend with variable settings. Data
+// depends on the type of the backend, see LogsRedis, LogsFile
+// and LogsConsole
 type Logs struct {
-	Type string    `json:"type"`
-	Data LogsRedis `json:"data"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
 }
 
 // LogsRedis defines how to connect a redis logs backend
@@ -29,6 +32,72 @@ type LogsRedis struct {
 	Stderr string `json:"stderr"`
 }
 
+// LogsFile defines a file logs backend. The logs are written to Path,
+// which is rotated when it reaches MaxSize. Rotated files are renamed
+// Path.1, Path.2 ... and only the last MaxFiles files are kept
+type LogsFile struct {
+	// Root is set when the logs are written in a directory the container
+	// can write to, like a volume. Path is then relative to Root and is
+	// resolved without following symlinks, so it can't escape Root
+	Root string `json:"root,omitempty"`
+
+	// Path of the logs file on the node, relative to Root if set
+	Path string `json:"path"`
+
+	// MaxSize in bytes of the logs file, default to 10MiB
+	MaxSize uint64 `json:"max_size,omitempty"`
+
+	// MaxFiles is the number of rotated files kept, default to 5
+	MaxFiles uint `json:"max_files,omitempty"`
+}
+
+// LogsConsole defines a console logs backend. The last Size bytes of
+// the logs are kept in a ring buffer that can be read back from contd
+type LogsConsole struct {
+	// Size of the ring buffer in bytes, default to 64KiB
+	Size uint64 `json:"size,omitempty"`
+}
+
+// New creates a Logs of type with data
+func New(typ string, data interface{}) (Logs, error) {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return Logs{}, err
+	}
+
+	return Logs{Type: typ, Data: bytes}, nil
+}
+
+// Redis decodes the data of a redis backend
+func (l *Logs) Redis() (backend LogsRedis, err error) {
+	err = l.decode(RedisType, &backend)
+	return
+}
+
+// File decodes the data of a file backend
+func (l *Logs) File() (backend LogsFile, err error) {
+	err = l.decode(FileType, &backend)
+	return
+}
+
+// Console decodes the data of a console backend
+func (l *Logs) Console() (backend LogsConsole, err error) {
+	err = l.decode(ConsoleType, &backend)
+	return
+}
+
+func (l *Logs) decode(typ string, data interface{}) error {
+	if l.Type != typ {
+		return fmt.Errorf("logs backend is of type '%s' not '%s'", l.Type, typ)
+	}
+
+	if len(l.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(l.Data, data)
+}
+
 // Serialize dumps logs array into a json file
 func Serialize(path string, logs []Logs) error {
 	data, err := json.Marshal(logs)
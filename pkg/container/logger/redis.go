@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg/container/stats"
+)
+
+const (
+	// maxLineSize is the size after which an incomplete line is published anyway
+	maxLineSize = 64 * 1024
+	// redisTimeout is the timeout to connect to the redis server and
+	// to publish a line, so an unreachable server can't hold the logs
+	redisTimeout = 2 * time.Second
+	// redisRetryDelay is how long the lines are dropped after
+	// failing to connect before connecting again
+	redisRetryDelay = 10 * time.Second
+)
+
+// errRedisDown is returned while the lines are dropped after failing to connect
+var errRedisDown = errors.New("redis server is not reachable")
+
+// Redis is a writer that publishes the lines written to it on a redis
+// channel. The connection is only opened when a line is published, and
+// opened again if it fails, so a server that is down when the container
+// starts receives the logs once it is up
+type Redis struct {
+	host    string
+	channel string
+	conn    redis.Conn
+	retryAt time.Time
+	line    []byte
+}
+
+// NewRedis creates a writer to the redis channel at endpoint (redis://host/channel)
+func NewRedis(endpoint string) (*Redis, error) {
+	host, channel, err := stats.RedisParseURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redis{host: host, channel: channel}, nil
+}
+
+func (r *Redis) connect() error {
+	if r.conn != nil {
+		return nil
+	}
+
+	if time.Now().Before(r.retryAt) {
+		return errRedisDown
+	}
+
+	conn, err := redis.Dial("tcp", r.host,
+		redis.DialConnectTimeout(redisTimeout),
+		redis.DialReadTimeout(redisTimeout),
+		redis.DialWriteTimeout(redisTimeout),
+	)
+	if err != nil {
+		r.retryAt = time.Now().Add(redisRetryDelay)
+		return err
+	}
+
+	r.conn = conn
+	return nil
+}
+
+func (r *Redis) disconnect() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+func (r *Redis) publish(line []byte) error {
+	// a connection that was working might have been closed by
+	// the server, the line is tried again once with a new connection
+	reconnect := r.conn != nil
+	if err := r.connect(); err != nil {
+		return err
+	}
+
+	_, err := r.conn.Do("PUBLISH", r.channel, line)
+	if err == nil {
+		return nil
+	}
+
+	r.disconnect()
+	if !reconnect {
+		r.retryAt = time.Now().Add(redisRetryDelay)
+		return err
+	}
+
+	if err := r.connect(); err != nil {
+		return err
+	}
+
+	if _, err = r.conn.Do("PUBLISH", r.channel, line); err != nil {
+		r.disconnect()
+		r.retryAt = time.Now().Add(redisRetryDelay)
+		return err
+	}
+
+	return nil
+}
+
+// Write publishes the complete lines of p, the end of an
+// incomplete line is kept until the line is complete
+func (r *Redis) Write(p []byte) (int, error) {
+	r.line = append(r.line, p...)
+
+	var err error
+	for {
+		i := bytes.IndexByte(r.line, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := r.line[:i]
+		r.line = r.line[i+1:]
+		if perr := r.publish(line); perr != nil {
+			err = perr
+		}
+	}
+
+	if len(r.line) >= maxLineSize {
+		line := r.line
+		r.line = nil
+		if perr := r.publish(line); perr != nil {
+			err = perr
+		}
+	}
+
+	// don't keep a reference to the published bytes
+	r.line = append([]byte{}, r.line...)
+	return len(p), err
+}
+
+// Close publishes the incomplete line if any and closes the connection
+func (r *Redis) Close() error {
+	if len(r.line) != 0 {
+		_ = r.publish(r.line)
+	}
+
+	r.disconnect()
+	return nil
+}
@@ -0,0 +1,125 @@
+package logger
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// silentListener accepts connections but never answers
+func silentListener(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	return listener
+}
+
+func TestRedisTimeout(t *testing.T) {
+	require := require.New(t)
+
+	listener := silentListener(t)
+	defer listener.Close()
+
+	redis, err := NewRedis("redis://" + listener.Addr().String() + "/stdout")
+	require.NoError(err)
+	defer redis.Close()
+
+	start := time.Now()
+	_, err = redis.Write([]byte("hello\nworld\n"))
+	require.Error(err)
+	require.Less(int64(time.Since(start)), int64(2*redisTimeout+time.Second))
+
+	// the server is not tried again for every line
+	start = time.Now()
+	_, err = redis.Write([]byte("again\n"))
+	require.Equal(errRedisDown, err)
+	require.Less(int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func TestRedisLazyConnect(t *testing.T) {
+	require := require.New(t)
+
+	// the server is down when the backend is created
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	redis, err := NewRedis("redis://" + addr + "/stdout")
+	require.NoError(err)
+	defer redis.Close()
+
+	_, err = redis.Write([]byte("lost\n"))
+	require.Error(err)
+
+	// the server is up again, the lines are published once the retry delay passed
+	listener, err = net.Listen("tcp", addr)
+	require.NoError(err)
+	defer listener.Close()
+
+	published := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 1024)
+		n, _ := conn.Read(buf)
+		published <- string(buf[:n])
+		_, _ = conn.Write([]byte(":1\r\n"))
+	}()
+
+	redis.retryAt = time.Time{}
+	_, err = redis.Write([]byte("hello\n"))
+	require.NoError(err)
+	require.Contains(<-published, "hello")
+}
+
+func TestFanoutBlockedBackend(t *testing.T) {
+	require := require.New(t)
+
+	listener := silentListener(t)
+	defer listener.Close()
+
+	redis, err := NewRedis("redis://" + listener.Addr().String() + "/stdout")
+	require.NoError(err)
+
+	ring := NewRing(1024)
+	blocked := newBackend(RedisType, redis)
+	console := newBackend(ConsoleType, ring)
+	out := fanout{blocked, console}
+
+	// the output of the container is never held by the blocked backend
+	start := time.Now()
+	for i := 0; i < 2*queueSize; i++ {
+		_, err := out.Write([]byte("line\n"))
+		require.NoError(err)
+	}
+	require.Less(int64(time.Since(start)), int64(time.Second))
+
+	console.close(closeTimeout)
+	require.Contains(string(ring.Bytes()), "line\n")
+
+	blocked.close(10 * time.Millisecond)
+}
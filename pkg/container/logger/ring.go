@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+const defaultConsoleSize = 64 * 1024 // 64KiB
+
+// Ring is a writer that keeps the last bytes written to it
+type Ring struct {
+	m    sync.Mutex
+	buf  []byte
+	pos  int
+	full bool
+}
+
+// NewRing creates a ring buffer of size bytes
+func NewRing(size uint64) *Ring {
+	if size == 0 {
+		size = defaultConsoleSize
+	}
+
+	return &Ring{buf: make([]byte, size)}
+}
+
+// Write p to the ring, overwriting the oldest bytes
+func (r *Ring) Write(p []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	n := len(p)
+	if n >= len(r.buf) {
+		// only the end of p fits in the ring
+		copy(r.buf, p[n-len(r.buf):])
+		r.pos = 0
+		r.full = true
+		return n, nil
+	}
+
+	copied := copy(r.buf[r.pos:], p)
+	if copied < n {
+		copy(r.buf, p[copied:])
+		r.full = true
+	}
+
+	r.pos = (r.pos + n) % len(r.buf)
+	if r.pos == 0 {
+		r.full = true
+	}
+
+	return n, nil
+}
+
+// Bytes returns the content of the ring, oldest bytes first
+func (r *Ring) Bytes() []byte {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.full {
+		return append([]byte{}, r.buf[:r.pos]...)
+	}
+
+	data := make([]byte, 0, len(r.buf))
+	data = append(data, r.buf[r.pos:]...)
+	return append(data, r.buf[:r.pos]...)
+}
+
+// Save the content of the ring to path
+func (r *Ring) Save(path string) error {
+	return ioutil.WriteFile(path, r.Bytes(), 0600)
+}
+
+// Load writes the content saved at path to the ring, it
+// does nothing if there is no file at path
+func (r *Ring) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = r.Write(data)
+	return err
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	require := require.New(t)
+
+	ring := NewRing(8)
+	require.Len(ring.Bytes(), 0)
+
+	ring.Write([]byte("abc"))
+	require.Equal("abc", string(ring.Bytes()))
+
+	ring.Write([]byte("defgh"))
+	require.Equal("abcdefgh", string(ring.Bytes()))
+
+	ring.Write([]byte("ij"))
+	require.Equal("cdefghij", string(ring.Bytes()))
+
+	ring.Write([]byte("0123456789"))
+	require.Equal("23456789", string(ring.Bytes()))
+
+	ring.Write([]byte("abcdefg"))
+	require.Equal("9abcdefg", string(ring.Bytes()))
+}
+
+func TestRingSaveLoad(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "ring-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "console")
+
+	ring := NewRing(8)
+	require.NoError(ring.Load(path))
+	ring.Write([]byte("0123456789"))
+	require.NoError(ring.Save(path))
+
+	loaded := NewRing(16)
+	require.NoError(loaded.Load(path))
+	loaded.Write([]byte("ab"))
+	require.Equal("23456789ab", string(loaded.Bytes()))
+}
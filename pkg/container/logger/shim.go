@@ -0,0 +1,322 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/runtime/v2/logging"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ConfigPath returns the path of the logs backends of container id
+func ConfigPath(root, ns, id string) string {
+	return filepath.Join(root, "config", ns, fmt.Sprintf("%s-logs.json", id))
+}
+
+// FilePath returns the default path of the logs file of container id
+func FilePath(root, ns, id string) string {
+	return filepath.Join(root, "logs", ns, fmt.Sprintf("%s.log", id))
+}
+
+// ConsoleSocket returns the path of the socket serving the console of
+// container id. Every connection to the socket receives the content of
+// the console and is then closed
+func ConsoleSocket(root, ns, id string) string {
+	return filepath.Join(root, "logs", ns, fmt.Sprintf("%s.sock", id))
+}
+
+// ConsolePath returns the path where the console of container id is
+// saved when its task exits, so the console survives restarts
+func ConsolePath(root, ns, id string) string {
+	return filepath.Join(root, "logs", ns, fmt.Sprintf("%s.console", id))
+}
+
+const (
+	// queueSize is the number of writes a backend can lag
+	// behind before the output of the container is dropped
+	queueSize = 128
+	// closeTimeout is how long the queued writes of a
+	// backend are waited for when the task exits
+	closeTimeout = 5 * time.Second
+)
+
+// backend is a writer of a fanout. Writes are queued and done in the
+// background, so a slow or blocked backend never blocks the output of
+// the container nor the other backends. Writes are dropped when the
+// queue is full
+type backend struct {
+	name    string
+	writer  io.Writer
+	queue   chan []byte
+	done    chan struct{}
+	dropped uint64
+
+	// m protects queue from being written once closed
+	m      sync.Mutex
+	closed bool
+}
+
+func newBackend(name string, writer io.Writer) *backend {
+	b := &backend{
+		name:   name,
+		writer: writer,
+		queue:  make(chan []byte, queueSize),
+		done:   make(chan struct{}),
+	}
+
+	go b.run()
+	return b
+}
+
+// run writes the queued writes, the writer is closed once the queue is closed
+func (b *backend) run() {
+	defer close(b.done)
+	if closer, ok := b.writer.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	failing := false
+	for p := range b.queue {
+		_, err := b.writer.Write(p)
+		if err != nil && !failing {
+			log.Error().Err(err).Str("backend", b.name).Msg("failed to write logs")
+		}
+		failing = err != nil
+	}
+}
+
+// write queues p, it never blocks
+func (b *backend) write(p []byte) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	select {
+	case b.queue <- append([]byte{}, p...):
+	default:
+		b.dropped++
+		if b.dropped == 1 || b.dropped%1000 == 0 {
+			log.Warn().Str("backend", b.name).Uint64("dropped", b.dropped).Msg("logs backend too slow, dropping logs")
+		}
+	}
+}
+
+// close waits for the queued writes to be done, or for timeout to pass.
+// In the later case, the writer is closed in the background once done
+func (b *backend) close(timeout time.Duration) {
+	b.m.Lock()
+	b.closed = true
+	close(b.queue)
+	b.m.Unlock()
+
+	select {
+	case <-b.done:
+	case <-time.After(timeout):
+		log.Warn().Str("backend", b.name).Msg("logs backend did not write all the queued logs")
+	}
+}
+
+// fanout writes to all its backends, a failing backend does not stop the others
+type fanout []*backend
+
+func (f fanout) Write(p []byte) (int, error) {
+	for _, b := range f {
+		b.write(p)
+	}
+
+	return len(p), nil
+}
+
+// serveConsole sends the content of console to the clients connecting to socket
+func serveConsole(console *Ring, socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen on console socket")
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			if _, err := conn.Write(console.Bytes()); err != nil {
+				log.Debug().Err(err).Msg("failed to send console")
+			}
+			conn.Close()
+		}
+	}()
+
+	return listener, nil
+}
+
+// Run forwards the output of a container task to the logs backends of the
+// container. It implements the logging binary containerd starts for every
+// task, root is the root directory of contd
+func Run(ctx context.Context, root string, config *logging.Config, ready func() error) error {
+	log := log.With().Str("namespace", config.Namespace).Str("container", config.ID).Logger()
+
+	logs, err := Deserialize(ConfigPath(root, config.Namespace, config.ID))
+	if err != nil {
+		// the output is still drained so the container does not block
+		log.Error().Err(err).Msg("failed to read logs backends")
+	}
+
+	var (
+		stdout   fanout
+		stderr   fanout
+		backends []*backend
+		console  *Ring
+		files    = make(map[string]struct{})
+	)
+
+	for _, l := range logs {
+		switch l.Type {
+		case RedisType:
+			cfg, err := l.Redis()
+			if err != nil {
+				log.Error().Err(err).Msg("invalid redis logs backend")
+				continue
+			}
+
+			for _, stream := range []struct {
+				endpoint string
+				fanout   *fanout
+			}{
+				{cfg.Stdout, &stdout},
+				{cfg.Stderr, &stderr},
+			} {
+				if stream.endpoint == "" {
+					continue
+				}
+
+				redis, err := NewRedis(stream.endpoint)
+				if err != nil {
+					log.Error().Err(err).Msg("invalid redis logs endpoint")
+					continue
+				}
+				b := newBackend(RedisType, redis)
+				*stream.fanout = append(*stream.fanout, b)
+				backends = append(backends, b)
+			}
+		case FileType:
+			file, err := l.File()
+			if err != nil {
+				log.Error().Err(err).Msg("invalid file logs backend")
+				continue
+			}
+
+			if file.Path == "" {
+				file.Path = FilePath(root, config.Namespace, config.ID)
+			}
+
+			// two backends writing the same file would rotate it under each other
+			key := filepath.Join(file.Root, file.Path)
+			if _, ok := files[key]; ok {
+				log.Error().Str("path", file.Path).Msg("logs file used by several backends, skipping")
+				continue
+			}
+			files[key] = struct{}{}
+
+			writer, err := NewRotatingFile(file)
+			if err != nil {
+				log.Error().Err(err).Str("path", file.Path).Msg("failed to open logs file")
+				continue
+			}
+			b := newBackend(FileType, writer)
+			stdout = append(stdout, b)
+			stderr = append(stderr, b)
+			backends = append(backends, b)
+		case ConsoleType:
+			if console != nil {
+				// a container has only one console
+				continue
+			}
+
+			cfg, err := l.Console()
+			if err != nil {
+				log.Error().Err(err).Msg("invalid console logs backend")
+				continue
+			}
+
+			console = NewRing(cfg.Size)
+			b := newBackend(ConsoleType, console)
+			stdout = append(stdout, b)
+			stderr = append(stderr, b)
+			backends = append(backends, b)
+		default:
+			log.Error().Str("type", l.Type).Msg("unknown logs backend")
+		}
+	}
+
+	if console != nil {
+		path := ConsolePath(root, config.Namespace, config.ID)
+		if err := console.Load(path); err != nil {
+			log.Error().Err(err).Msg("failed to load previous console")
+		}
+
+		listener, err := serveConsole(console, ConsoleSocket(root, config.Namespace, config.ID))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to serve console")
+		} else {
+			defer listener.Close()
+		}
+
+		defer func() {
+			if err := console.Save(path); err != nil {
+				log.Error().Err(err).Msg("failed to save console")
+			}
+		}()
+	}
+
+	if err := ready(); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stdout, config.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stderr, config.Stderr)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	for _, b := range backends {
+		b.close(closeTimeout)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/runtime/v2/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogsDecode(t *testing.T) {
+	require := require.New(t)
+
+	logs, err := New(FileType, LogsFile{Path: "/logs", MaxFiles: 2})
+	require.NoError(err)
+
+	file, err := logs.File()
+	require.NoError(err)
+	require.Equal(LogsFile{Path: "/logs", MaxFiles: 2}, file)
+
+	_, err = logs.Redis()
+	require.Error(err)
+
+	console, err := (&Logs{Type: ConsoleType}).Console()
+	require.NoError(err)
+	require.Equal(LogsConsole{}, console)
+}
+
+func TestRun(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "logs-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	file, err := New(FileType, LogsFile{})
+	require.NoError(err)
+	console, err := New(ConsoleType, LogsConsole{Size: 1024})
+	require.NoError(err)
+
+	const ns, id = "ns", "container"
+	require.NoError(os.MkdirAll(filepath.Dir(ConfigPath(root, ns, id)), 0755))
+	require.NoError(Serialize(ConfigPath(root, ns, id), []Logs{file, console, {Type: "unknown"}}))
+
+	stdout, stdoutW := io.Pipe()
+	stderr, stderrW := io.Pipe()
+	config := &logging.Config{
+		ID:        id,
+		Namespace: ns,
+		Stdout:    stdout,
+		Stderr:    stderr,
+	}
+
+	ready := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- Run(context.Background(), root, config, func() error {
+			close(ready)
+			return nil
+		})
+	}()
+
+	<-ready
+	_, err = stdoutW.Write([]byte("hello\n"))
+	require.NoError(err)
+
+	// the output is forwarded in the background
+	require.Eventually(func() bool {
+		conn, err := net.Dial("unix", ConsoleSocket(root, ns, id))
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+
+		data, err := ioutil.ReadAll(conn)
+		return err == nil && string(data) == "hello\n"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = stderrW.Write([]byte("world\n"))
+	require.NoError(err)
+	stdoutW.Close()
+	stderrW.Close()
+
+	select {
+	case err := <-done:
+		require.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("logs forwarding did not stop")
+	}
+
+	data, err := ioutil.ReadFile(FilePath(root, ns, id))
+	require.NoError(err)
+	require.Equal("hello\nworld\n", string(data))
+
+	// the console is saved for the next task of the container
+	data, err = ioutil.ReadFile(ConsolePath(root, ns, id))
+	require.NoError(err)
+	require.Equal("hello\nworld\n", string(data))
+	require.NoFileExists(ConsoleSocket(root, ns, id))
+}
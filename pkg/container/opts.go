@@ -3,13 +3,18 @@ package container
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 
 	"path"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/threefoldtech/zos/pkg"
+	"golang.org/x/sys/unix"
 
 	"github.com/containerd/containerd/containers"
 	"github.com/containerd/containerd/oci"
@@ -136,3 +141,207 @@ func cruToLimit(cru uint, totalCPU int) (quota int64, period uint64) {
 	p = math.Ceil(p)
 	return quota, uint64(p)
 }
+
+// sysBlock is where the block devices of the node are listed
+const sysBlock = "/sys/block"
+
+// blockDevice is the major and minor numbers of a disk
+type blockDevice struct {
+	major int64
+	minor int64
+}
+
+// blockDevices lists the disks of the node from the sysfs block directory
+// root. Virtual devices (loop, ram, ...) have no device link and are skipped
+func blockDevices(root string) ([]blockDevice, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list block devices")
+	}
+
+	var devices []blockDevice
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(root, entry.Name(), "device")); err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(root, entry.Name(), "dev"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read device number of %s", entry.Name())
+		}
+
+		var device blockDevice
+		if _, err := fmt.Sscanf(string(data), "%d:%d", &device.major, &device.minor); err != nil {
+			return nil, errors.Wrapf(err, "invalid device number of %s", entry.Name())
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+func withResources(s *oci.Spec) *specs.LinuxResources {
+	if s.Linux.Resources == nil {
+		s.Linux.Resources = &specs.LinuxResources{}
+	}
+
+	return s.Linux.Resources
+}
+
+// WithIOLimits configure the blkio cgroup (io on cgroup v2) to throttle the
+// container on all the disks of the node
+func WithIOLimits(limits pkg.IOLimits) oci.SpecOpts {
+	return func(ctx context.Context, client oci.Client, c *containers.Container, s *oci.Spec) error {
+		if limits == (pkg.IOLimits{}) {
+			return nil
+		}
+
+		devices, err := blockDevices(sysBlock)
+		if err != nil {
+			return err
+		}
+
+		return withIOLimits(limits, devices)(ctx, client, c, s)
+	}
+}
+
+func withIOLimits(limits pkg.IOLimits, devices []blockDevice) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		throttle := func(rate uint64) []specs.LinuxThrottleDevice {
+			if rate == 0 {
+				return nil
+			}
+
+			throttled := make([]specs.LinuxThrottleDevice, 0, len(devices))
+			for _, device := range devices {
+				dev := specs.LinuxThrottleDevice{Rate: rate}
+				dev.Major = device.major
+				dev.Minor = device.minor
+				throttled = append(throttled, dev)
+			}
+
+			return throttled
+		}
+
+		withResources(s).BlockIO = &specs.LinuxBlockIO{
+			ThrottleReadBpsDevice:   throttle(limits.ReadBps),
+			ThrottleWriteBpsDevice:  throttle(limits.WriteBps),
+			ThrottleReadIOPSDevice:  throttle(limits.ReadIOPS),
+			ThrottleWriteIOPSDevice: throttle(limits.WriteIOPS),
+		}
+
+		return nil
+	}
+}
+
+// WithPidsLimit configure the pids cgroup to limit the number of processes of the
+// container. A limit of 0 leaves the number of processes unlimited
+func WithPidsLimit(limit int64) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if limit <= 0 {
+			return nil
+		}
+
+		withResources(s).Pids = &specs.LinuxPids{Limit: limit}
+		return nil
+	}
+}
+
+// WithSwapLimit limits the swap used by the container to swap bytes on top of
+// its memory limit. A nil swap leaves swap unlimited
+func WithSwapLimit(memory uint64, swap *uint64) oci.SpecOpts {
+	return withSwapLimit(memory, swap, swapAccounting)
+}
+
+func withSwapLimit(memory uint64, swap *uint64, accounting func() bool) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if swap == nil {
+			return nil
+		}
+
+		resources := withResources(s)
+		if resources.Memory == nil {
+			resources.Memory = &specs.LinuxMemory{}
+		}
+
+		if !accounting() {
+			// without swap accounting the swap can't be limited, but
+			// the container can still be kept from swapping at all
+			if *swap > 0 {
+				return fmt.Errorf("swap can't be limited, swap accounting is not enabled on the node")
+			}
+
+			var swappiness uint64
+			resources.Memory.Swappiness = &swappiness
+			return nil
+		}
+
+		// the oci swap limit is the limit of memory and swap together
+		total := int64(memory + *swap)
+		resources.Memory.Swap = &total
+
+		return nil
+	}
+}
+
+// swapAccounting returns true if the swap of the containers can be limited,
+// on cgroup v1 it needs the kernel swap accounting
+func swapAccounting() bool {
+	if cgroupUnified() {
+		return true
+	}
+
+	_, err := os.Stat("/sys/fs/cgroup/memory/memory.memsw.limit_in_bytes")
+	return err == nil
+}
+
+// cgroupUnified returns true if the node uses the cgroup v2 unified hierarchy
+func cgroupUnified() bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs("/sys/fs/cgroup", &stat); err != nil {
+		return false
+	}
+
+	return stat.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// limitsFromSpec fills the resource limits of the container from its oci spec
+func limitsFromSpec(resources *specs.LinuxResources, container *pkg.Container) {
+	if resources == nil {
+		return
+	}
+
+	if memory := resources.Memory; memory != nil && memory.Limit != nil {
+		container.Memory = uint64(*memory.Limit)
+		if memory.Swap != nil {
+			swap := uint64(*memory.Swap - *memory.Limit)
+			container.Swap = &swap
+		} else if memory.Swappiness != nil && *memory.Swappiness == 0 {
+			// swap disabled on a node without swap accounting
+			var swap uint64
+			container.Swap = &swap
+		}
+	}
+
+	if resources.Pids != nil {
+		container.Pids = resources.Pids.Limit
+	}
+
+	rate := func(devices []specs.LinuxThrottleDevice) uint64 {
+		// all the disks have the same limit
+		if len(devices) == 0 {
+			return 0
+		}
+		return devices[0].Rate
+	}
+
+	if blkio := resources.BlockIO; blkio != nil {
+		container.IO = pkg.IOLimits{
+			ReadBps:   rate(blkio.ThrottleReadBpsDevice),
+			WriteBps:  rate(blkio.ThrottleWriteBpsDevice),
+			ReadIOPS:  rate(blkio.ThrottleReadIOPSDevice),
+			WriteIOPS: rate(blkio.ThrottleWriteIOPSDevice),
+		}
+	}
+}
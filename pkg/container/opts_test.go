@@ -1,6 +1,17 @@
 package container
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/oci"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
 
 func Test_cruToLimit(t *testing.T) {
 	type args struct {
@@ -62,3 +73,95 @@ func Test_cruToLimit(t *testing.T) {
 		})
 	}
 }
+
+func TestBlockDevices(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "sysblock")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	device := func(name, number string, physical bool) {
+		require.NoError(os.MkdirAll(filepath.Join(root, name), 0755))
+		require.NoError(ioutil.WriteFile(filepath.Join(root, name, "dev"), []byte(number+"\n"), 0644))
+		if physical {
+			require.NoError(os.MkdirAll(filepath.Join(root, name, "device"), 0755))
+		}
+	}
+
+	device("sda", "8:0", true)
+	device("nvme0n1", "259:0", true)
+	device("loop0", "7:0", false)
+
+	devices, err := blockDevices(root)
+	require.NoError(err)
+	require.ElementsMatch([]blockDevice{{8, 0}, {259, 0}}, devices)
+}
+
+func TestResourceLimits(t *testing.T) {
+	require := require.New(t)
+
+	spec := &oci.Spec{Linux: &specs.Linux{}}
+	limits := pkg.IOLimits{ReadBps: 1024, WriteIOPS: 100}
+	devices := []blockDevice{{8, 0}, {8, 16}}
+
+	ctx := context.Background()
+	require.NoError(WithMemoryLimit(1024)(ctx, nil, nil, spec))
+	require.NoError(withIOLimits(limits, devices)(ctx, nil, nil, spec))
+	require.NoError(WithPidsLimit(10)(ctx, nil, nil, spec))
+
+	blkio := spec.Linux.Resources.BlockIO
+	require.Len(blkio.ThrottleReadBpsDevice, 2)
+	require.EqualValues(8, blkio.ThrottleReadBpsDevice[1].Major)
+	require.EqualValues(16, blkio.ThrottleReadBpsDevice[1].Minor)
+	require.Len(blkio.ThrottleWriteIOPSDevice, 2)
+	require.Len(blkio.ThrottleWriteBpsDevice, 0)
+
+	// swap is not limited unless asked
+	require.NoError(WithSwapLimit(1024, nil)(ctx, nil, nil, spec))
+	require.Nil(spec.Linux.Resources.Memory.Swap)
+
+	var container pkg.Container
+	limitsFromSpec(spec.Linux.Resources, &container)
+	require.Equal(limits, container.IO)
+	require.EqualValues(10, container.Pids)
+	require.EqualValues(1024, container.Memory)
+	require.Nil(container.Swap)
+
+	// the oci swap limit includes the memory
+	total := int64(1024 + 512)
+	spec.Linux.Resources.Memory.Swap = &total
+	limitsFromSpec(spec.Linux.Resources, &container)
+	require.NotNil(container.Swap)
+	require.EqualValues(512, *container.Swap)
+
+	// the number of processes is not limited unless asked
+	spec = &oci.Spec{Linux: &specs.Linux{}}
+	require.NoError(WithPidsLimit(0)(ctx, nil, nil, spec))
+	require.Nil(spec.Linux.Resources)
+}
+
+func TestSwapWithoutAccounting(t *testing.T) {
+	require := require.New(t)
+
+	ctx := context.Background()
+	noAccounting := func() bool { return false }
+
+	// swap can still be disabled
+	var none uint64
+	spec := &oci.Spec{Linux: &specs.Linux{}}
+	require.NoError(WithMemoryLimit(1024)(ctx, nil, nil, spec))
+	require.NoError(withSwapLimit(1024, &none, noAccounting)(ctx, nil, nil, spec))
+	require.Nil(spec.Linux.Resources.Memory.Swap)
+	require.NotNil(spec.Linux.Resources.Memory.Swappiness)
+	require.EqualValues(0, *spec.Linux.Resources.Memory.Swappiness)
+
+	var container pkg.Container
+	limitsFromSpec(spec.Linux.Resources, &container)
+	require.NotNil(container.Swap)
+	require.EqualValues(0, *container.Swap)
+
+	// but not limited
+	swap := uint64(512)
+	require.Error(withSwapLimit(1024, &swap, noAccounting)(ctx, nil, nil, spec))
+}
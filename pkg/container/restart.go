@@ -0,0 +1,193 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+const (
+	// restartLabel holds the restart policy of a container
+	restartLabel = "zos.restart"
+	// restartStateLabel holds the restarts state of a container
+	restartStateLabel = "zos.restart.state"
+
+	// defaultMaxRetries is the number of restarts in a row of a
+	// failing container with the on-exit and on-failure policies
+	defaultMaxRetries = failuresBeforeDestroy - 1
+	// maxRestartDelay caps the exponential backoff between restarts
+	maxRestartDelay = 5 * time.Minute
+	// restartResetAfter is how long a container must run for its
+	// restarts in a row and backoff to be reset
+	restartResetAfter = time.Minute
+)
+
+// restartState is the restarts state of a container stored in its labels
+type restartState struct {
+	pkg.RestartStatus
+	// Retries is the number of restarts in a row
+	Retries uint
+	// StartedAt is when the container was last (re)started
+	StartedAt time.Time
+}
+
+// restartPolicyDefaults validates policy and sets the default values
+func restartPolicyDefaults(policy *pkg.RestartPolicy) error {
+	switch policy.Mode {
+	case "":
+		policy.Mode = pkg.RestartOnExit
+	case pkg.RestartOnExit, pkg.RestartOnFailure, pkg.RestartAlways, pkg.RestartNever:
+	default:
+		return fmt.Errorf("invalid restart policy '%s'", policy.Mode)
+	}
+
+	if (policy.Mode == pkg.RestartOnExit || policy.Mode == pkg.RestartOnFailure) && policy.MaxRetries == 0 {
+		policy.MaxRetries = defaultMaxRetries
+	}
+
+	return nil
+}
+
+// restartBackoff returns the exponential backoff before
+// restarting a container that restarted retries times in a row
+func restartBackoff(retries uint) time.Duration {
+	delay := restartDelay
+	for i := uint(1); i < retries && delay < maxRestartDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+
+	return delay
+}
+
+// exited records the exit of the container entrypoint in state, and decides
+// whether the container must be restarted and after which delay. A non nil
+// error means the container failed too many times and must be deleted
+func (s *restartState) exited(policy pkg.RestartPolicy, code uint32, at time.Time) (restart bool, delay time.Duration, err error) {
+	s.Exited = true
+	s.ExitCode = code
+	s.ExitedAt = at
+
+	if at.Sub(s.StartedAt) >= restartResetAfter {
+		// the container ran long enough, it is not crashing in a loop
+		s.Retries = 0
+	}
+
+	switch policy.Mode {
+	case pkg.RestartNever:
+		return false, 0, nil
+	case pkg.RestartAlways:
+	default:
+		if policy.Mode == pkg.RestartOnFailure && code == 0 {
+			return false, 0, nil
+		}
+
+		if s.Retries >= policy.MaxRetries {
+			return false, 0, fmt.Errorf("deleting container due to so many crashes")
+		}
+	}
+
+	s.Retries++
+	return true, restartBackoff(s.Retries), nil
+}
+
+// restarted records that the container was restarted at
+func (s *restartState) restarted(at time.Time) {
+	s.Count++
+	s.StartedAt = at
+}
+
+// restartFromLabels decodes the restart policy and state stored in
+// the container labels. Containers created without a policy use the
+// default policy, which is how containers were always restarted
+func restartFromLabels(labels map[string]string) (policy pkg.RestartPolicy, state restartState, err error) {
+	if data, ok := labels[restartLabel]; ok {
+		if err := json.Unmarshal([]byte(data), &policy); err != nil {
+			return policy, state, errors.Wrap(err, "failed to decode restart policy")
+		}
+	}
+
+	if err := restartPolicyDefaults(&policy); err != nil {
+		return policy, state, err
+	}
+
+	if data, ok := labels[restartStateLabel]; ok {
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			return policy, state, errors.Wrap(err, "failed to decode restart state")
+		}
+	}
+
+	return policy, state, nil
+}
+
+// restartLabels returns the labels holding the restart policy and state
+func restartLabels(policy pkg.RestartPolicy, state restartState) (map[string]string, error) {
+	p, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		restartLabel:      string(p),
+		restartStateLabel: string(s),
+	}, nil
+}
+
+// loadRestart returns the restart policy and state of container id
+func (c *Module) loadRestart(ns, id string) (pkg.RestartPolicy, restartState, error) {
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return pkg.RestartPolicy{}, restartState{}, err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return pkg.RestartPolicy{}, restartState{}, err
+	}
+
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return pkg.RestartPolicy{}, restartState{}, err
+	}
+
+	return restartFromLabels(labels)
+}
+
+// saveRestartState stores the restart state of container id in its labels
+func (c *Module) saveRestartState(ns, id string, state restartState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	client, err := containerd.New(c.containerd)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+	container, err := client.LoadContainer(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = container.SetLabels(ctx, map[string]string{restartStateLabel: string(data)})
+	return err
+}
@@ -0,0 +1,138 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestRestartPolicyDefaults(t *testing.T) {
+	require := require.New(t)
+
+	var policy pkg.RestartPolicy
+	require.NoError(restartPolicyDefaults(&policy))
+	require.Equal(pkg.RestartOnExit, policy.Mode)
+	require.EqualValues(defaultMaxRetries, policy.MaxRetries)
+
+	policy = pkg.RestartPolicy{Mode: pkg.RestartOnFailure}
+	require.NoError(restartPolicyDefaults(&policy))
+	require.EqualValues(defaultMaxRetries, policy.MaxRetries)
+
+	policy = pkg.RestartPolicy{Mode: pkg.RestartOnFailure, MaxRetries: 10}
+	require.NoError(restartPolicyDefaults(&policy))
+	require.EqualValues(10, policy.MaxRetries)
+
+	policy = pkg.RestartPolicy{Mode: pkg.RestartAlways}
+	require.NoError(restartPolicyDefaults(&policy))
+	require.EqualValues(0, policy.MaxRetries)
+
+	require.Error(restartPolicyDefaults(&pkg.RestartPolicy{Mode: "sometimes"}))
+}
+
+func TestRestartBackoff(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(restartDelay, restartBackoff(1))
+	require.Equal(2*restartDelay, restartBackoff(2))
+	require.Equal(4*restartDelay, restartBackoff(3))
+	require.Equal(maxRestartDelay, restartBackoff(100))
+}
+
+func TestRestartOnFailure(t *testing.T) {
+	require := require.New(t)
+
+	policy := pkg.RestartPolicy{Mode: pkg.RestartOnFailure, MaxRetries: 2}
+	now := time.Now()
+	state := restartState{StartedAt: now}
+
+	restart, delay, err := state.exited(policy, 1, now.Add(time.Second))
+	require.NoError(err)
+	require.True(restart)
+	require.Equal(restartDelay, delay)
+	state.restarted(now.Add(2 * time.Second))
+
+	restart, delay, err = state.exited(policy, 1, now.Add(3*time.Second))
+	require.NoError(err)
+	require.True(restart)
+	require.Equal(2*restartDelay, delay)
+	state.restarted(now.Add(4 * time.Second))
+
+	// too many crashes in a row
+	_, _, err = state.exited(policy, 1, now.Add(5*time.Second))
+	require.Error(err)
+	require.EqualValues(2, state.Count)
+	require.True(state.Exited)
+	require.EqualValues(1, state.ExitCode)
+
+	// a container that ran long enough is restarted again
+	restart, delay, err = state.exited(policy, 1, now.Add(time.Hour))
+	require.NoError(err)
+	require.True(restart)
+	require.Equal(restartDelay, delay)
+
+	// a successful exit is not restarted
+	restart, _, err = state.exited(policy, 0, now.Add(time.Hour))
+	require.NoError(err)
+	require.False(restart)
+	require.EqualValues(0, state.ExitCode)
+}
+
+func TestRestartOnExit(t *testing.T) {
+	require := require.New(t)
+
+	// the default policy restarts on any exit and gives up after 3 restarts in a row
+	var policy pkg.RestartPolicy
+	require.NoError(restartPolicyDefaults(&policy))
+
+	now := time.Now()
+	state := restartState{StartedAt: now}
+	for i := 0; i < 3; i++ {
+		restart, _, err := state.exited(policy, 0, now)
+		require.NoError(err)
+		require.True(restart)
+		state.restarted(now)
+	}
+
+	_, _, err := state.exited(policy, 0, now)
+	require.Error(err)
+}
+
+func TestRestartAlwaysAndNever(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	state := restartState{StartedAt: now}
+	always := pkg.RestartPolicy{Mode: pkg.RestartAlways}
+	for i := 0; i < 10; i++ {
+		restart, _, err := state.exited(always, uint32(i%2), now)
+		require.NoError(err)
+		require.True(restart)
+		state.restarted(now)
+	}
+	require.EqualValues(10, state.Count)
+
+	never := pkg.RestartPolicy{Mode: pkg.RestartNever}
+	restart, _, err := state.exited(never, 1, now)
+	require.NoError(err)
+	require.False(restart)
+}
+
+func TestRestartFromLabels(t *testing.T) {
+	require := require.New(t)
+
+	policy, state, err := restartFromLabels(map[string]string{})
+	require.NoError(err)
+	require.Equal(pkg.RestartOnExit, policy.Mode)
+	require.False(state.Exited)
+
+	state.restarted(time.Now())
+	labels, err := restartLabels(pkg.RestartPolicy{Mode: pkg.RestartNever}, state)
+	require.NoError(err)
+
+	policy, loaded, err := restartFromLabels(labels)
+	require.NoError(err)
+	require.Equal(pkg.RestartNever, policy.Mode)
+	require.EqualValues(1, loaded.Count)
+}
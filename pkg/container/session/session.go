@@ -0,0 +1,163 @@
+// Package session implements the protocol used to stream the stdin, stdout
+// and stderr of a process executed inside a container (see
+// pkg.ContainerModule.Exec) over a unix socket.
+//
+// Every message is a frame made of a 1 byte stream, the 4 bytes big
+// endian size of the payload, and the payload:
+//
+//	Stdin  client -> contd  data for the process stdin, an empty payload closes stdin
+//	Resize client -> contd  width and height of the terminal as 2 big endian uint16
+//	Stdout contd -> client  output of the process
+//	Stderr contd -> client  error output of the process, not used with a terminal
+//	Exit   contd -> client  exit code of the process as a big endian uint32, last frame
+package session
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Stream is the stream a frame belongs to
+type Stream byte
+
+// The streams of a session
+const (
+	Stdin Stream = iota
+	Stdout
+	Stderr
+	Resize
+	Exit
+)
+
+// MaxFrameSize is the maximum size of the payload of a frame
+const MaxFrameSize = 64 * 1024
+
+// WriteFrame writes data as a single frame of stream
+func WriteFrame(w io.Writer, stream Stream, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes is too big", len(data))
+	}
+
+	frame := make([]byte, 5+len(data))
+	frame[0] = byte(stream)
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(data)))
+	copy(frame[5:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads the next frame
+func ReadFrame(r io.Reader) (Stream, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > MaxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too big", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, errors.Wrap(err, "failed to read frame payload")
+	}
+
+	return Stream(header[0]), data, nil
+}
+
+// Client is a connection to the session of a process
+type Client struct {
+	conn net.Conn
+	m    sync.Mutex
+}
+
+// Dial connects to the session served on socket. The process
+// starts when the first client connects to its session
+func Dial(socket string) (*Client, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) write(stream Stream, data []byte) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return WriteFrame(c.conn, stream, data)
+}
+
+// Write sends p to the process stdin
+func (c *Client) Write(p []byte) (int, error) {
+	for written := 0; written < len(p); {
+		size := len(p) - written
+		if size > MaxFrameSize {
+			size = MaxFrameSize
+		}
+
+		if err := c.write(Stdin, p[written:written+size]); err != nil {
+			return written, err
+		}
+		written += size
+	}
+
+	return len(p), nil
+}
+
+// CloseStdin closes the process stdin
+func (c *Client) CloseStdin() error {
+	return c.write(Stdin, nil)
+}
+
+// Resize changes the size of the process terminal
+func (c *Client) Resize(width, height uint16) error {
+	var data [4]byte
+	binary.BigEndian.PutUint16(data[0:2], width)
+	binary.BigEndian.PutUint16(data[2:4], height)
+
+	return c.write(Resize, data[:])
+}
+
+// Wait copies the output of the process to stdout and stderr
+// until it exits and returns its exit code
+func (c *Client) Wait(stdout, stderr io.Writer) (uint32, error) {
+	for {
+		stream, data, err := ReadFrame(c.conn)
+		if err == io.EOF {
+			return 0, errors.Wrap(io.ErrUnexpectedEOF, "session closed before the process exited")
+		} else if err != nil {
+			return 0, err
+		}
+
+		switch stream {
+		case Stdout:
+			_, err = stdout.Write(data)
+		case Stderr:
+			_, err = stderr.Write(data)
+		case Exit:
+			if len(data) != 4 {
+				return 0, fmt.Errorf("invalid exit frame")
+			}
+			return binary.BigEndian.Uint32(data), nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close the connection to the session. The process keeps running
+// and another client can attach to it
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
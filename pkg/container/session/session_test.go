@@ -0,0 +1,97 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrame(t *testing.T) {
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	require.NoError(WriteFrame(&buf, Stdout, []byte("hello")))
+	require.NoError(WriteFrame(&buf, Stdin, nil))
+	require.Error(WriteFrame(&buf, Stdout, make([]byte, MaxFrameSize+1)))
+
+	stream, data, err := ReadFrame(&buf)
+	require.NoError(err)
+	require.Equal(Stdout, stream)
+	require.Equal([]byte("hello"), data)
+
+	stream, data, err = ReadFrame(&buf)
+	require.NoError(err)
+	require.Equal(Stdin, stream)
+	require.Len(data, 0)
+
+	_, _, err = ReadFrame(&buf)
+	require.Equal(io.EOF, err)
+}
+
+func TestClient(t *testing.T) {
+	require := require.New(t)
+
+	conn, server := net.Pipe()
+	client := &Client{conn: conn}
+	defer client.Close()
+
+	// fake contd side of the session: echo stdin on stdout
+	// and exit with the size of the terminal width
+	go func() {
+		defer server.Close()
+		var width uint16
+		for {
+			stream, data, err := ReadFrame(server)
+			if err != nil {
+				return
+			}
+
+			switch stream {
+			case Stdin:
+				if len(data) == 0 {
+					_ = WriteFrame(server, Stderr, []byte("closed"))
+					var code [4]byte
+					binary.BigEndian.PutUint32(code[:], uint32(width))
+					_ = WriteFrame(server, Exit, code[:])
+					return
+				}
+				_ = WriteFrame(server, Stdout, data)
+			case Resize:
+				width = binary.BigEndian.Uint16(data[0:2])
+			}
+		}
+	}()
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan struct{})
+	var code uint32
+	var err error
+	go func() {
+		code, err = client.Wait(&stdout, &stderr)
+		close(done)
+	}()
+
+	_, werr := client.Write([]byte("hello"))
+	require.NoError(werr)
+	require.NoError(client.Resize(80, 24))
+	require.NoError(client.CloseStdin())
+
+	<-done
+	require.NoError(err)
+	require.EqualValues(80, code)
+	require.Equal("hello", stdout.String())
+	require.Equal("closed", stderr.String())
+}
+
+func TestClientClosed(t *testing.T) {
+	conn, server := net.Pipe()
+	client := &Client{conn: conn}
+	server.Close()
+
+	_, err := client.Wait(&bytes.Buffer{}, &bytes.Buffer{})
+	require.Error(t, err)
+}
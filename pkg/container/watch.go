@@ -7,8 +7,9 @@ import (
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/typeurl"
-	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg/stubs"
 )
@@ -18,15 +19,16 @@ func (c *Module) handlerEventTaskExit(ns string, event *events.TaskExit) {
 		Str("namespace", ns).
 		Str("container", event.ContainerID).Logger()
 
-	log.Debug().Msg("task exited")
-
-	marker, ok := c.failures.Get(event.ContainerID)
-	if !ok {
-		// no previous value. so this is the first failure
-		c.failures.Set(event.ContainerID, int(0), cache.DefaultExpiration)
+	if event.ID != event.ContainerID {
+		// a process executed inside the container exited (like a
+		// health check), the container itself is still running
+		log.Debug().Str("process", event.ID).Msg("exec process exited")
+		return
 	}
 
-	if marker == permanent {
+	log.Debug().Msg("task exited")
+
+	if marker, ok := c.failures.Get(event.ContainerID); ok && marker == permanent {
 		// if the marker is permanent. it means that this container
 		// is being deleted. we don't need to take any more action here
 		// (don't try to restart or delete)
@@ -34,23 +36,77 @@ func (c *Module) handlerEventTaskExit(ns string, event *events.TaskExit) {
 		return
 	}
 
-	count, err := c.failures.IncrementInt(event.ContainerID, 1)
+	job, err := c.isJob(ns, event.ContainerID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check if container is a job")
+	} else if job {
+		// jobs are never restarted
+		c.handleJobExit(ns, event)
+		return
+	}
+
+	policy, state, err := c.loadRestart(ns, event.ContainerID)
 	if err != nil {
-		// this should never happen because we make sure value
-		// is set
-		log.Error().Err(err).Msg("error while checking number of failures")
+		log.Error().Err(err).Msg("failed to load container restart policy")
 		return
 	}
 
-	log.Debug().Int("count", count).Msg("recorded stops")
+	restart, delay, reason := state.exited(policy, event.ExitStatus, event.ExitedAt)
+	log.Debug().
+		Str("policy", string(policy.Mode)).
+		Uint32("exit-code", event.ExitStatus).
+		Uint("retries", state.Retries).
+		Msg("recorded stops")
+
+	if err := c.saveRestartState(ns, event.ContainerID, state); err != nil {
+		log.Error().Err(err).Msg("failed to save container restart state")
+	}
+
+	if restart {
+		log.Debug().Str("delay", delay.String()).Msg("trying to restart the container")
+		<-time.After(delay)
+
+		if _, ok := c.failures.Get(event.ContainerID); ok {
+			log.Debug().Msg("container deleted while waiting to restart")
+			return
+		}
+
+		state.restarted(time.Now())
+		if err := c.saveRestartState(ns, event.ContainerID, state); err != nil {
+			log.Error().Err(err).Msg("failed to save container restart state")
+		}
 
-	var reason error
-	if count < failuresBeforeDestroy {
-		log.Debug().Msg("trying to restart the container")
-		<-time.After(restartDelay) // wait for 2 seconds
 		reason = c.start(ns, event.ContainerID)
+		if errdefs.IsNotFound(reason) {
+			log.Debug().Msg("container deleted while waiting to restart")
+			return
+		}
 	} else {
-		reason = fmt.Errorf("deleting container due to so many crashes")
+		// the container stays stopped, so its health is not monitored
+		// anymore, and the owner is told the container is not running
+		healthErr := c.healthError(event.ContainerID)
+		c.stopHealthCheck(event.ContainerID)
+
+		if reason == nil {
+			// the policy leaves the container stopped, it is kept with its
+			// restart state so it can still be inspected
+			stopped := fmt.Errorf("container exited with code %d, the %s restart policy does not restart it", event.ExitStatus, policy.Mode)
+			if healthErr != nil {
+				stopped = errors.Wrapf(healthErr, "unhealthy container stopped, the %s restart policy does not restart it", policy.Mode)
+			}
+
+			log.Debug().Err(stopped).Msg("container left stopped")
+
+			stub := stubs.NewProvisionStub(c.client)
+			if err := stub.WorkloadStopped(event.ContainerID, stopped.Error()); err != nil {
+				log.Error().Err(err).Msg("failed to report stopped container")
+			}
+			return
+		}
+
+		if healthErr != nil {
+			reason = errors.Wrap(healthErr, "deleting unhealthy container due to so many restarts")
+		}
 	}
 
 	if reason != nil {
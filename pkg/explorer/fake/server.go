@@ -0,0 +1,709 @@
+// Package fake implements a stand-in for the tfexplorer that keeps
+// its state in memory. It serves the endpoints of the explorer API
+// used by the node daemons, so the node stack can run without
+// network access to a real explorer.
+package fake
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/threefoldtech/zos/pkg/crypto"
+)
+
+// Prefix is the path under which the explorer API is served. The
+// explorer client always talks to this path
+const Prefix = "/api/v1"
+
+// Server is an in memory explorer.
+//
+// The following endpoints of the explorer API are available:
+//
+//	POST   /api/v1/nodes                                        register a node
+//	GET    /api/v1/nodes                                        list the nodes
+//	GET    /api/v1/nodes/{id}                                   get a node
+//	POST   /api/v1/nodes/{id}/interfaces                        set the node interfaces
+//	POST   /api/v1/nodes/{id}/ports                             set the node wireguard ports
+//	POST   /api/v1/nodes/{id}/configure_public                  set the node public config
+//	POST   /api/v1/nodes/{id}/configure_free                    set the node free to use flag
+//	POST   /api/v1/nodes/{id}/capacity                          set the node total capacity
+//	POST   /api/v1/nodes/{id}/uptime                            set the node uptime
+//	POST   /api/v1/nodes/{id}/used_resources                    set the node used resources
+//	GET    /api/v1/farms/{id}                                   get a farm
+//	GET    /api/v1/users/{id}                                   get a user
+//	GET    /api/v1/reservations/workloads/{id}                  get a workload
+//	GET    /api/v1/reservations/nodes/{node}/workloads          poll the workloads of a node
+//	GET    /api/v1/reservations/nodes/workloads/{gwid}          get a workload by its global id
+//	PUT    /api/v1/reservations/nodes/{node}/workloads/{gwid}   set the result of a workload
+//	DELETE /api/v1/reservations/nodes/{node}/workloads/{gwid}   mark a workload deleted
+//
+// The state is seeded with the following endpoints, which the real
+// explorer doesn't have:
+//
+//	POST   /fake/farms           create a farm
+//	POST   /fake/users           create a user
+//	POST   /fake/workloads       create a workload, ready to be deployed
+//	DELETE /fake/workloads/{id}  mark a workload to be deleted
+//
+// Like the explorer, workloads must be signed by their customer: the
+// signature is checked against the public key of the user when the
+// workload is created, then the epoch of the workload is set to the
+// creation time. Nodes rely on this check and don't verify the
+// signature of the workloads again. Other signatures are not checked
+type Server struct {
+	m         sync.Mutex
+	nodes     map[string]*directory.Node
+	farms     map[schema.ID]directory.Farm
+	users     map[schema.ID]phonebook.User
+	workloads map[schema.ID]workloads.Workloader
+
+	lastFarm     schema.ID
+	lastUser     schema.ID
+	lastWorkload schema.ID
+}
+
+// NewServer creates a new empty explorer
+func NewServer() *Server {
+	return &Server{
+		nodes:     make(map[string]*directory.Node),
+		farms:     make(map[schema.ID]directory.Farm),
+		users:     make(map[schema.ID]phonebook.User),
+		workloads: make(map[schema.ID]workloads.Workloader),
+	}
+}
+
+// AddFarm adds a farm and returns its id. The id of the
+// farm is kept if set, otherwise the next free id is used
+func (s *Server) AddFarm(farm directory.Farm) schema.ID {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if farm.ID == 0 {
+		farm.ID = s.lastFarm + 1
+	}
+	if farm.ID > s.lastFarm {
+		s.lastFarm = farm.ID
+	}
+
+	s.farms[farm.ID] = farm
+	return farm.ID
+}
+
+// AddUser adds a user and returns its id. The id of the
+// user is kept if set, otherwise the next free id is used
+func (s *Server) AddUser(user phonebook.User) schema.ID {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if user.ID == 0 {
+		user.ID = s.lastUser + 1
+	}
+	if user.ID > s.lastUser {
+		s.lastUser = user.ID
+	}
+
+	user.Signature = ""
+	s.users[user.ID] = user
+	return user.ID
+}
+
+// AddWorkload adds a workload ready to be deployed by its node
+// and returns its id. Workloads always get the next free id so
+// the nodes polling the explorer see them
+func (s *Server) AddWorkload(wl workloads.Workloader) (schema.ID, error) {
+	if len(wl.GetNodeID()) == 0 {
+		return 0, fmt.Errorf("workload node id is required")
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.verify(wl); err != nil {
+		return 0, errors.Wrap(err, "failed to verify customer signature")
+	}
+
+	s.lastWorkload++
+	wl.SetID(s.lastWorkload)
+	wl.SetNextAction(workloads.NextActionDeploy)
+	// the explorer overwrites the epoch signed by the customer
+	wl.SetEpoch(schema.Date{Time: time.Now()})
+
+	s.workloads[wl.GetID()] = wl
+	return wl.GetID(), nil
+}
+
+// verify checks the signature of the customer of wl
+// the same way the explorer does
+func (s *Server) verify(wl workloads.Workloader) error {
+	user, ok := s.users[schema.ID(wl.GetCustomerTid())]
+	if !ok {
+		return fmt.Errorf("user %d not found", wl.GetCustomerTid())
+	}
+
+	key, err := crypto.KeyFromHex(user.Pubkey)
+	if err != nil {
+		return errors.Wrapf(err, "invalid public key of user %d", user.ID)
+	}
+
+	signature, err := hex.DecodeString(wl.GetCustomerSignature())
+	if err != nil {
+		return errors.Wrap(err, "invalid signature format, expecting hex encoded string")
+	}
+
+	challenge, err := wl.SignatureChallenge()
+	if err != nil {
+		return err
+	}
+
+	msg := sha256.Sum256(challenge)
+	return crypto.Verify(key, msg[:], signature)
+}
+
+// DeleteWorkload marks the workload id to be deleted by its node
+func (s *Server) DeleteWorkload(id schema.ID) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	wl, ok := s.workloads[id]
+	if !ok {
+		return fmt.Errorf("workload %d not found", id)
+	}
+
+	if wl.GetNextAction() != workloads.NextActionDeleted {
+		wl.SetNextAction(workloads.NextActionDelete)
+	}
+
+	return nil
+}
+
+// Node returns the node registered with id
+func (s *Server) Node(id string) (directory.Node, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	node, ok := s.nodes[id]
+	if !ok {
+		return directory.Node{}, false
+	}
+
+	return *node, true
+}
+
+// Workload returns the workload id with its result and next action
+func (s *Server) Workload(id schema.ID) (workloads.Workloader, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	wl, ok := s.workloads[id]
+	return wl, ok
+}
+
+// Handler returns the http handler of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Prefix+"/nodes", s.nodeRegister)
+	mux.HandleFunc(Prefix+"/nodes/", s.node)
+	mux.HandleFunc(Prefix+"/farms/", s.farm)
+	mux.HandleFunc(Prefix+"/users/", s.user)
+	mux.HandleFunc(Prefix+"/reservations/", s.reservations)
+
+	mux.HandleFunc("/fake/farms", s.fakeFarm)
+	mux.HandleFunc("/fake/users", s.fakeUser)
+	mux.HandleFunc("/fake/workloads", s.fakeWorkload)
+	mux.HandleFunc("/fake/workloads/", s.fakeWorkloadDelete)
+
+	return logged(mux)
+}
+
+func (s *Server) nodeRegister(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet:
+		s.nodeList(w, r)
+		return
+	default:
+		writeError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var node directory.Node
+	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+		writeError(w, errors.Wrap(err, "failed to decode node"), http.StatusBadRequest)
+		return
+	}
+
+	if len(node.NodeId) == 0 {
+		writeError(w, fmt.Errorf("node_id is required"), http.StatusBadRequest)
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.farms[schema.ID(node.FarmId)]; !ok {
+		writeError(w, fmt.Errorf("farm %d not found", node.FarmId), http.StatusNotFound)
+		return
+	}
+
+	now := schema.Date{Time: time.Now()}
+	if old, ok := s.nodes[node.NodeId]; ok {
+		// like the explorer, a registration only updates
+		// what the node reports about itself
+		old.FarmId = node.FarmId
+		old.OsVersion = node.OsVersion
+		old.Location = node.Location
+		old.PublicKeyHex = node.PublicKeyHex
+		old.Updated = now
+	} else {
+		node.ID = schema.ID(len(s.nodes) + 1)
+		node.Created = now
+		node.Updated = now
+		s.nodes[node.NodeId] = &node
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) nodeList(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	nodes := make([]directory.Node, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		nodes = append(nodes, *node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+func (s *Server) node(w http.ResponseWriter, r *http.Request) {
+	parts := split(r.URL.Path, Prefix+"/nodes/")
+	if len(parts) == 1 && r.Method == http.MethodGet {
+		node, ok := s.Node(parts[0])
+		if !ok {
+			writeError(w, fmt.Errorf("node %s not found", parts[0]), http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, node)
+		return
+	}
+
+	if len(parts) != 2 || r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("not found"), http.StatusNotFound)
+		return
+	}
+
+	var update func(node *directory.Node, body []byte) error
+	status := http.StatusOK
+
+	switch parts[1] {
+	case "interfaces":
+		status = http.StatusCreated
+		update = func(node *directory.Node, body []byte) error {
+			return json.Unmarshal(body, &node.Ifaces)
+		}
+	case "ports":
+		update = func(node *directory.Node, body []byte) error {
+			var input struct {
+				Ports []int64 `json:"ports"`
+			}
+			if err := json.Unmarshal(body, &input); err != nil {
+				return err
+			}
+			node.WgPorts = input.Ports
+			return nil
+		}
+	case "configure_public":
+		status = http.StatusCreated
+		update = func(node *directory.Node, body []byte) error {
+			var pub directory.PublicIface
+			if err := json.Unmarshal(body, &pub); err != nil {
+				return err
+			}
+			node.PublicConfig = &pub
+			return nil
+		}
+	case "configure_free":
+		update = func(node *directory.Node, body []byte) error {
+			var input struct {
+				FreeToUse bool `json:"free_to_use"`
+			}
+			if err := json.Unmarshal(body, &input); err != nil {
+				return err
+			}
+			node.FreeToUse = input.FreeToUse
+			return nil
+		}
+	case "capacity":
+		update = func(node *directory.Node, body []byte) error {
+			var input struct {
+				Capacity directory.ResourceAmount `json:"capacity"`
+			}
+			if err := json.Unmarshal(body, &input); err != nil {
+				return err
+			}
+			node.TotalResources = input.Capacity
+			return nil
+		}
+	case "uptime":
+		update = func(node *directory.Node, body []byte) error {
+			var input struct {
+				Uptime int64 `json:"uptime"`
+			}
+			if err := json.Unmarshal(body, &input); err != nil {
+				return err
+			}
+			node.Uptime = input.Uptime
+			return nil
+		}
+	case "used_resources":
+		update = func(node *directory.Node, body []byte) error {
+			var input struct {
+				directory.ResourceAmount
+				directory.WorkloadAmount
+			}
+			if err := json.Unmarshal(body, &input); err != nil {
+				return err
+			}
+			node.UsedResources = input.ResourceAmount
+			node.Workloads = input.WorkloadAmount
+			return nil
+		}
+	default:
+		writeError(w, fmt.Errorf("not found"), http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errors.Wrap(err, "failed to read request body"), http.StatusBadRequest)
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	node, ok := s.nodes[parts[0]]
+	if !ok {
+		writeError(w, fmt.Errorf("node %s not found", parts[0]), http.StatusNotFound)
+		return
+	}
+
+	if err := update(node, body); err != nil {
+		writeError(w, errors.Wrapf(err, "failed to decode %s", parts[1]), http.StatusBadRequest)
+		return
+	}
+	node.Updated = schema.Date{Time: time.Now()}
+
+	w.WriteHeader(status)
+}
+
+func (s *Server) farm(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, Prefix+"/farms/")
+	if !ok {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	farm, ok := s.farms[id]
+	if !ok {
+		writeError(w, fmt.Errorf("farm %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, farm)
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, Prefix+"/users/")
+	if !ok {
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		writeError(w, fmt.Errorf("user %d not found", id), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (s *Server) reservations(w http.ResponseWriter, r *http.Request) {
+	parts := split(r.URL.Path, Prefix+"/reservations/")
+
+	switch {
+	case len(parts) == 2 && parts[0] == "workloads" && r.Method == http.MethodGet:
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			writeError(w, errors.Wrap(err, "invalid workload id"), http.StatusBadRequest)
+			return
+		}
+		s.workloadGet(w, func(wl workloads.Workloader) bool { return wl.GetID() == schema.ID(id) })
+
+	case len(parts) == 3 && parts[0] == "nodes" && parts[1] == "workloads" && r.Method == http.MethodGet:
+		s.workloadGet(w, func(wl workloads.Workloader) bool { return wl.UniqueWorkloadID() == parts[2] })
+
+	case len(parts) == 3 && parts[0] == "nodes" && parts[2] == "workloads" && r.Method == http.MethodGet:
+		s.nodeWorkloads(w, r, parts[1])
+
+	case len(parts) == 4 && parts[0] == "nodes" && parts[2] == "workloads" && r.Method == http.MethodPut:
+		s.workloadResult(w, r, parts[1], parts[3])
+
+	case len(parts) == 4 && parts[0] == "nodes" && parts[2] == "workloads" && r.Method == http.MethodDelete:
+		s.workloadDeleted(w, parts[1], parts[3])
+
+	default:
+		writeError(w, fmt.Errorf("not found"), http.StatusNotFound)
+	}
+}
+
+// find returns the first workload matching fn. Must be called with the lock held
+func (s *Server) find(fn func(wl workloads.Workloader) bool) (workloads.Workloader, bool) {
+	for _, wl := range s.workloads {
+		if fn(wl) {
+			return wl, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *Server) workloadGet(w http.ResponseWriter, fn func(wl workloads.Workloader) bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	wl, ok := s.find(fn)
+	if !ok {
+		writeError(w, fmt.Errorf("workload not found"), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, wl)
+}
+
+// nodeWorkloads returns the workloads of the node starting at the id from,
+// and the workloads that must be deleted whatever their id. Like the
+// explorer, the x-last-id header is the last workload id known
+func (s *Server) nodeWorkloads(w http.ResponseWriter, r *http.Request, nodeID string) {
+	var from schema.ID
+	if v := r.URL.Query().Get("from"); len(v) != 0 {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, errors.Wrap(err, "invalid from"), http.StatusBadRequest)
+			return
+		}
+		from = schema.ID(id)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	list := []workloads.Workloader{}
+	for _, wl := range s.workloads {
+		if wl.GetNodeID() != nodeID {
+			continue
+		}
+
+		if wl.GetID() >= from || wl.GetNextAction() == workloads.NextActionDelete {
+			list = append(list, wl)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GetID() < list[j].GetID() })
+
+	w.Header().Set("x-last-id", fmt.Sprint(s.lastWorkload))
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) workloadResult(w http.ResponseWriter, r *http.Request, nodeID, gwid string) {
+	var result workloads.Result
+	if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+		writeError(w, errors.Wrap(err, "failed to decode result"), http.StatusBadRequest)
+		return
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	wl, ok := s.find(func(wl workloads.Workloader) bool {
+		return wl.UniqueWorkloadID() == gwid && wl.GetNodeID() == nodeID
+	})
+	if !ok {
+		writeError(w, fmt.Errorf("workload %s not found on node %s", gwid, nodeID), http.StatusNotFound)
+		return
+	}
+
+	result.NodeId = nodeID
+	result.WorkloadId = gwid
+	wl.SetResult(result)
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) workloadDeleted(w http.ResponseWriter, nodeID, gwid string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	wl, ok := s.find(func(wl workloads.Workloader) bool {
+		return wl.UniqueWorkloadID() == gwid && wl.GetNodeID() == nodeID
+	})
+	if !ok {
+		writeError(w, fmt.Errorf("workload %s not found on node %s", gwid, nodeID), http.StatusNotFound)
+		return
+	}
+
+	wl.SetNextAction(workloads.NextActionDeleted)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) fakeFarm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var farm directory.Farm
+	if err := json.NewDecoder(r.Body).Decode(&farm); err != nil {
+		writeError(w, errors.Wrap(err, "failed to decode farm"), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, idResponse{ID: s.AddFarm(farm)})
+}
+
+func (s *Server) fakeUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var user phonebook.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, errors.Wrap(err, "failed to decode user"), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, idResponse{ID: s.AddUser(user)})
+}
+
+func (s *Server) fakeWorkload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, errors.Wrap(err, "failed to read request body"), http.StatusBadRequest)
+		return
+	}
+
+	wl, err := workloads.UnmarshalJSON(body)
+	if err != nil {
+		writeError(w, errors.Wrap(err, "failed to decode workload"), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.AddWorkload(wl)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, idResponse{ID: id})
+}
+
+func (s *Server) fakeWorkloadDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := pathID(w, r, "/fake/workloads/")
+	if !ok {
+		return
+	}
+
+	if err := s.DeleteWorkload(id); err != nil {
+		writeError(w, err, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type idResponse struct {
+	ID schema.ID `json:"id"`
+}
+
+// split returns the elements of the path after prefix
+func split(path, prefix string) []string {
+	return strings.Split(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+}
+
+// pathID parses the id at the end of the request path. It writes
+// the error response and returns false if the id is not valid
+func pathID(w http.ResponseWriter, r *http.Request, prefix string) (schema.ID, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		writeError(w, fmt.Errorf("method not allowed"), http.StatusMethodNotAllowed)
+		return 0, false
+	}
+
+	parts := split(r.URL.Path, prefix)
+	if len(parts) != 1 {
+		writeError(w, fmt.Errorf("not found"), http.StatusNotFound)
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		writeError(w, errors.Wrapf(err, "invalid id %s", parts[0]), http.StatusBadRequest)
+		return 0, false
+	}
+
+	return schema.ID(id), true
+}
+
+// writeError writes err in the format the explorer client expects
+func writeError(w http.ResponseWriter, err error, status int) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
+func logged(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Debug().Str("method", r.Method).Str("path", r.URL.Path).Msg("request")
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,124 @@
+package fake
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/client"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func TestServer(t *testing.T) {
+	require := require.New(t)
+
+	server := NewServer()
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	cl, err := client.NewClient(ts.URL, nil)
+	require.NoError(err)
+
+	// nodes can only register in a known farm
+	node := directory.Node{NodeId: "node", FarmId: 1}
+	require.Error(cl.Directory.NodeRegister(node))
+
+	farm := server.AddFarm(directory.Farm{Name: "farm"})
+	require.EqualValues(1, farm)
+	require.NoError(cl.Directory.NodeRegister(node))
+
+	require.NoError(cl.Directory.NodeUpdateUptime("node", 42))
+	require.NoError(cl.Directory.NodeSetPorts("node", []uint{1, 2}))
+	require.NoError(cl.Directory.NodeUpdateUsedResources("node", directory.ResourceAmount{Cru: 2}, directory.WorkloadAmount{Container: 1}))
+	require.Error(cl.Directory.NodeUpdateUptime("unknown", 42))
+
+	got, err := cl.Directory.NodeGet("node", false)
+	require.NoError(err)
+	require.EqualValues(42, got.Uptime)
+	require.Equal([]int64{1, 2}, got.WgPorts)
+	require.EqualValues(2, got.UsedResources.Cru)
+	require.EqualValues(1, got.Workloads.Container)
+
+	_, err = cl.Directory.FarmGet(farm)
+	require.NoError(err)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	user := server.AddUser(phonebook.User{Name: "user", Pubkey: hex.EncodeToString(pub)})
+	u, err := cl.Phonebook.Get(user)
+	require.NoError(err)
+	require.Equal(hex.EncodeToString(pub), u.Pubkey)
+
+	container := &workloads.Container{
+		ReservationInfo: workloads.ReservationInfo{
+			WorkloadId:   1,
+			NodeId:       "node",
+			CustomerTid:  int64(user),
+			WorkloadType: workloads.WorkloadTypeContainer,
+		},
+		Flist: "https://hub.grid.tf/tf-official-apps/base:latest.flist",
+	}
+
+	// workloads must be signed by their customer
+	_, err = server.AddWorkload(container)
+	require.Error(err)
+
+	signed := time.Now().Add(-time.Hour)
+	container.Epoch = schema.Date{Time: signed}
+	challenge, err := container.SignatureChallenge()
+	require.NoError(err)
+	msg := sha256.Sum256(challenge)
+	container.CustomerSignature = hex.EncodeToString(ed25519.Sign(priv, msg[:]))
+
+	id, err := server.AddWorkload(container)
+	require.NoError(err)
+
+	// like the explorer, the epoch is set once the signature is checked
+	require.True(container.GetEpoch().After(signed))
+
+	list, lastID, err := cl.Workloads.NodeWorkloads("node", 0)
+	require.NoError(err)
+	require.EqualValues(id, lastID)
+	require.Len(list, 1)
+	require.Equal(workloads.WorkloadTypeContainer, list[0].GetWorkloadType())
+	require.Equal(workloads.NextActionDeploy, list[0].GetNextAction())
+
+	gwid := list[0].UniqueWorkloadID()
+	require.NoError(cl.Workloads.NodeWorkloadPutResult("node", gwid, workloads.Result{
+		State: workloads.ResultStateOK,
+	}))
+
+	wl, err := cl.Workloads.NodeWorkloadGet(gwid)
+	require.NoError(err)
+	require.Equal(workloads.ResultStateOK, wl.GetResult().State)
+	require.Equal(gwid, wl.GetResult().WorkloadId)
+
+	// nothing new since the last poll
+	list, _, err = cl.Workloads.NodeWorkloads("node", uint64(lastID)+1)
+	require.NoError(err)
+	require.Len(list, 0)
+
+	// workloads to delete are always returned
+	require.NoError(server.DeleteWorkload(id))
+	list, _, err = cl.Workloads.NodeWorkloads("node", uint64(lastID)+1)
+	require.NoError(err)
+	require.Len(list, 1)
+	require.Equal(workloads.NextActionDelete, list[0].GetNextAction())
+
+	require.NoError(cl.Workloads.NodeWorkloadPutDeleted("node", gwid))
+	list, _, err = cl.Workloads.NodeWorkloads("node", uint64(lastID)+1)
+	require.NoError(err)
+	require.Len(list, 0)
+
+	wl, ok := server.Workload(id)
+	require.True(ok)
+	require.Equal(workloads.NextActionDeleted, wl.GetNextAction())
+}
@@ -50,6 +50,9 @@ type Flister interface {
 	// NamedUmount unmounts the flist mounted via the NamedMount call, with the same name
 	NamedUmount(path string) error
 
+	// NamedMounts returns the names of all the flists mounted with NamedMount
+	NamedMounts() ([]string, error)
+
 	// HashFromRootPath returns flist hash from a running g8ufs mounted with NamedMount
 	HashFromRootPath(name string) (string, error)
 
@@ -130,6 +130,31 @@ func (f *flistModule) Mount(url, storage string, opts pkg.MountOptions) (string,
 	return f.mount(rnd, url, storage, opts)
 }
 
+// NamedMounts implements the Flister.NamedMounts interface
+func (f *flistModule) NamedMounts() ([]string, error) {
+	infos, err := ioutil.ReadDir(f.mountpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list mountpoints")
+	}
+
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(f.mountpoint, info.Name())
+		if err := f.valid(path); err != ErrAlreadyMounted {
+			// nothing is mounted there
+			continue
+		}
+
+		names = append(names, info.Name())
+	}
+
+	return names, nil
+}
+
 func (f *flistModule) mountpath(name string) (string, error) {
 	mountpath := filepath.Join(f.mountpoint, name)
 	if filepath.Dir(mountpath) != f.mountpoint {
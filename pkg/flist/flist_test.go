@@ -58,6 +58,14 @@ func (s *StorageMock) Path(name string) (pkg.Filesystem, error) {
 	}, args.Error(1)
 }
 
+// UpdateFilesystem update filesystem mock
+func (s *StorageMock) UpdateFilesystem(name string, size uint64) (pkg.Filesystem, error) {
+	args := s.Called(name, size)
+	return pkg.Filesystem{
+		Path: args.String(0),
+	}, args.Error(1)
+}
+
 func (s *StorageMock) CanAllocate(name string, size uint64) (bool, error) {
 	args := s.Called(name, size)
 	return args.Bool(0), args.Error(1)
@@ -25,6 +25,13 @@ type ContainerNetworkConfig struct {
 	IPs         []string
 	PublicIP6   bool
 	YggdrasilIP bool
+	// Interface is the name of the interface created in the container
+	// namespace, eth0 if not set. The first network a container joins must
+	// use eth0, which gets the default routes. A container joins other
+	// networks through other interfaces (eth1, eth2, ...) that only route
+	// the ip range of their network. Public IPv6 and yggdrasil are only
+	// available on eth0
+	Interface string
 }
 
 //Networker is the interface for the network module
@@ -44,8 +51,12 @@ type Networker interface {
 	// name.
 	// The member name specifies the name of the member, and must be unique
 	// The NetID is the network id to join
+	// If cfg.Interface is not eth0, the namespace of the container must exist
+	// and a new veth pair is added to it instead
 	Join(networkdID NetID, containerID string, cfg ContainerNetworkConfig) (join Member, err error)
-	// Leave delete a container nameapce created by Join
+	// Leave delete a container nameapce created by Join. If the container
+	// joined the network through another interface than eth0, only this
+	// interface is deleted
 	Leave(networkdID NetID, containerID string) (err error)
 
 	// ZDBPrepare creates a network namespace with a macvlan interface into it
@@ -58,16 +69,15 @@ type Networker interface {
 	// for zdb is rewind. ns param is the namespace return by the ZDBPrepare
 	ZDBDestroy(ns string) error
 
-	// SetupTap sets up a tap device in the network namespace for the networkID. It is hooked
-	// to the network bridge. The name of the tap interface is returned
-	SetupTap(networkID NetID) (string, error)
+	// SetupTap sets up a tap device named after the reservation id. It is hooked
+	// to the bridge of the network networkID. The name of the tap interface is returned
+	SetupTap(networkID NetID, reservationID string) (string, error)
 
-	// TapExists checks if the tap device exists already
-	TapExists(networkID NetID) (bool, error)
+	// TapExists checks if the tap device of the reservation exists already
+	TapExists(reservationID string) (bool, error)
 
-	// RemoveTap removes the tap device from the network namespace
-	// of the networkID
-	RemoveTap(networkID NetID) error
+	// RemoveTap removes the tap device of the reservation
+	RemoveTap(reservationID string) error
 
 	// PublicIPv4Support enabled on this node for reservations
 	PublicIPv4Support() bool
@@ -189,11 +189,21 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, cfg pkg.Conta
 		return join, errors.Errorf("this node runs in IPv4 only mode and you asked for a public IPv6. Impossible to fulfill the request")
 	}
 
+	if cfg.Interface != "" && cfg.Interface != nr.PrimaryInterface && (cfg.PublicIP6 || cfg.YggdrasilIP) {
+		return join, errors.Errorf("public IPv6 and yggdrasil are only available on the %s interface", nr.PrimaryInterface)
+	}
+
 	netRes, err := nr.New(localNR)
 	if err != nil {
 		return join, errors.Wrap(err, "failed to load network resource")
 	}
 
+	if cfg.Interface != "" && cfg.Interface != nr.PrimaryInterface {
+		if err := n.checkOverlap(netRes, containerID); err != nil {
+			return join, err
+		}
+	}
+
 	ips := make([]net.IP, len(cfg.IPs))
 	for i, addr := range cfg.IPs {
 		ips[i] = net.ParseIP(addr)
@@ -204,6 +214,7 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, cfg pkg.Conta
 		IPs:         ips,
 		PublicIP6:   cfg.PublicIP6,
 		IPv4Only:    n.ipv4Only(),
+		Interface:   cfg.Interface,
 	})
 	if err != nil {
 		return join, errors.Wrap(err, "failed to load network resource")
@@ -265,6 +276,33 @@ func (n *networker) Join(networkdID pkg.NetID, containerID string, cfg pkg.Conta
 	return join, nil
 }
 
+// checkOverlap makes sure netRes doesn't overlap the
+// networks already joined by the container containerID
+func (n *networker) checkOverlap(netRes *nr.NetResource, containerID string) error {
+	joined, err := nr.Joined(containerID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list the networks of container %s", containerID)
+	}
+
+	for _, id := range joined {
+		localNR, err := n.networkOf(string(id))
+		if err != nil {
+			return errors.Wrapf(err, "couldn't load network with id (%s)", id)
+		}
+
+		other, err := nr.New(localNR)
+		if err != nil {
+			return errors.Wrap(err, "failed to load network resource")
+		}
+
+		if netRes.Overlaps(other) {
+			return errors.Errorf("network %s overlaps network %s joined by the container", netRes.ID(), id)
+		}
+	}
+
+	return nil
+}
+
 func (n *networker) Leave(networkdID pkg.NetID, containerID string) error {
 	log.Info().Str("network-id", string(networkdID)).Msg("leaving network")
 
@@ -379,10 +417,10 @@ func (n networker) createMacVlan(iface string, hw net.HardwareAddr, ips []*net.I
 	return nil
 }
 
-// SetupTap interface in the network resource. We only allow 1 tap interface to be
-// set up per NR currently
-func (n *networker) SetupTap(networkID pkg.NetID) (string, error) {
-	log.Info().Str("network-id", string(networkID)).Msg("Setting up tap interface")
+// SetupTap interface in the network resource. The tap is named after the
+// reservation id so several vms can be connected to the same NR
+func (n *networker) SetupTap(networkID pkg.NetID, reservationID string) (string, error) {
+	log.Info().Str("network-id", string(networkID)).Str("res-id", reservationID).Msg("Setting up tap interface")
 
 	localNR, err := n.networkOf(string(networkID))
 	if err != nil {
@@ -399,7 +437,7 @@ func (n *networker) SetupTap(networkID pkg.NetID) (string, error) {
 		return "", errors.Wrap(err, "could not get network namespace bridge")
 	}
 
-	tapIface, err := tapName(networkID)
+	tapIface, err := tapName(reservationID)
 	if err != nil {
 		return "", errors.Wrap(err, "could not get network namespace tap device name")
 	}
@@ -409,10 +447,11 @@ func (n *networker) SetupTap(networkID pkg.NetID) (string, error) {
 	return tapIface, err
 }
 
-func (n *networker) TapExists(networkID pkg.NetID) (bool, error) {
-	log.Info().Str("network-id", string(networkID)).Msg("Checking if tap interface exists")
+// TapExists checks if the tap device of the reservation exists already
+func (n *networker) TapExists(reservationID string) (bool, error) {
+	log.Info().Str("res-id", reservationID).Msg("Checking if tap interface exists")
 
-	tapIface, err := tapName(networkID)
+	tapIface, err := tapName(reservationID)
 	if err != nil {
 		return false, errors.Wrap(err, "could not get network namespace tap device name")
 	}
@@ -421,10 +460,10 @@ func (n *networker) TapExists(networkID pkg.NetID) (bool, error) {
 }
 
 // RemoveTap in the network resource.
-func (n *networker) RemoveTap(networkID pkg.NetID) error {
-	log.Info().Str("network-id", string(networkID)).Msg("Removing tap interface")
+func (n *networker) RemoveTap(reservationID string) error {
+	log.Info().Str("res-id", reservationID).Msg("Removing tap interface")
 
-	tapIface, err := tapName(networkID)
+	tapIface, err := tapName(reservationID)
 	if err != nil {
 		return errors.Wrap(err, "could not get network namespace tap device name")
 	}
@@ -1083,9 +1122,9 @@ func createNetNS(name string) (ns.NetNS, error) {
 	return netNs, nil
 }
 
-// tapName returns the name of the tap device for a network namespace
-func tapName(netID pkg.NetID) (string, error) {
-	name := fmt.Sprintf("t-%s", netID)
+// tapName returns the name of the tap device of a reservation
+func tapName(resID string) (string, error) {
+	name := fmt.Sprintf("t-%s", resID)
 	if len(name) > 15 {
 		return "", errors.Errorf("tap name too long %s", name)
 	}
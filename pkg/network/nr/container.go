@@ -17,6 +17,10 @@ import (
 	"github.com/vishvananda/netlink"
 )
 
+// PrimaryInterface is the interface of the first network joined by
+// a container, the default routes of the container go through it
+const PrimaryInterface = "eth0"
+
 // ContainerConfig is an object used to pass the required network configuration
 // for a container
 type ContainerConfig struct {
@@ -24,9 +28,16 @@ type ContainerConfig struct {
 	IPs         []net.IP
 	PublicIP6   bool //true if the container must have a public ipv6
 	IPv4Only    bool // describe the state of the node, true mean it runs in ipv4 only mode
+	// Interface is the name of the interface in the container namespace,
+	// PrimaryInterface if not set
+	Interface string
 }
 
 // Join make a network namespace of a container join a network resource network
+//
+// Joining through the primary interface creates the namespace of the container
+// and sets its default routes. Joining through another interface adds it to the
+// existing namespace, with a route to the ip range of the network only
 func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 	name, err := nr.BridgeName()
 	if err != nil {
@@ -38,8 +49,19 @@ func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 		return join, err
 	}
 
+	if cfg.Interface == "" {
+		cfg.Interface = PrimaryInterface
+	}
+	primary := cfg.Interface == PrimaryInterface
+
 	join.Namespace = cfg.ContainerID
-	netspace, err := namespace.Create(cfg.ContainerID)
+
+	var netspace ns.NetNS
+	if primary {
+		netspace, err = namespace.Create(cfg.ContainerID)
+	} else {
+		netspace, err = namespace.GetByName(cfg.ContainerID)
+	}
 	if err != nil {
 		return join, err
 	}
@@ -50,7 +72,9 @@ func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 		Logger()
 
 	defer func() {
-		if err != nil {
+		if !primary {
+			netspace.Close()
+		} else if err != nil {
 			namespace.Delete(netspace)
 		}
 	}()
@@ -62,9 +86,9 @@ func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 		}
 
 		slog.Info().
-			Str("veth", "eth0").
+			Str("veth", cfg.Interface).
 			Msg("Create veth pair in net namespace")
-		hostVeth, containerVeth, err := ip.SetupVeth("eth0", 1500, host)
+		hostVeth, containerVeth, err := ip.SetupVeth(cfg.Interface, 1500, host)
 		if err != nil {
 			return errors.Wrapf(err, "failed to create veth pair in namespace (%s)", join.Namespace)
 		}
@@ -76,6 +100,12 @@ func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 			return err
 		}
 
+		// the network of the interface is recorded in its
+		// alias so the interface is found back on leave
+		if err := netlink.LinkSetAlias(eth0, nr.ID()); err != nil {
+			return errors.Wrapf(err, "failed to set alias of %s", cfg.Interface)
+		}
+
 		for _, addr := range cfg.IPs {
 			slog.Info().
 				Str("ip", addr.String()).
@@ -117,6 +147,26 @@ func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 
 		ipnet.IP[len(ipnet.IP)-1] = 0x01
 
+		if !primary {
+			// the other networks are only reachable through their ip range
+			route := &netlink.Route{
+				Dst:       &nr.resource.NetworkIPRange.IPNet,
+				Gw:        ipnet.IP,
+				LinkIndex: eth0.Attrs().Index,
+			}
+
+			slog.Info().
+				Str("route", route.String()).
+				Msgf("set route to container")
+			// overlapping networks are refused on join, so an existing
+			// route means the range is already routed to another network
+			if err := netlink.RouteAdd(route); err != nil {
+				return errors.Wrapf(err, "failed to set route %s on %s", route.String(), cfg.Interface)
+			}
+
+			return nil
+		}
+
 		routes := []*netlink.Route{
 			{
 				Dst: &net.IPNet{
@@ -170,7 +220,9 @@ func (nr *NetResource) Join(cfg ContainerConfig) (join pkg.Member, err error) {
 	return join, bridge.AttachNic(hostVeth, br)
 }
 
-// Leave delete a container network namespace
+// Leave removes the container from the network resource. If the network was
+// joined through the primary interface, the container network namespace is
+// deleted, otherwise only the interface connected to this network is removed
 func (nr *NetResource) Leave(containerID string) error {
 	log.Info().
 		Str("namespace", containerID).
@@ -187,9 +239,91 @@ func (nr *NetResource) Leave(containerID string) error {
 	}
 	defer namespc.Close()
 
+	var iface string
+	err = namespc.Do(func(_ ns.NetNS) error {
+		iface, err = nr.containerInterface()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if iface == "" {
+		// the container already left this network
+		return nil
+	} else if iface != PrimaryInterface {
+		log.Info().Str("interface", iface).Msg("delete container network interface")
+		return ifaceutil.Delete(iface, namespc)
+	}
+
 	err = namespace.Delete(namespc)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// containerInterface returns the name of the interface of the current
+// namespace connected to the network resource
+func (nr *NetResource) containerInterface() (string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return "", err
+	}
+
+	recorded := false
+	for _, link := range links {
+		alias := link.Attrs().Alias
+		if alias == nr.ID() {
+			return link.Attrs().Name, nil
+		}
+		recorded = recorded || alias != ""
+	}
+
+	if !recorded {
+		// containers joined before the network of the interfaces was
+		// recorded could only join one network, through the primary interface
+		return PrimaryInterface, nil
+	}
+
+	return "", nil
+}
+
+// Joined returns the networks joined by the container containerID
+func Joined(containerID string) ([]pkg.NetID, error) {
+	namespc, err := namespace.GetByName(containerID)
+	if err != nil {
+		return nil, err
+	}
+	defer namespc.Close()
+
+	var joined []pkg.NetID
+	err = namespc.Do(func(_ ns.NetNS) error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return err
+		}
+
+		for _, link := range links {
+			if alias := link.Attrs().Alias; alias != "" {
+				joined = append(joined, pkg.NetID(alias))
+			}
+		}
+
+		return nil
+	})
+
+	return joined, err
+}
+
+// Overlaps checks if the subnet or the ip range of nr overlaps the ones
+// of other. A container can't join overlapping networks since their
+// routes would conflict
+func (nr *NetResource) Overlaps(other *NetResource) bool {
+	overlaps := func(a, b net.IPNet) bool {
+		return a.Contains(b.IP) || b.Contains(a.IP)
+	}
+
+	return overlaps(nr.resource.Subnet.IPNet, other.resource.Subnet.IPNet) ||
+		overlaps(nr.networkIPRange, other.networkIPRange)
+}
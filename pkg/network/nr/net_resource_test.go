@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/types"
 	"github.com/vishvananda/netlink"
 )
 
@@ -189,3 +190,20 @@ func Test_convert4to6(t *testing.T) {
 		})
 	}
 }
+
+func TestOverlaps(t *testing.T) {
+	resource := func(subnet, iprange string) *NetResource {
+		nr, err := New(pkg.NetResource{
+			Subnet:         types.MustParseIPNet(subnet),
+			NetworkIPRange: types.MustParseIPNet(iprange),
+		})
+		require.NoError(t, err)
+		return nr
+	}
+
+	nr := resource("10.1.1.0/24", "10.1.0.0/16")
+
+	assert.False(t, nr.Overlaps(resource("10.2.1.0/24", "10.2.0.0/16")))
+	assert.True(t, nr.Overlaps(resource("10.1.2.0/24", "10.1.0.0/16")))
+	assert.True(t, nr.Overlaps(resource("10.1.1.0/24", "10.0.0.0/8")))
+}
@@ -2,7 +2,13 @@ package pkg
 
 //go:generate zbusc -module provision -version 0.0.1 -name provision -package stubs github.com/threefoldtech/zos/pkg+Provision stubs/provision_stub.go
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // ProvisionCounters struct
 type ProvisionCounters struct {
@@ -14,8 +20,261 @@ type ProvisionCounters struct {
 	Debug     int64 `json:"debug"`
 }
 
+// ResourceUnits is an amount of resource units
+// memory and storage units are in bytes
+type ResourceUnits struct {
+	CRU   uint64 `json:"cru"`
+	MRU   uint64 `json:"mru"`
+	SRU   uint64 `json:"sru"`
+	HRU   uint64 `json:"hru"`
+	IPV4U uint64 `json:"ipv4u"`
+}
+
+// Admission is the result of the capacity check done
+// before a reservation is provisioned
+type Admission struct {
+	// Requested is the amount of resource units used by the reservation
+	Requested ResourceUnits `json:"requested"`
+	// Available is the amount of resource units not yet reserved on the node
+	// the node doesn't limit the amount of public ipv4, so IPV4U is not set
+	// and PublicIPv4 must be used instead
+	Available ResourceUnits `json:"available"`
+	// PublicIPv4 is true if the node can host public ipv4 addresses
+	PublicIPv4 bool `json:"public_ipv4"`
+	// Missing is the list of resource units the node doesn't have enough of
+	// (cru, mru, sru, hru, ipv4u). ipv4u is also missing when the requested
+	// public ip is already reserved
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Admitted returns true if the node has enough capacity for the reservation
+func (a Admission) Admitted() bool {
+	return len(a.Missing) == 0
+}
+
+// ErrInsufficientCapacity is returned when a reservation is refused
+// because the node doesn't have enough free capacity
+type ErrInsufficientCapacity struct {
+	Admission
+}
+
+func (e ErrInsufficientCapacity) Error() string {
+	return fmt.Sprintf("insufficient capacity: not enough %s available", strings.Join(e.Missing, ", "))
+}
+
+// Quota is the maximum amount of resource units and workloads a user can
+// reserve on the node. memory and storage units are in bytes. A nil limit
+// means the user is not limited for that unit
+type Quota struct {
+	CRU       *uint64 `json:"cru,omitempty"`
+	MRU       *uint64 `json:"mru,omitempty"`
+	SRU       *uint64 `json:"sru,omitempty"`
+	HRU       *uint64 `json:"hru,omitempty"`
+	IPV4U     *uint64 `json:"ipv4u,omitempty"`
+	Workloads *uint64 `json:"workloads,omitempty"`
+}
+
+// Exceeded returns the units (cru, mru, sru, hru, ipv4u, workloads)
+// for which used is over the quota
+func (q Quota) Exceeded(used ResourceUnits, workloads uint64) []string {
+	var exceeded []string
+	check := func(name string, limit *uint64, value uint64) {
+		if limit != nil && value > *limit {
+			exceeded = append(exceeded, name)
+		}
+	}
+
+	check("cru", q.CRU, used.CRU)
+	check("mru", q.MRU, used.MRU)
+	check("sru", q.SRU, used.SRU)
+	check("hru", q.HRU, used.HRU)
+	check("ipv4u", q.IPV4U, used.IPV4U)
+	check("workloads", q.Workloads, workloads)
+
+	return exceeded
+}
+
+// UserUsage is the amount of resources reserved by a user on the node
+type UserUsage struct {
+	User      string        `json:"user"`
+	Used      ResourceUnits `json:"used"`
+	Workloads uint64        `json:"workloads"`
+	// Quota is the quota applied to the user, nil if the user has no quota
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// ErrQuotaExceeded is returned when a reservation is refused
+// because its user would go over its quota
+type ErrQuotaExceeded struct {
+	// Usage is what the user would use if the reservation was deployed
+	Usage UserUsage
+	// Exceeded is the list of units over the quota
+	Exceeded []string
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("quota of user %s exceeded: too much %s requested", e.Usage.User, strings.Join(e.Exceeded, ", "))
+}
+
+// MeteredUsage is an amount of resource units consumed over time. cpu and
+// public ips are in unit-hours, memory and storage in GiB-hours. CU and SU
+// are the cloud units equivalent of the resource units, in unit-hours
+type MeteredUsage struct {
+	CRU   float64 `json:"cru"`
+	MRU   float64 `json:"mru"`
+	SRU   float64 `json:"sru"`
+	HRU   float64 `json:"hru"`
+	IPV4U float64 `json:"ipv4u"`
+	CU    float64 `json:"cu"`
+	SU    float64 `json:"su"`
+}
+
+// Add adds o to u
+func (u *MeteredUsage) Add(o MeteredUsage) {
+	u.CRU += o.CRU
+	u.MRU += o.MRU
+	u.SRU += o.SRU
+	u.HRU += o.HRU
+	u.IPV4U += o.IPV4U
+	u.CU += o.CU
+	u.SU += o.SU
+}
+
+// WorkloadUsage is the usage of a single reservation
+type WorkloadUsage struct {
+	ID    string       `json:"id"`
+	Type  string       `json:"type"`
+	Usage MeteredUsage `json:"usage"`
+}
+
+// UsageReport is the usage of the reservations of a user on
+// the node during a time range. It is signed by the node
+type UsageReport struct {
+	NodeID    string          `json:"node_id"`
+	User      string          `json:"user"`
+	From      time.Time       `json:"from"`
+	To        time.Time       `json:"to"`
+	Workloads []WorkloadUsage `json:"workloads"`
+	Total     MeteredUsage    `json:"total"`
+	Signature []byte          `json:"signature,omitempty"`
+}
+
+// SignedBytes returns the content of the report covered by the signature
+func (r UsageReport) SignedBytes() ([]byte, error) {
+	r.Signature = nil
+	r.From = r.From.UTC()
+	r.To = r.To.UTC()
+
+	return json.Marshal(r)
+}
+
+// WorkloadRequest is a reservation sent to an external provisioner
+type WorkloadRequest struct {
+	ID       string          `json:"id"`
+	User     string          `json:"user"`
+	Type     string          `json:"type"`
+	Created  time.Time       `json:"created"`
+	Duration time.Duration   `json:"duration"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// ExternalProvisioner is implemented by the daemons that deploy reservation
+// types provisiond doesn't know about. The daemon serves it over zbus and
+// registers the types it handles with Provision.RegisterProvisioner
+type ExternalProvisioner interface {
+	// Provision deploys the workload and returns the data of its result
+	Provision(w WorkloadRequest) (json.RawMessage, error)
+	// Decommission removes the workload
+	Decommission(w WorkloadRequest) error
+	// ResourceUnits returns the resource units used by the workload
+	ResourceUnits(w WorkloadRequest) (ResourceUnits, error)
+}
+
+// ExternalProvisionerID is the zbus object serving an ExternalProvisioner
+type ExternalProvisionerID struct {
+	Module  string `json:"module"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ProvisionPhase is a step of the provisioning, update
+// or decommissioning of a reservation
+type ProvisionPhase string
+
+const (
+	// PhaseReceived the reservation has been received by the engine
+	PhaseReceived ProvisionPhase = "received"
+	// PhaseRejected the reservation is refused and won't be deployed
+	PhaseRejected ProvisionPhase = "rejected"
+	// PhaseWaiting the reservation waits for the workloads it depends on to be deployed
+	PhaseWaiting ProvisionPhase = "waiting"
+	// PhaseProvisioning the workload is being deployed
+	PhaseProvisioning ProvisionPhase = "provisioning"
+	// PhaseRetrying the deployment failed with a transient error and is tried again
+	PhaseRetrying ProvisionPhase = "retrying"
+	// PhaseDeployed the workload is deployed
+	PhaseDeployed ProvisionPhase = "deployed"
+	// PhaseUpdating the configuration of the workload is being changed
+	PhaseUpdating ProvisionPhase = "updating"
+	// PhaseUpdated the workload runs with its new configuration
+	PhaseUpdated ProvisionPhase = "updated"
+	// PhaseDecommissioning the workload is being removed
+	PhaseDecommissioning ProvisionPhase = "decommissioning"
+	// PhaseDecommissioned the workload has been removed
+	PhaseDecommissioned ProvisionPhase = "decommissioned"
+	// PhaseFailed the last operation on the workload failed
+	PhaseFailed ProvisionPhase = "failed"
+)
+
+// ProvisionEvent is sent by the provision engine at each
+// step of the life of a reservation
+type ProvisionEvent struct {
+	ID    string         `json:"id"`
+	Type  string         `json:"type"`
+	Phase ProvisionPhase `json:"phase"`
+	Time  time.Time      `json:"time"`
+	// Duration is the time elapsed since the engine
+	// started processing the reservation
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
 // Provision interface
 type Provision interface {
 	Counters(ctx context.Context) <-chan ProvisionCounters
+	// Events streams the lifecycle events of the reservations
+	// processed by the engine
+	Events(ctx context.Context) <-chan ProvisionEvent
 	DecommissionCached(id string, reason string) error
+	// JobExited is used by the container module to report that the
+	// job reservation id ended. The reservation is decommissioned
+	// and result becomes its result
+	JobExited(id string, result JobResult) error
+	// WorkloadStopped is used to report to the owner of the workload id
+	// that it stopped running because of reason. Unlike DecommissionCached
+	// the reservation is not decommissioned
+	WorkloadStopped(id string, reason string) error
+
+	// CheckCapacity is a dry run of the capacity check done before a
+	// reservation is provisioned. data is the reservation data of type typ
+	CheckCapacity(typ string, data []byte) (Admission, error)
+
+	// Usage returns the resources reserved by each user
+	// of the node, along with the quota applied to them
+	Usage() ([]UserUsage, error)
+
+	// UsageReport returns the signed report of the resources
+	// consumed by user between from and to
+	UsageReport(user string, from, to time.Time) (UsageReport, error)
+
+	// RegisterProvisioner routes the reservations of type typ to the
+	// external provisioner id. Built-in types can't be registered
+	RegisterProvisioner(typ string, id ExternalProvisionerID) error
+	// UnregisterProvisioner stops routing the reservations of type typ
+	// to its external provisioner
+	UnregisterProvisioner(typ string) error
+
+	// VerifyToken checks that token is valid and signed
+	// by the owner of the reservation id
+	VerifyToken(id string, token AccessToken) error
 }
@@ -1,6 +1,7 @@
 package provision
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -10,38 +11,114 @@ import (
 	"github.com/threefoldtech/tfexplorer/client"
 	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/network/namespace"
 	"github.com/threefoldtech/zos/pkg/provision/common"
 	"github.com/threefoldtech/zos/pkg/storage"
 	"github.com/threefoldtech/zos/pkg/stubs"
 	"github.com/threefoldtech/zos/pkg/zdb"
+	"github.com/vishvananda/netlink"
 	"golang.org/x/net/context"
 )
 
 var (
-	vdiskIDMatch = regexp.MustCompile(`^(\d+-\d+)`)
+	vdiskIDMatch    = regexp.MustCompile(`^(\d+-\d+)`)
+	workloadIDMatch = regexp.MustCompile(`^\d+-\d+$`)
 )
 
+// Orphan is a resource found on the node that
+// doesn't belong to any active reservation
+type Orphan struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
 // Janitor structure
 type Janitor struct {
 	zbus zbus.Client
 
 	getter ReservationGetter
+	cache  ReservationCache
+
+	// ReportOnly makes the janitor log the lingering resources
+	// it finds without deleting them
+	ReportOnly bool
 }
 
 // NewJanitor creates a new Janitor instance
-func NewJanitor(zbus zbus.Client, getter ReservationGetter) *Janitor {
+func NewJanitor(zbus zbus.Client, getter ReservationGetter, cache ReservationCache) *Janitor {
 	return &Janitor{
 		zbus:   zbus,
 		getter: getter,
+		cache:  cache,
+	}
+}
+
+// janitorRun holds the state of a single clean up pass
+type janitorRun struct {
+	reportOnly bool
+	orphans    []Orphan
+
+	// workloads and networks are the IDs used
+	// by the reservations in the cache
+	workloads map[string]struct{}
+	networks  map[pkg.NetID]struct{}
+}
+
+// remove records the orphan and calls fn to delete it unless
+// the run is in report only mode
+func (r *janitorRun) remove(orphan Orphan, fn func() error) error {
+	r.orphans = append(r.orphans, orphan)
+
+	clog := log.With().
+		Str("kind", orphan.Kind).
+		Str("name", orphan.Name).
+		Str("reason", orphan.Reason).
+		Logger()
+
+	if r.reportOnly {
+		clog.Info().Msg("found lingering resource")
+		return nil
 	}
+
+	clog.Info().Msg("delete lingering resource")
+	if err := fn(); err != nil {
+		clog.Error().Err(err).Msgf("failed to delete %s", orphan.Kind)
+		return err
+	}
+
+	return nil
+}
+
+func (r *janitorRun) isWorkload(id string) bool {
+	_, ok := r.workloads[id]
+	return ok
+}
+
+func (r *janitorRun) isNetwork(id pkg.NetID) bool {
+	_, ok := r.networks[id]
+	return ok
 }
 
 // CleanupResources cleans up unused resources
 func (j *Janitor) CleanupResources(ctx context.Context) error {
+	_, err := j.run(ctx, j.ReportOnly)
+	return err
+}
+
+// Report returns the lingering resources found on the node
+// without deleting anything
+func (j *Janitor) Report(ctx context.Context) ([]Orphan, error) {
+	return j.run(ctx, true)
+}
+
+func (j *Janitor) run(ctx context.Context, reportOnly bool) ([]Orphan, error) {
+	run := &janitorRun{reportOnly: reportOnly}
+
 	// - First remove all lingering zdb namespaces that has NO valid
 	// reservation. This will also decomission zdb containers that
 	// serves no namespaces anymore
-	if err := j.cleanupZdbContainers(ctx); err != nil {
+	if err := j.cleanupZdbContainers(ctx, run); err != nil {
 		log.Error().Err(err).Msg("zdb cleaner failed")
 		// we don't stop here. if we failed to clean zdb containers
 		// any lingering zdb container will end up in the protected
@@ -50,16 +127,78 @@ func (j *Janitor) CleanupResources(ctx context.Context) error {
 	}
 
 	// - Second, we clean up all lingering volumes on the node
-	if err := j.cleanupVolumes(ctx); err != nil {
+	if err := j.cleanupVolumes(ctx, run); err != nil {
 		log.Error().Err(err).Msg("volume cleaner failed")
 	}
 
 	// - Third, we clean up any lingering vdisks that are not being
 	// used.
-	if err := j.cleanupVdisks(ctx); err != nil {
+	if err := j.cleanupVdisks(ctx, run); err != nil {
 		log.Error().Err(err).Msg("virtual disks cleaner failed")
 	}
 
+	// - The remaining resources are checked against the local cache
+	// since it is the only place that knows what runs on this node.
+	if j.cache == nil {
+		log.Info().Msg("reservation cache is not configured, skipping workload clean up")
+		return run.orphans, nil
+	}
+
+	if err := j.loadInUse(run); err != nil {
+		return run.orphans, errors.Wrap(err, "failed to list the reservations in use")
+	}
+
+	cleaners := []struct {
+		name string
+		fn   func(context.Context, *janitorRun) error
+	}{
+		{"vm", j.cleanupVMs},
+		{"flist", j.cleanupMounts},
+		{"public ip filter", j.cleanupPublicIPFilters},
+		{"tap", j.cleanupTaps},
+		{"network resource", j.cleanupNetworkResources},
+	}
+
+	for _, cleaner := range cleaners {
+		if err := cleaner.fn(ctx, run); err != nil {
+			log.Error().Err(err).Msgf("%s cleaner failed", cleaner.name)
+		}
+	}
+
+	return run.orphans, nil
+}
+
+// loadInUse fills the run with the workloads and
+// networks of all the reservations in the cache
+func (j *Janitor) loadInUse(run *janitorRun) error {
+	reservations, err := j.cache.List()
+	if err != nil {
+		return err
+	}
+
+	run.workloads = make(map[string]struct{})
+	run.networks = make(map[pkg.NetID]struct{})
+
+	for _, r := range reservations {
+		run.workloads[r.ID] = struct{}{}
+		if r.Reference != "" {
+			// updated workloads keep running under the ID of
+			// the reservation they replaced
+			run.workloads[r.Reference] = struct{}{}
+		}
+
+		if r.Type != "network" && r.Type != "network_resource" {
+			continue
+		}
+
+		var nr pkg.NetResource
+		if err := json.Unmarshal(r.Data, &nr); err != nil {
+			return errors.Wrapf(err, "failed to decode network of reservation %s", r.ID)
+		}
+
+		run.networks[NetworkID(r.User, nr.Name)] = struct{}{}
+	}
+
 	return nil
 }
 
@@ -83,7 +222,7 @@ func (j *Janitor) checkToDelete(id string) (bool, error) {
 	return reservation.ToDelete, nil
 }
 
-func (j *Janitor) cleanupVdisks(ctx context.Context) error {
+func (j *Janitor) cleanupVdisks(ctx context.Context, run *janitorRun) error {
 	stub := stubs.NewVDiskModuleStub(j.zbus)
 
 	vdisks, err := stub.List()
@@ -106,10 +245,10 @@ func (j *Janitor) cleanupVdisks(ctx context.Context) error {
 		}
 
 		if delete {
-			clog.Info().Str("reason", "no-associated-reservation").Msg("delete vdisk")
-			if err := stub.Deallocate(vdisk.Name()); err != nil {
-				clog.Error().Err(err).Msg("failed to deallocate vdisk")
-			}
+			name := vdisk.Name()
+			_ = run.remove(Orphan{Kind: "vdisk", Name: name, Reason: "no-associated-reservation"}, func() error {
+				return stub.Deallocate(name)
+			})
 		} else {
 			clog.Info().Msg("skipping vdisk")
 		}
@@ -118,7 +257,7 @@ func (j *Janitor) cleanupVdisks(ctx context.Context) error {
 	return nil
 }
 
-func (j *Janitor) cleanupVolumes(ctx context.Context) error {
+func (j *Janitor) cleanupVolumes(ctx context.Context, run *janitorRun) error {
 	storaged := stubs.NewStorageModuleStub(j.zbus)
 	// We get a list with ALL volumes, that are being
 	// used by active containers. Note we don't check if
@@ -150,34 +289,27 @@ func (j *Janitor) cleanupVolumes(ctx context.Context) error {
 			continue
 		}
 
+		name := volume.Name
+		release := func() error {
+			return storaged.ReleaseFilesystem(name)
+		}
+
 		if len(volume.Name) == 64 {
 			// if the fs is not used by any container and its name is 64 character long
 			// they are left over of old containers when flistd used to generate random names
 			// for the container root flist subvolumes
-			clog.Info().Str("reason", "legacy-root-fs").Msg("delete subvolume")
-			if err := storaged.ReleaseFilesystem(volume.Name); err != nil {
-				clog.Error().Err(err).Msg("failed to delete subvol")
-			}
-
+			_ = run.remove(Orphan{Kind: "subvolume", Name: name, Reason: "legacy-root-fs"}, release)
 			continue
 		}
 
 		if strings.HasPrefix(volume.Name, storage.ZDBPoolPrefix) {
-			clog.Info().Str("reason", "unused-zdb").Msg("delete subvolume")
-			if err := storaged.ReleaseFilesystem(volume.Name); err != nil {
-				clog.Error().Err(err).Msg("failed to delete subvol")
-			}
-
+			_ = run.remove(Orphan{Kind: "subvolume", Name: name, Reason: "unused-zdb"}, release)
 			continue
 		}
 
 		if volume.Name == "fcvms" {
 			// left over from testing during vm module development
-			clog.Info().Str("reason", "legacy-vm-fs").Msg("delete subvolume")
-			if err := storaged.ReleaseFilesystem(volume.Name); err != nil {
-				clog.Error().Err(err).Msg("failed to delete subvol")
-			}
-
+			_ = run.remove(Orphan{Kind: "subvolume", Name: name, Reason: "legacy-vm-fs"}, release)
 			continue
 		}
 
@@ -193,10 +325,7 @@ func (j *Janitor) cleanupVolumes(ctx context.Context) error {
 		}
 
 		if delete {
-			clog.Info().Str("reason", "no-associated-reservation").Msg("delete subvolume")
-			if err := storaged.ReleaseFilesystem(volume.Name); err != nil {
-				clog.Error().Err(err).Msg("failed to delete subvolume")
-			}
+			_ = run.remove(Orphan{Kind: "subvolume", Name: name, Reason: "no-associated-reservation"}, release)
 		} else {
 			clog.Info().Msg("skipping subvolume")
 		}
@@ -205,7 +334,7 @@ func (j *Janitor) cleanupVolumes(ctx context.Context) error {
 	return nil
 }
 
-func (j *Janitor) cleanupZdbContainer(ctx context.Context, id string) error {
+func (j *Janitor) cleanupZdbContainer(ctx context.Context, run *janitorRun, id string) error {
 	con, err := newZdbConnection(id)
 	if err != nil {
 		return err
@@ -237,8 +366,12 @@ func (j *Janitor) cleanupZdbContainer(ctx context.Context, id string) error {
 			continue
 		}
 
-		if err := con.DeleteNamespace(namespace); err != nil {
-			log.Error().Err(err).Str("zdb-namespace", namespace).Msg("failed to delete lingering zdb namespace")
+		name := namespace
+		err = run.remove(Orphan{Kind: "zdb-namespace", Name: name, Reason: "no-associated-reservation"}, func() error {
+			return con.DeleteNamespace(name)
+		})
+		if err != nil {
+			continue
 		}
 
 		delete(mapped, namespace)
@@ -251,10 +384,12 @@ func (j *Janitor) cleanupZdbContainer(ctx context.Context, id string) error {
 	}
 
 	// no more namespace to keep, so container can also go
-	return common.DeleteZdbContainer(pkg.ContainerID(id), j.zbus)
+	return run.remove(Orphan{Kind: "zdb-container", Name: id, Reason: "no-namespaces"}, func() error {
+		return common.DeleteZdbContainer(pkg.ContainerID(id), j.zbus)
+	})
 }
 
-func (j *Janitor) cleanupZdbContainers(ctx context.Context) error {
+func (j *Janitor) cleanupZdbContainers(ctx context.Context, run *janitorRun) error {
 	containerd := stubs.NewContainerModuleStub(j.zbus)
 
 	containers, err := containerd.List("zdb")
@@ -263,7 +398,7 @@ func (j *Janitor) cleanupZdbContainers(ctx context.Context) error {
 	}
 
 	for _, containerID := range containers {
-		if err := j.cleanupZdbContainer(ctx, string(containerID)); err != nil {
+		if err := j.cleanupZdbContainer(ctx, run, string(containerID)); err != nil {
 			log.Error().Err(err).Msg("failed to cleanup zdb container")
 		}
 	}
@@ -317,6 +452,133 @@ func (j *Janitor) protectedVolumesFromContainers(ctx context.Context) (map[strin
 	return toSave, nil
 }
 
+func (j *Janitor) cleanupVMs(ctx context.Context, run *janitorRun) error {
+	vmd := stubs.NewVMModuleStub(j.zbus)
+
+	machines, err := vmd.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list virtual machines")
+	}
+
+	for _, name := range machines {
+		if !workloadIDMatch.MatchString(name) || run.isWorkload(name) {
+			continue
+		}
+
+		name := name
+		_ = run.remove(Orphan{Kind: "vm", Name: name, Reason: "no-associated-reservation"}, func() error {
+			return vmd.Delete(name)
+		})
+	}
+
+	return nil
+}
+
+// cleanupMounts unmounts the container root flists. The other named
+// mounts (k3os images, zdb) don't use a reservation ID as name
+// and are never touched
+func (j *Janitor) cleanupMounts(ctx context.Context, run *janitorRun) error {
+	flist := stubs.NewFlisterStub(j.zbus)
+
+	mounts, err := flist.NamedMounts()
+	if err != nil {
+		return errors.Wrap(err, "failed to list flist mounts")
+	}
+
+	for _, name := range mounts {
+		if !workloadIDMatch.MatchString(name) || run.isWorkload(name) {
+			continue
+		}
+
+		name := name
+		_ = run.remove(Orphan{Kind: "flist", Name: name, Reason: "no-associated-reservation"}, func() error {
+			return flist.NamedUmount(name)
+		})
+	}
+
+	return nil
+}
+
+func (j *Janitor) cleanupPublicIPFilters(ctx context.Context, run *janitorRun) error {
+	ids, err := common.ListPublicIPFilters(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if run.isWorkload(id) {
+			continue
+		}
+
+		name := common.PublicIPFilterName(id)
+		_ = run.remove(Orphan{Kind: "nft-chain", Name: name, Reason: "no-associated-reservation"}, func() error {
+			return common.TeardownPublicIPFilters(ctx, name)
+		})
+	}
+
+	return nil
+}
+
+func (j *Janitor) cleanupTaps(ctx context.Context, run *janitorRun) error {
+	network := stubs.NewNetworkerStub(j.zbus)
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return errors.Wrap(err, "failed to list network interfaces")
+	}
+
+	for _, link := range links {
+		name := link.Attrs().Name
+
+		switch {
+		case strings.HasPrefix(name, "t-"):
+			// taps used to be named after the network, those are
+			// kept as long as the network exists
+			id := strings.TrimPrefix(name, "t-")
+			if run.isWorkload(id) || run.isNetwork(pkg.NetID(id)) {
+				continue
+			}
+
+			_ = run.remove(Orphan{Kind: "tap", Name: name, Reason: "no-associated-reservation"}, func() error {
+				return network.RemoveTap(id)
+			})
+		case strings.HasPrefix(name, "p-"):
+			id := strings.TrimPrefix(name, "p-")
+			if run.isWorkload(id) {
+				continue
+			}
+
+			_ = run.remove(Orphan{Kind: "tap", Name: name, Reason: "no-associated-reservation"}, func() error {
+				return network.RemovePubTap(id)
+			})
+		}
+	}
+
+	return nil
+}
+
+func (j *Janitor) cleanupNetworkResources(ctx context.Context, run *janitorRun) error {
+	network := stubs.NewNetworkerStub(j.zbus)
+
+	names, err := namespace.List("n-")
+	if err != nil {
+		return errors.Wrap(err, "failed to list network namespaces")
+	}
+
+	for _, name := range names {
+		netID := pkg.NetID(strings.TrimPrefix(name, "n-"))
+		if run.isNetwork(netID) {
+			continue
+		}
+
+		_ = run.remove(Orphan{Kind: "network-resource", Name: name, Reason: "no-associated-network"}, func() error {
+			return network.DeleteNR(pkg.NetResource{NetID: netID})
+		})
+	}
+
+	return nil
+}
+
 func socketDir(containerID string) string {
 	return fmt.Sprintf("/var/run/zdb_%s", containerID)
 }
@@ -0,0 +1,65 @@
+package provision
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func (c *TestCache) List() ([]*Reservation, error) {
+	returns := c.Called()
+	return returns.Get(0).([]*Reservation), returns.Error(1)
+}
+
+func TestJanitorInUse(t *testing.T) {
+	require := require.New(t)
+
+	nr, err := json.Marshal(pkg.NetResource{Name: "net"})
+	require.NoError(err)
+
+	cache := &TestCache{}
+	cache.On("List").Return([]*Reservation{
+		{ID: "1-1", Type: "container"},
+		{ID: "2-1", Reference: "1-2", Type: "volume"},
+		{ID: "3-1", User: "user", Type: "network", Data: nr},
+	}, nil)
+
+	janitor := NewJanitor(nil, nil, cache)
+	run := &janitorRun{}
+	require.NoError(janitor.loadInUse(run))
+
+	require.True(run.isWorkload("1-1"))
+	require.True(run.isWorkload("2-1"))
+	require.True(run.isWorkload("1-2"))
+	require.False(run.isWorkload("4-1"))
+
+	require.True(run.isNetwork(NetworkID("user", "net")))
+	require.False(run.isNetwork(NetworkID("other", "net")))
+}
+
+func TestJanitorReportOnly(t *testing.T) {
+	require := require.New(t)
+
+	orphan := Orphan{Kind: "vm", Name: "1-1", Reason: "no-associated-reservation"}
+	called := false
+	remove := func() error {
+		called = true
+		return nil
+	}
+
+	run := &janitorRun{reportOnly: true}
+	require.NoError(run.remove(orphan, remove))
+	require.False(called)
+	require.Equal([]Orphan{orphan}, run.orphans)
+
+	run = &janitorRun{}
+	require.NoError(run.remove(orphan, remove))
+	require.True(called)
+
+	run = &janitorRun{}
+	require.Error(run.remove(orphan, func() error { return errors.New("busy") }))
+	require.Equal([]Orphan{orphan}, run.orphans)
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const publicIPFilterPrefix = "r-"
+
+// PublicIPFilterName returns the name of the nft chains that filter
+// the traffic of the public ip reservation id
+func PublicIPFilterName(reservationID string) string {
+	return fmt.Sprintf("%s%s", publicIPFilterPrefix, reservationID)
+}
+
+// ListPublicIPFilters returns the reservation IDs of all the public ip
+// filter chains configured in the bridge filter table
+func ListPublicIPFilters(ctx context.Context) ([]string, error) {
+	output, err := exec.CommandContext(ctx, "nft", "list", "table", "bridge", "filter").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list firewall chains")
+	}
+
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "chain" {
+			continue
+		}
+
+		if !strings.HasPrefix(fields[1], publicIPFilterPrefix) {
+			continue
+		}
+
+		ids = append(ids, strings.TrimPrefix(fields[1], publicIPFilterPrefix))
+	}
+
+	return ids, scanner.Err()
+}
+
+// TeardownPublicIPFilters removes the nft chains named fName and the rules
+// jumping to them from the arp and bridge filter tables
+func TeardownPublicIPFilters(ctx context.Context, fName string) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c",
+		fmt.Sprintf(`# in bridge table
+nft 'flush chain bridge filter %[1]s'
+# jump to chain rule
+a=$( nft -a list table bridge filter | awk '/jump %[1]s/{ print $NF}' )
+nft 'delete rule bridge filter forward handle '${a}
+# chain itself
+a=$( nft -a list table bridge filter | awk '/chain %[1]s/{ print $NF}' )
+nft 'delete chain bridge filter handle '${a}
+
+# in arp table
+nft 'flush chain arp filter %[1]s'
+# jump to chain rule 
+a=$( nft -a list table arp filter | awk '/jump %[1]s/{ print $NF}' )
+nft 'delete rule arp filter input handle '${a}
+# chain itself
+a=$( nft -a list table arp filter | awk '/chain %[1]s/{ print $NF}' )
+nft 'delete chain arp filter handle '${a}`, fName))
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "could not setup firewall rules for public ip")
+	}
+	return nil
+}
@@ -2,14 +2,20 @@ package provision
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/pkg/errors"
 
-	"github.com/threefoldtech/zos/pkg"
 	"github.com/threefoldtech/zos/pkg/crypto"
 	"golang.org/x/crypto/ed25519"
 )
 
+var (
+	// ErrInvalidSignature is returned when the signature of a reservation
+	// doesn't match the key of the user
+	ErrInvalidSignature = fmt.Errorf("invalid reservation signature")
+)
+
 // Sign creates a signature from all the field of the reservation
 // object and fill the Signature field
 func (r *Reservation) Sign(privateKey ed25519.PrivateKey) error {
@@ -42,8 +48,13 @@ func (r *Reservation) Sign(privateKey ed25519.PrivateKey) error {
 	return nil
 }
 
-// Verify verifies the signature of the reservation
-func Verify(r *Reservation) error {
+// Verify verifies the signature of the reservation against the user publicKey
+// The signature is expected to be generated by Reservation.Sign
+func Verify(r *Reservation, publicKey ed25519.PublicKey) error {
+	if len(r.Signature) == 0 {
+		return errors.Wrap(ErrInvalidSignature, "reservation is not signed")
+	}
+
 	buf := &bytes.Buffer{}
 	//FIME: Since the ID is only set when the reservation is sent to bcdb
 	// we cannot use it in the signature. This is a problem
@@ -66,10 +77,9 @@ func Verify(r *Reservation) error {
 		return err
 	}
 
-	publicKey, err := crypto.KeyFromID(pkg.StrIdentifier(r.User))
-	if err != nil {
-		return errors.Wrap(err, "failed to extract public key from user ID")
+	if err := crypto.Verify(publicKey, buf.Bytes(), r.Signature); err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
 	}
 
-	return crypto.Verify(publicKey, buf.Bytes(), r.Signature)
+	return nil
 }
@@ -7,6 +7,8 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -16,7 +18,9 @@ import (
 	"github.com/shirou/gopsutil/mem"
 	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/crypto"
 	"github.com/threefoldtech/zos/pkg/stubs"
+	"golang.org/x/crypto/ed25519"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -26,6 +30,10 @@ const gib = 1024 * 1024 * 1024
 
 const minimunZosMemory = 2 * gib
 
+// pendingExitTimeout is how long the exit of a job that
+// is not cached yet is kept
+const pendingExitTimeout = time.Hour
+
 // Engine is the core of this package
 // The engine is responsible to manage provision and decomission of workloads on the system
 type Engine struct {
@@ -35,13 +43,33 @@ type Engine struct {
 	feedback       Feedbacker
 	provisioners   map[ReservationType]ProvisionerFunc
 	decomissioners map[ReservationType]DecomissionerFunc
+	updaters       map[ReservationType]UpdaterFunc
+	retryDeadlines map[ReservationType]time.Duration
+	gracePeriod    time.Duration
+	persistent     map[ReservationType]bool
+	expiryWarnings []time.Duration
 	signer         Signer
+	users          UserKeyGetter
 	statser        Statser
+	capacity       CapacityChecker
+	quota          QuotaChecker
+	meter          Meter
+	external       *ExternalProvisioners
 	zbusCl         zbus.Client
 	janitor        *Janitor
+	journal        *Journal
+	events         *events
+	graph          *graph
+	cascade        bool
+	workers        int
+	keys           ReservationKeysFunc
 
 	memCache          *cache.Cache
 	totalMemAvailable uint64
+	statsM            sync.Mutex
+	admitM            sync.Mutex
+	exitsM            sync.Mutex
+	exits             map[string]pendingExit
 }
 
 // EngineOps are the configuration of the engine
@@ -62,25 +90,88 @@ type EngineOps struct {
 	// Decomissioners contains the opposite function from Provisioners
 	// they are used to decomission workloads from the system
 	Decomissioners map[ReservationType]DecomissionerFunc
+	// Updaters are used to change the configuration of a deployed workload without
+	// decommissioning it. A workload is updated when a reservation referencing it, or a
+	// newer version of the same reservation, is received with a different content.
+	// Types without updater can't be changed once deployed
+	Updaters map[ReservationType]UpdaterFunc
+	// RetryDeadlines is how long the engine keeps retrying the provision of a reservation
+	// type when the provisioner returns a retryable error (see Retryable) before reporting
+	// the failure. Types not in the map use DefaultRetryDeadline, a deadline of 0
+	// disables retries for the type
+	RetryDeadlines map[ReservationType]time.Duration
+	// GracePeriod is how long the data of an expired reservation is kept. Workloads are
+	// stopped as soon as their reservation expires, but the types in Persistent are only
+	// decommissioned once the grace period is over, so a late renewal doesn't lose data.
+	// Only reservations with a limited duration expire on the node, the reservations of
+	// the explorer use NoExpiry and are removed with ToDelete as soon as their capacity
+	// pool runs out, the grace period doesn't apply to them
+	GracePeriod time.Duration
+	// Persistent are the reservation types that hold user data and are
+	// kept during the grace period
+	Persistent map[ReservationType]bool
+	// ExpiryWarnings are the durations before the expiration of a reservation at which
+	// the engine warns the owner using Feedback.Expiring. One warning is sent per
+	// duration. If not set, no warning is sent. Like GracePeriod, it doesn't apply to
+	// the reservations that don't expire on the node
+	ExpiryWarnings []time.Duration
 	// Signer is used to authenticate the result send to the source
 	Signer Signer
+	// Users is used to retrieve the public key of the users to verify
+	// the signature of the reservations. If not set, signatures are not verified
+	Users UserKeyGetter
 	// Statser is responsible to keep track of how much workloads and resource units
 	// are reserved on the system running the engine
 	// After each provision/decomission the engine sends statistics update to the staster
 	Statser Statser
+	// Capacity is used to check that the node has enough free resource units
+	// to deploy a reservation. Reservations that don't fit are refused with an
+	// insufficient capacity result. If not set, no admission check is done
+	Capacity CapacityChecker
+	// Quota is used to check that the user of a reservation stays within
+	// its quota. Reservations going over the quota are refused. If not set,
+	// users are not limited
+	Quota QuotaChecker
+	// Meter is used to produce the usage reports of the users. If not set,
+	// usage reports are not available
+	Meter Meter
+	// External routes the reservations of the types registered by external
+	// daemons to them. If not set, only the built-in types are supported
+	External *ExternalProvisioners
 	// ZbusCl is a client to Zbus
 	ZbusCl zbus.Client
 
 	// Janitor is used to clean up some of the resources that might be lingering on the node
 	// if not set, no cleaning up will be done
 	Janitor *Janitor
+
+	// Journal is used to persist the lifecycle state of the reservations. On start
+	// the engine rolls back or resumes the reservations left in an intermediate state
+	// by a crash. If not set, no state is persisted
+	Journal *Journal
+
+	// Workers is the number of reservations the engine processes concurrently
+	// if not set, the engine processes one reservation at a time
+	Workers int
+	// Keys returns the resources used by a reservation. Reservations that
+	// share a resource are always processed in order, even when Workers > 1
+	Keys ReservationKeysFunc
+	// Dependencies returns the resources a reservation provides and uses. A reservation
+	// is only provisioned once all the resources it uses are deployed, it waits up to
+	// its retry deadline for them. If not set, dependencies are not tracked
+	Dependencies DependenciesFunc
+	// Cascade controls what happens when a reservation used by others is decommissioned.
+	// If true, the reservations using it are decommissioned first. Otherwise the
+	// decommission is refused with ErrInUse, reported to the owner, and done once
+	// the reservations using it are removed
+	Cascade bool
 }
 
 // New creates a new engine. Once started, the engine
 // will continue processing all reservations from the reservation source
 // and try to apply them.
-// the default implementation is a single threaded worker. so it process
-// one reservation at a time. On error, the engine will log the error. and
+// the engine process up to opts.Workers reservations in parallel (one at a time
+// by default). On error, the engine will log the error. and
 // continue to next reservation.
 func New(opts EngineOps) (*Engine, error) {
 	memStats, err := mem.VirtualMemory()
@@ -95,12 +186,29 @@ func New(opts EngineOps) (*Engine, error) {
 		feedback:          opts.Feedback,
 		provisioners:      opts.Provisioners,
 		decomissioners:    opts.Decomissioners,
+		updaters:          opts.Updaters,
+		retryDeadlines:    opts.RetryDeadlines,
+		gracePeriod:       opts.GracePeriod,
+		persistent:        opts.Persistent,
+		expiryWarnings:    opts.ExpiryWarnings,
 		signer:            opts.Signer,
+		users:             opts.Users,
 		statser:           opts.Statser,
+		capacity:          opts.Capacity,
+		quota:             opts.Quota,
+		meter:             opts.Meter,
+		external:          opts.External,
 		zbusCl:            opts.ZbusCl,
 		janitor:           opts.Janitor,
+		journal:           opts.Journal,
+		events:            newEvents(),
+		graph:             newGraph(opts.Dependencies),
+		cascade:           opts.Cascade,
+		workers:           opts.Workers,
+		keys:              opts.Keys,
 		memCache:          cache.New(30*time.Minute, 30*time.Second),
 		totalMemAvailable: memStats.Total - minimunZosMemory,
+		exits:             make(map[string]pendingExit),
 	}, nil
 }
 
@@ -122,6 +230,30 @@ func (e *Engine) Run(ctx context.Context) error {
 	c.Start()
 	defer c.Stop()
 
+	if e.journal != nil {
+		if err := e.recover(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to recover interrupted reservations")
+		}
+	}
+
+	if len(e.expiryWarnings) > 0 {
+		go e.watchExpiry(ctx)
+	}
+
+	var waitExpired <-chan time.Time
+	if e.graph != nil {
+		if err := e.loadGraph(); err != nil {
+			log.Error().Err(err).Msg("failed to load dependencies of deployed reservations")
+		}
+
+		ticker := time.NewTicker(dependenciesCheckInterval)
+		defer ticker.Stop()
+		waitExpired = ticker.C
+	}
+
+	workers := newScheduler(e.workers, e.keys)
+	defer workers.Close()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -152,12 +284,23 @@ func (e *Engine) Run(ctx context.Context) error {
 				Bool("expired", expired).
 				Logger()
 
+			if expired && !reservation.ToDelete && e.inGracePeriod(&reservation.Reservation) {
+				slog.Debug().Msg("reservation expired, keeping its data during the grace period")
+				continue
+			}
+
 			if expired || reservation.ToDelete {
 				slog.Info().Msg("start decommissioning reservation")
-				if err := e.decommission(ctx, &reservation.Reservation); err != nil {
-					log.Error().Err(err).Msgf("failed to decommission reservation %s", reservation.ID)
-					continue
-				}
+				workers.Schedule(&reservation.Reservation, func() {
+					if err := e.decommission(ctx, &reservation.Reservation); err != nil {
+						log.Error().Err(err).Msgf("failed to decommission reservation %s", reservation.ID)
+						return
+					}
+
+					if err := e.updateStats(); err != nil {
+						log.Error().Err(err).Msg("failed to updated the capacity counters")
+					}
+				})
 			} else {
 				slog.Info().Msg("start provisioning reservation")
 
@@ -165,21 +308,64 @@ func (e *Engine) Run(ctx context.Context) error {
 				// this is just a hack now to avoid having double provisioning
 				// other logs has been added in other places so we can find why
 				// the node keep receiving the same reservation twice
-				if _, ok := e.memCache.Get(reservation.ID); ok {
+				if err := e.memCache.Add(processedKey(&reservation.Reservation), struct{}{}, cache.DefaultExpiration); err != nil {
 					log.Debug().Str("id", reservation.ID).Msg("skipping reservation since it has just been processes!")
 					continue
 				}
 
-				e.memCache.Set(reservation.ID, struct{}{}, cache.DefaultExpiration)
+				workers.Schedule(&reservation.Reservation, func() {
+					if err := e.provision(ctx, &reservation.Reservation); err != nil {
+						log.Error().Err(err).Msgf("failed to provision reservation %s", reservation.ID)
+						return
+					}
 
-				if err := e.provision(ctx, &reservation.Reservation); err != nil {
-					log.Error().Err(err).Msgf("failed to provision reservation %s", reservation.ID)
-					continue
-				}
+					if err := e.updateStats(); err != nil {
+						log.Error().Err(err).Msg("failed to updated the capacity counters")
+					}
+				})
+			}
+
+		case <-e.graph.readyC():
+			for _, r := range e.graph.takeReleased() {
+				r := r
+				log.Info().Str("id", r.ID).Msg("reservation not used anymore, start decommissioning reservation")
+				workers.Schedule(r, func() {
+					if err := e.decommission(ctx, r); err != nil {
+						log.Error().Err(err).Msgf("failed to decommission reservation %s", r.ID)
+						return
+					}
+
+					if err := e.updateStats(); err != nil {
+						log.Error().Err(err).Msg("failed to updated the capacity counters")
+					}
+				})
+			}
+
+			for _, r := range e.graph.takeReady() {
+				r := r
+				log.Info().Str("id", r.ID).Msg("dependencies deployed, start provisioning reservation")
+				workers.Schedule(r, func() {
+					if err := e.provision(ctx, r); err != nil {
+						log.Error().Err(err).Msgf("failed to provision reservation %s", r.ID)
+						return
+					}
+
+					if err := e.updateStats(); err != nil {
+						log.Error().Err(err).Msg("failed to updated the capacity counters")
+					}
+				})
 			}
 
-			if err := e.updateStats(); err != nil {
-				log.Error().Err(err).Msg("failed to updated the capacity counters")
+		case now := <-waitExpired:
+			for _, w := range e.graph.expired(now) {
+				w := w
+				log.Warn().Str("id", w.reservation.ID).Strs("missing", w.missing).Msg("dependencies of reservation not deployed in time")
+				workers.Schedule(w.reservation, func() {
+					reason := fmt.Errorf("dependencies not deployed: %s", strings.Join(w.missing, ", "))
+					if err := e.reject(ctx, w.reservation, w.since, reason); err != nil {
+						log.Error().Err(err).Msgf("reservation %s rejected", w.reservation.ID)
+					}
+				})
 			}
 
 		case <-cleanUp:
@@ -195,6 +381,10 @@ func (e *Engine) Run(ctx context.Context) error {
 				continue
 			}
 
+			// make sure no workload is being deployed while
+			// the janitor is looking for lingering resources
+			workers.Wait()
+
 			if err := e.janitor.CleanupResources(ctx); err != nil {
 				log.Error().Err(err).Msg("failed to cleanup resources")
 				continue
@@ -203,23 +393,60 @@ func (e *Engine) Run(ctx context.Context) error {
 	}
 }
 
+// processedKey is the key of r in the cache of the reservations that
+// have just been processed. A new version or a renewal of a reservation
+// has a different key so the update is not skipped
+func processedKey(r *Reservation) string {
+	return fmt.Sprintf("%s@%d@%d", r.ID, r.Version, r.Expiry().Unix())
+}
+
 func (e *Engine) provision(ctx context.Context, r *Reservation) error {
+	start := time.Now()
+
 	if err := r.validate(); err != nil {
 		return errors.Wrapf(err, "failed validation of reservation")
 	}
 
-	fn, ok := e.provisioners[r.Type]
+	if err := e.verify(ctx, r); err != nil {
+		if !errors.Is(err, ErrInvalidSignature) {
+			// we could not check the signature (for example the user key
+			// could not be retrieved), so we can't say the reservation is
+			// invalid. It is forgotten so it is processed again the next
+			// time the source sends it
+			e.memCache.Delete(processedKey(r))
+			return errors.Wrapf(err, "failed to verify reservation %s", r.ID)
+		}
+
+		log.Warn().Err(err).Str("id", r.ID).Msg("verification of reservation signature failed")
+		return e.reject(ctx, r, start, err)
+	}
+
+	fn, ok := e.provisioner(r.Type)
 	if !ok {
 		return fmt.Errorf("type of reservation not supported: %s", r.Type)
 	}
 
 	if r.Reference != "" {
+		if old, err := e.cache.Get(r.Reference); err == nil && e.isUpdate(old, r) {
+			return e.update(ctx, old, r, start)
+		}
+
 		if err := e.migrateToPool(ctx, r); err != nil {
 			return err
 		}
 	}
 
 	if cached, err := e.cache.Get(r.ID); err == nil {
+		if e.isUpdate(cached, r) {
+			return e.update(ctx, cached, r, start)
+		}
+
+		if !cached.Expiry().Equal(r.Expiry()) {
+			if err := e.renew(cached, r); err != nil {
+				return err
+			}
+		}
+
 		log.Info().Str("id", r.ID).Msg("reservation have already been processed")
 		if cached.Result.IsNil() {
 			// this is probably an older reservation that is cached BEFORE
@@ -229,7 +456,8 @@ func (e *Engine) provision(ctx context.Context, r *Reservation) error {
 		}
 
 		// otherwise, it's safe to resend the same result
-		// back to the grid.
+		// back to the grid. This also replaces the expiration
+		// warning of a renewed reservation
 		if err := e.reply(ctx, &cached.Result); err != nil {
 			log.Error().Err(err).Msg("failed to send result to BCDB")
 		}
@@ -237,14 +465,37 @@ func (e *Engine) provision(ctx context.Context, r *Reservation) error {
 		return nil
 	}
 
+	if missing := e.graph.park(r, start, start.Add(e.retryDeadline(r.Type))); len(missing) > 0 {
+		log.Info().Str("id", r.ID).Strs("missing", missing).Msg("reservation waits for its dependencies to be deployed")
+		e.setState(r, LifecycleWaiting)
+		e.emit(r.ID, r.Type, pkg.PhaseWaiting, start, nil)
+		return nil
+	}
+
+	e.setState(r, LifecycleReceived)
+	e.emit(r.ID, r.Type, pkg.PhaseReceived, start, nil)
+
+	release, err := e.admit(r, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("id", r.ID).Msg("reservation refused")
+		return e.reject(ctx, r, start, err)
+	}
+
+	e.setState(r, LifecycleProvisioning)
+	e.emit(r.ID, r.Type, pkg.PhaseProvisioning, start, nil)
+
 	// to ensure old reservation workload that are already running
 	// keeps running as it is, we use the reference as new workload ID
 	realID := r.ID
 	if r.Reference != "" {
 		r.ID = r.Reference
 	}
+	workloadID := r.ID
 
-	returned, provisionError := e.provisionForward(ctx, fn, r)
+	returned, provisionError := e.provisionForward(ctx, fn, r, func(err error, d time.Duration) {
+		log.Warn().Err(err).Str("id", realID).Msgf("provision failed with a transient error, retrying in %s", d)
+		e.emit(realID, r.Type, pkg.PhaseRetrying, start, err)
+	})
 
 	result, err := e.buildResult(realID, r.Type, provisionError, returned)
 	if err != nil {
@@ -256,56 +507,417 @@ func (e *Engine) provision(ctx context.Context, r *Reservation) error {
 		log.Error().Err(err).Msg("failed to send result to BCDB")
 	}
 
+	r.ID = realID
+
 	// if we fail to decomission the reservation then must be marked
 	// as deleted so it's never tried again. we also skip caching
 	// the reservation object. this is similar to what decomission does
 	// since on a decomission we also clear up the cache.
 	if provisionError != nil {
+		release()
+		e.popExit(workloadID)
+
 		// we need to mark the reservation as deleted as well
 		if err := e.feedback.Deleted(e.nodeID, realID); err != nil {
 			log.Error().Err(err).Msg("failed to mark failed reservation as deleted")
 		}
 
+		e.setState(r, LifecycleFailed)
+		e.emit(r.ID, r.Type, pkg.PhaseFailed, start, provisionError)
 		return provisionError
 	}
 
-	// we only cache successful reservations
+	// we only cache successful reservations. exitsM is held until the
+	// reservation is fully deployed, so a job exiting meanwhile is either
+	// kept as pending or sees the deployed reservation
+	r.Result = *result
+	e.exitsM.Lock()
+	if err := e.cache.Add(r); err != nil {
+		e.takeExit(workloadID)
+		e.exitsM.Unlock()
+		release()
+		return errors.Wrapf(err, "failed to cache reservation %s locally", r.ID)
+	}
+
+	e.graph.add(r)
+	e.setState(r, LifecycleDeployed)
+	exit, exited := e.takeExit(workloadID)
+	e.exitsM.Unlock()
+
+	e.emit(r.ID, r.Type, pkg.PhaseDeployed, start, nil)
+
+	// a job can exit before it is cached, its exit
+	// is applied now that the reservation is known
+	if exited {
+		return e.jobExited(workloadID, exit.result)
+	}
+
+	return nil
+}
+
+// renew sets the expiration of the deployed reservation old to the one of r
+func (e *Engine) renew(old, r *Reservation) error {
+	log.Info().
+		Str("id", r.ID).
+		Time("old_expiry", old.Expiry()).
+		Time("expiry", r.Expiry()).
+		Msg("reservation renewed")
+
+	old.Created = r.Created
+	old.Duration = r.Duration
+
+	if err := e.cache.Remove(old.ID); err != nil {
+		return errors.Wrapf(err, "failed to remove reservation %s from cache", old.ID)
+	}
+
+	if err := e.cache.Add(old); err != nil {
+		return errors.Wrapf(err, "failed to cache reservation %s locally", old.ID)
+	}
+
+	return nil
+}
+
+// isUpdate checks if r is a new configuration of the already deployed reservation old
+func (e *Engine) isUpdate(old, r *Reservation) bool {
+	if _, ok := e.updaters[r.Type]; !ok || old.Type != r.Type {
+		return false
+	}
+
+	if bytes.Equal(old.Data, r.Data) {
+		return false
+	}
+
+	// a reservation referencing the old one, or a newer version of the same reservation
+	return old.ID != r.ID || r.Version > old.Version
+}
+
+// update applies the new configuration r to the workload deployed by old
+func (e *Engine) update(ctx context.Context, old, r *Reservation, start time.Time) error {
+	fn := e.updaters[r.Type]
+
+	// the workload keeps the ID it has been deployed with
+	workloadID := old.ID
+	if old.Reference != "" {
+		workloadID = old.Reference
+	}
+
+	log.Info().
+		Str("id", r.ID).
+		Str("old_id", old.ID).
+		Str("workload", workloadID).
+		Msg("updating deployed workload")
+	e.emit(r.ID, r.Type, pkg.PhaseUpdating, start, nil)
+
+	oldWl := *old
+	oldWl.ID = workloadID
+	realID := r.ID
+	r.ID = workloadID
+
+	returned, updateError := e.updateForward(ctx, fn, &oldWl, r)
+
 	r.ID = realID
+	if realID != workloadID {
+		r.Reference = workloadID
+	}
+
+	result, err := e.buildResult(realID, r.Type, updateError, returned)
+	if err != nil {
+		return errors.Wrapf(err, "failed to build result object for reservation: %s", realID)
+	}
+
+	if err := e.reply(ctx, result); err != nil {
+		log.Error().Err(err).Msg("failed to send result to BCDB")
+	}
+
+	if updateError != nil {
+		// the workload keeps running with its old configuration
+		// so the old reservation stays in the cache
+		e.emit(realID, r.Type, pkg.PhaseFailed, start, updateError)
+		return updateError
+	}
+
+	if err := e.cache.Remove(old.ID); err != nil {
+		return errors.Wrapf(err, "failed to remove reservation %s from cache", old.ID)
+	}
+
 	r.Result = *result
 	if err := e.cache.Add(r); err != nil {
 		return errors.Wrapf(err, "failed to cache reservation %s locally", r.ID)
 	}
 
-	// If an update occurs on the network we don't increment the counter
-	if r.Type == "network_resource" {
-		nr := pkg.NetResource{}
-		if err := json.Unmarshal(r.Data, &nr); err != nil {
-			return fmt.Errorf("failed to unmarshal network from reservation: %w", err)
+	e.graph.remove(old)
+	e.graph.add(r)
+
+	if old.ID != r.ID {
+		e.removeState(old.ID)
+	}
+	e.setState(r, LifecycleDeployed)
+	e.emit(r.ID, r.Type, pkg.PhaseUpdated, start, nil)
+
+	return nil
+}
+
+func (e *Engine) updateForward(ctx context.Context, fn UpdaterFunc, old, r *Reservation) (interface{}, error) {
+	// the old reservation is swapped for the new one in the counters,
+	// so the capacity checks only account for what the update adds
+	release, err := e.admit(r, old)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to apply update")
+	}
+
+	returned, err := fn(ctx, old, r)
+	if err != nil {
+		log.Error().Err(err).Str("id", r.ID).Msg("failed to apply update")
+		release()
+		if err := e.statser.Increment(old); err != nil {
+			log.Err(err).Str("reservation_id", old.ID).Msg("failed to increment workloads statistics")
 		}
+		return nil, err
+	}
 
-		uniqueID := NetworkID(r.User, nr.Name)
-		exists, err := e.cache.NetworkExists(string(uniqueID))
-		if err != nil {
-			return errors.Wrap(err, "failed to check if network exists")
+	log.Info().
+		Str("result", fmt.Sprintf("%v", returned)).
+		Msgf("workload updated")
+
+	return returned, nil
+}
+
+// verify checks that the reservation has been signed by its user. Failing
+// to retrieve the user key is retried until the retry deadline of r
+func (e *Engine) verify(ctx context.Context, r *Reservation) error {
+	if e.users == nil || r.Verified {
+		return nil
+	}
+
+	var key ed25519.PublicKey
+	err := retry(ctx, e.retryDeadline(r.Type), func() (err error) {
+		key, err = e.users.PublicKey(r.User)
+		return Retryable(err)
+	}, func(err error, d time.Duration) {
+		log.Warn().Err(err).Str("id", r.ID).Msgf("failed to retrieve user public key, retrying in %s", d)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to retrieve public key of user %s", r.User)
+	}
+
+	return Verify(r, key)
+}
+
+// VerifyToken implements pkg.Provision. The token must not be expired, must
+// not expire more than pkg.AccessTokenMaxLifetime from now, and must be signed
+// by the owner of the reservation it was issued for. id is the id of the
+// workload, which is the reference of reservations updating an older one
+func (e *Engine) VerifyToken(id string, token pkg.AccessToken) error {
+	now := time.Now()
+	if now.After(token.Expiry) {
+		return fmt.Errorf("token expired at %s", token.Expiry)
+	}
+
+	if token.Expiry.After(now.Add(pkg.AccessTokenMaxLifetime)) {
+		return fmt.Errorf("token expires at %s, tokens can't be valid for more than %s", token.Expiry, pkg.AccessTokenMaxLifetime)
+	}
+
+	if e.users == nil {
+		return fmt.Errorf("user keys are not available, tokens can't be verified")
+	}
+
+	r, err := e.workload(id)
+	if err != nil {
+		return errors.Wrapf(err, "failed to retrieve reservation %s", id)
+	}
+
+	if token.Reservation != r.ID && token.Reservation != id {
+		return fmt.Errorf("token is not valid for reservation %s", r.ID)
+	}
+
+	key, err := e.users.PublicKey(r.User)
+	if err != nil {
+		return errors.Wrapf(err, "failed to retrieve public key of user %s", r.User)
+	}
+
+	if err := crypto.Verify(key, token.SignedBytes(), token.Signature); err != nil {
+		return errors.Wrap(err, "invalid token")
+	}
+
+	return nil
+}
+
+// admit checks that the node has enough free capacity to deploy r and that
+// its user stays within its quota. pkg.ErrInsufficientCapacity or
+// pkg.ErrQuotaExceeded is returned if it is not the case.
+//
+// An admitted reservation is reserved in the counters right away, under the
+// same lock as the checks, so concurrent reservations can't be admitted on
+// the same free capacity or quota. The returned release function must be
+// called if r fails to deploy. replaced is the reservation r updates if any,
+// it is released before the checks and reserved again if r is not admitted
+func (e *Engine) admit(r *Reservation, replaced *Reservation) (release func(), err error) {
+	e.admitM.Lock()
+	defer e.admitM.Unlock()
+
+	if replaced != nil {
+		if err := e.statser.Decrement(replaced); err != nil {
+			log.Err(err).Str("reservation_id", replaced.ID).Msg("failed to decrement workloads statistics")
 		}
-		if exists {
-			return nil
+	}
+
+	counted, err := e.counted(r)
+	if err == nil {
+		err = e.check(r)
+	}
+
+	if err != nil {
+		if replaced != nil {
+			if err := e.statser.Increment(replaced); err != nil {
+				log.Err(err).Str("reservation_id", replaced.ID).Msg("failed to increment workloads statistics")
+			}
 		}
+		return nil, err
+	}
+
+	if !counted {
+		return func() {}, nil
 	}
 
 	if err := e.statser.Increment(r); err != nil {
 		log.Err(err).Str("reservation_id", r.ID).Msg("failed to increment workloads statistics")
 	}
 
-	return nil
+	return func() {
+		if err := e.statser.Decrement(r); err != nil {
+			log.Err(err).Str("reservation_id", r.ID).Msg("failed to decrement workloads statistics")
+		}
+	}, nil
 }
 
-func (e *Engine) provisionForward(ctx context.Context, fn ProvisionerFunc, r *Reservation) (interface{}, error) {
+// counted returns false if r doesn't count as a new workload. It is
+// the case of a network resource of a network that is already deployed
+func (e *Engine) counted(r *Reservation) (bool, error) {
+	if r.Type != "network_resource" {
+		return true, nil
+	}
+
+	nr := pkg.NetResource{}
+	if err := json.Unmarshal(r.Data, &nr); err != nil {
+		return false, fmt.Errorf("failed to unmarshal network from reservation: %w", err)
+	}
+
+	exists, err := e.cache.NetworkExists(string(NetworkID(r.User, nr.Name)))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check if network exists")
+	}
+
+	return !exists, nil
+}
+
+// check does the admission checks of admit
+func (e *Engine) check(r *Reservation) error {
 	if err := e.statser.CheckMemoryRequirements(r, e.totalMemAvailable); err != nil {
-		return nil, errors.Wrapf(err, "failed to apply provision")
+		return err
+	}
+
+	if e.capacity != nil {
+		admission, err := e.capacity.Check(r)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check capacity for reservation %s", r.ID)
+		}
+
+		if !admission.Admitted() {
+			return pkg.ErrInsufficientCapacity{Admission: admission}
+		}
+	}
+
+	if e.quota != nil {
+		if err := e.quota.Check(r); err != nil {
+			var quotaErr pkg.ErrQuotaExceeded
+			if errors.As(err, &quotaErr) {
+				return err
+			}
+			return errors.Wrapf(err, "failed to check quota for reservation %s", r.ID)
+		}
+	}
+
+	return nil
+}
+
+// CheckCapacity is a dry run of the admission check of the engine. It returns
+// the admission result of a reservation of type typ without provisioning it
+func (e *Engine) CheckCapacity(typ string, data []byte) (pkg.Admission, error) {
+	if e.capacity == nil {
+		return pkg.Admission{}, fmt.Errorf("capacity check is not configured")
+	}
+
+	return e.capacity.Check(&Reservation{
+		Type: ReservationType(typ),
+		Data: data,
+	})
+}
+
+// Usage returns the resources reserved by each user
+// of the node, along with the quota applied to them
+func (e *Engine) Usage() ([]pkg.UserUsage, error) {
+	if e.quota == nil {
+		return nil, fmt.Errorf("quotas are not configured")
+	}
+
+	return e.quota.Usage(), nil
+}
+
+// UsageReport returns the signed report of the resources
+// consumed by user between from and to
+func (e *Engine) UsageReport(user string, from, to time.Time) (pkg.UsageReport, error) {
+	if e.meter == nil {
+		return pkg.UsageReport{}, fmt.Errorf("metering is not configured")
+	}
+
+	return e.meter.Report(user, from, to)
+}
+
+// reject sends an error result for a reservation that is not going
+// to be provisioned and marks it as deleted
+func (e *Engine) reject(ctx context.Context, r *Reservation, start time.Time, reason error) error {
+	// when refused for capacity or quota reasons, the result
+	// data holds the details of the admission check
+	var info interface{}
+	var capacityErr pkg.ErrInsufficientCapacity
+	var quotaErr pkg.ErrQuotaExceeded
+	if errors.As(reason, &capacityErr) {
+		info = capacityErr.Admission
+	} else if errors.As(reason, &quotaErr) {
+		info = quotaErr.Usage
 	}
 
-	returned, provisionError := fn(ctx, r)
+	result, err := e.buildResult(r.ID, r.Type, reason, info)
+	if err != nil {
+		return errors.Wrapf(err, "failed to build result object for reservation: %s", r.ID)
+	}
+
+	if err := e.reply(ctx, result); err != nil {
+		log.Error().Err(err).Msg("failed to send result to BCDB")
+	}
+
+	if err := e.feedback.Deleted(e.nodeID, r.ID); err != nil {
+		log.Error().Err(err).Msg("failed to mark rejected reservation as deleted")
+	}
+
+	e.setState(r, LifecycleFailed)
+	e.emit(r.ID, r.Type, pkg.PhaseRejected, start, reason)
+	return reason
+}
+
+func (e *Engine) provisionForward(ctx context.Context, fn ProvisionerFunc, r *Reservation, notify backoff.Notify) (interface{}, error) {
+	var returned interface{}
+	provisionError := retry(ctx, e.retryDeadline(r.Type), func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = recovered(p)
+			}
+		}()
+
+		returned, err = fn(ctx, r)
+		return err
+	}, notify)
+
 	if provisionError != nil {
 		log.Error().
 			Err(provisionError).
@@ -321,8 +933,18 @@ func (e *Engine) provisionForward(ctx context.Context, fn ProvisionerFunc, r *Re
 	return returned, nil
 }
 
+func (e *Engine) retryDeadline(typ ReservationType) time.Duration {
+	if deadline, ok := e.retryDeadlines[typ]; ok {
+		return deadline
+	}
+
+	return DefaultRetryDeadline
+}
+
 func (e *Engine) decommission(ctx context.Context, r *Reservation) error {
-	fn, ok := e.decomissioners[r.Type]
+	start := time.Now()
+
+	fn, ok := e.decomissioner(r.Type)
 	if !ok {
 		return fmt.Errorf("type of reservation not supported: %s", r.Type)
 	}
@@ -337,16 +959,11 @@ func (e *Engine) decommission(ctx context.Context, r *Reservation) error {
 		if err := e.feedback.Deleted(e.nodeID, r.ID); err != nil {
 			log.Error().Err(err).Str("id", r.ID).Msg("failed to mark reservation as deleted")
 		}
+		e.setState(r, LifecycleDeleted)
+		e.emit(r.ID, r.Type, pkg.PhaseDecommissioned, start, nil)
 		return nil
 	}
 
-	// to ensure old reservation can be deleted
-	// we use the reference as workload ID
-	realID := r.ID
-	if r.Reference != "" {
-		r.ID = r.Reference
-	}
-
 	if r.Result.State == StateError {
 		// this reservation already failed to deploy
 		// this code here shouldn't be executing because if
@@ -355,25 +972,68 @@ func (e *Engine) decommission(ctx context.Context, r *Reservation) error {
 		// BUT
 		// that was not always the case, so instead we
 		// will just return. here
-		log.Warn().Str("id", realID).Msg("skipping reservation because it is not provisioned")
+		log.Warn().Str("id", r.ID).Msg("skipping reservation because it is not provisioned")
 		return nil
 	}
 
+	if err := e.decommissionDependents(ctx, r); err != nil {
+		var inUse ErrInUse
+		if errors.As(err, &inUse) {
+			e.refuse(ctx, r, start, err)
+			return err
+		}
+
+		e.emit(r.ID, r.Type, pkg.PhaseFailed, start, err)
+		return err
+	}
+
+	e.setState(r, LifecycleDecommissioning)
+	e.emit(r.ID, r.Type, pkg.PhaseDecommissioning, start, nil)
+
+	// to ensure old reservation can be deleted
+	// we use the reference as workload ID
+	realID := r.ID
+	if r.Reference != "" {
+		r.ID = r.Reference
+	}
+
 	err = fn(ctx, r)
 	if err != nil {
+		e.emit(realID, r.Type, pkg.PhaseFailed, start, err)
 		return errors.Wrap(err, "decommissioning of reservation failed")
 	}
 
 	r.ID = realID
 
-	if err := e.cache.Remove(r.ID); err != nil {
-		return errors.Wrapf(err, "failed to remove reservation %s from cache", r.ID)
+	// the meter records the usage of the reservation
+	// as it is removed from the cache
+	var removeErr error
+	remove := func() error {
+		removeErr = e.cache.Remove(r.ID)
+		return removeErr
+	}
+
+	if e.meter != nil {
+		if err := e.meter.Decommissioned(r, remove); err != nil && removeErr == nil {
+			log.Error().Err(err).Str("id", r.ID).Msg("failed to record usage of decommissioned reservation")
+		}
+	} else {
+		_ = remove()
 	}
 
+	if removeErr != nil {
+		return errors.Wrapf(removeErr, "failed to remove reservation %s from cache", r.ID)
+	}
+
+	e.graph.remove(r)
+
 	if err := e.statser.Decrement(r); err != nil {
 		log.Err(err).Str("reservation_id", r.ID).Msg("failed to decrement workloads statistics")
 	}
 
+	e.setState(r, LifecycleDeleted)
+	e.emit(r.ID, r.Type, pkg.PhaseDecommissioned, start, nil)
+
 	if err := e.feedback.Deleted(e.nodeID, r.ID); err != nil {
 		return errors.Wrap(err, "failed to mark reservation as deleted")
 	}
@@ -381,6 +1041,147 @@ func (e *Engine) decommission(ctx context.Context, r *Reservation) error {
 	return nil
 }
 
+// setState persists the lifecycle state of r if the journal is configured
+func (e *Engine) setState(r *Reservation, state LifecycleState) {
+	if e.journal == nil {
+		return
+	}
+
+	if err := e.journal.Set(r, state); err != nil {
+		log.Error().Err(err).Str("id", r.ID).Str("state", string(state)).Msg("failed to persist reservation state")
+	}
+}
+
+func (e *Engine) removeState(id string) {
+	if e.journal == nil {
+		return
+	}
+
+	if err := e.journal.Remove(id); err != nil {
+		log.Error().Err(err).Str("id", id).Msg("failed to remove reservation state")
+	}
+}
+
+// recover goes over the journal and handles the reservations left in
+// an intermediate state by a previous run of the engine.
+//   - received: nothing was deployed yet, the entry is dropped and the
+//     source sends the reservation again
+//   - waiting: the reservation waits again for its dependencies, until
+//     the deadline it had before the restart
+//   - provisioning: the partially deployed workload is rolled back, then
+//     the reservation is provisioned again when the source sends it
+//   - in-use: the decommission waits again for the reservations
+//     using it to be removed
+//   - decommissioning: the decommission is resumed
+func (e *Engine) recover(ctx context.Context) error {
+	entries, err := e.journal.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list journal entries")
+	}
+
+	for _, entry := range entries {
+		r := entry.Reservation
+		slog := log.With().Str("id", r.ID).Str("state", string(entry.State)).Logger()
+
+		switch entry.State {
+		case LifecycleReceived:
+			slog.Info().Msg("dropping reservation received before restart")
+			e.removeState(r.ID)
+
+		case LifecycleWaiting:
+			exists, err := e.cache.Exists(r.ID)
+			if err != nil {
+				slog.Error().Err(err).Msg("failed to check if reservation exists in cache")
+				continue
+			}
+
+			if exists {
+				e.setState(r, LifecycleDeployed)
+				continue
+			}
+
+			// the graph is loaded afterward, reservations with all
+			// their dependencies deployed are provisioned right away
+			if missing := e.graph.park(r, entry.Updated, entry.Updated.Add(e.retryDeadline(r.Type))); len(missing) == 0 {
+				// dependencies are not tracked anymore
+				e.removeState(r.ID)
+				continue
+			}
+			slog.Info().Msg("reservation waits again for its dependencies")
+
+		case LifecycleProvisioning:
+			exists, err := e.cache.Exists(r.ID)
+			if err != nil {
+				slog.Error().Err(err).Msg("failed to check if reservation exists in cache")
+				continue
+			}
+
+			if exists {
+				// the crash happened after the reservation got cached
+				e.setState(r, LifecycleDeployed)
+				continue
+			}
+
+			slog.Info().Msg("rolling back interrupted provisioning")
+			e.rollback(ctx, r)
+			e.removeState(r.ID)
+
+		case LifecycleInUse:
+			exists, err := e.cache.Exists(r.ID)
+			if err != nil {
+				slog.Error().Err(err).Msg("failed to check if reservation exists in cache")
+				continue
+			}
+
+			if !exists {
+				e.removeState(r.ID)
+				continue
+			}
+
+			// the graph is loaded afterward, the reservation is
+			// decommissioned right away if nothing uses it anymore
+			slog.Info().Msg("decommission waits again for dependent reservations to be removed")
+			e.graph.refuse(r)
+
+		case LifecycleDecommissioning:
+			slog.Info().Msg("resuming interrupted decommission")
+			if err := e.decommission(ctx, r); err != nil {
+				slog.Error().Err(err).Msg("failed to decommission reservation")
+			}
+
+		case LifecycleFailed, LifecycleDeleted:
+			if time.Since(entry.Updated) > journalRetention {
+				e.removeState(r.ID)
+			}
+		}
+	}
+
+	return e.updateStats()
+}
+
+// rollback removes what has been deployed by an interrupted provisioning
+func (e *Engine) rollback(ctx context.Context, r *Reservation) {
+	// networks are shared between all the reservations of the
+	// same user network, we can't remove a partially updated one
+	if r.Type == "network" || r.Type == "network_resource" {
+		return
+	}
+
+	fn, ok := e.decomissioner(r.Type)
+	if !ok {
+		return
+	}
+
+	wl := *r
+	if wl.Reference != "" {
+		wl.ID = wl.Reference
+	}
+
+	if err := fn(ctx, &wl); err != nil {
+		log.Warn().Err(err).Str("id", r.ID).Msg("failed to roll back partially provisioned reservation")
+	}
+}
+
 func (e *Engine) reply(ctx context.Context, result *Result) error {
 	log.Debug().Str("id", result.ID).Msg("sending reply for reservation")
 
@@ -396,21 +1197,148 @@ func (e *Engine) reply(ctx context.Context, result *Result) error {
 // the decommission method will take care to update the reservation instance
 // and also decommission the reservation normally
 func (e *Engine) DecommissionCached(id string, reason string) error {
+	return e.decommissionCached(id, fmt.Errorf(reason), nil)
+}
+
+// JobExited implements pkg.Provision. A job that exited with a non
+// zero code is reported as failed, with its output in the result data
+// so the owner can see why. In both cases the job is decommissioned so
+// its capacity is released right away instead of at expiration
+func (e *Engine) JobExited(id string, result pkg.JobResult) error {
+	log.Info().Str("id", id).Uint32("exit-code", result.ExitCode).Msg("job exited")
+
+	e.exitsM.Lock()
+	if _, err := e.workload(id); err != nil {
+		// short jobs can exit before the engine is done provisioning
+		// them, the exit is kept until the reservation is cached
+		log.Debug().Str("id", id).Msg("job exited before it was deployed")
+		e.pushExit(id, result)
+		e.exitsM.Unlock()
+		return nil
+	}
+	e.exitsM.Unlock()
+
+	return e.jobExited(id, result)
+}
+
+// jobExited decommissions the job id that exited with result
+func (e *Engine) jobExited(id string, result pkg.JobResult) error {
+	var err error
+	if result.ExitCode != 0 {
+		err = fmt.Errorf("job exited with code %d", result.ExitCode)
+	}
+
+	return e.decommissionCached(id, err, result)
+}
+
+// pendingExit is the exit of a job that is not cached yet
+type pendingExit struct {
+	result pkg.JobResult
+	at     time.Time
+}
+
+// pushExit keeps the exit of the job id until the job is cached.
+// exitsM must be held. Exits of jobs that are never cached are
+// dropped after pendingExitTimeout
+func (e *Engine) pushExit(id string, result pkg.JobResult) {
+	if e.exits == nil {
+		e.exits = make(map[string]pendingExit)
+	}
+
+	now := time.Now()
+	for pending, exit := range e.exits {
+		if now.Sub(exit.at) > pendingExitTimeout {
+			delete(e.exits, pending)
+		}
+	}
+
+	e.exits[id] = pendingExit{result: result, at: now}
+}
+
+// popExit returns and forgets the pending exit of the job id if any
+func (e *Engine) popExit(id string) (pendingExit, bool) {
+	e.exitsM.Lock()
+	defer e.exitsM.Unlock()
+
+	return e.takeExit(id)
+}
+
+// takeExit is popExit with exitsM held
+func (e *Engine) takeExit(id string) (pendingExit, bool) {
+	exit, ok := e.exits[id]
+	delete(e.exits, id)
+	return exit, ok
+}
+
+// workload returns the cached reservation that deployed the workload id.
+// The workload of a reservation referencing an older one keeps the id
+// of the reference
+func (e *Engine) workload(id string) (*Reservation, error) {
 	r, err := e.cache.Get(id)
+	if err == nil {
+		return r, nil
+	}
+
+	all, lerr := e.cache.List()
+	if lerr != nil {
+		return nil, err
+	}
+
+	for _, r := range all {
+		if r.Reference == id {
+			return r, nil
+		}
+	}
+
+	return nil, err
+}
+
+// decommissionCached decommissions the cached reservation of the workload
+// id and sends a result built from reason and info to the owner
+func (e *Engine) decommissionCached(id string, reason error, info interface{}) error {
+	r, err := e.workload(id)
 	if err != nil {
 		return err
 	}
 
 	ctx := context.Background()
-	result, err := e.buildResult(id, r.Type, fmt.Errorf(reason), nil)
+	result, err := e.buildResult(r.ID, r.Type, reason, info)
 	if err != nil {
-		return errors.Wrapf(err, "failed to build result object for reservation: %s", id)
+		return errors.Wrapf(err, "failed to build result object for reservation: %s", r.ID)
 	}
 
 	if err := e.decommission(ctx, r); err != nil {
 		log.Error().Err(err).Msgf("failed to update reservation result with failure: %s", id)
 	}
 
+	return e.replyRetry(ctx, result)
+}
+
+// WorkloadStopped implements pkg.Provision. The owner of the workload id is
+// told it is not running anymore because of reason. The reservation is not
+// decommissioned, so the workload can still be inspected until it expires
+// or is deleted
+func (e *Engine) WorkloadStopped(id string, reason string) error {
+	log.Info().Str("id", id).Str("reason", reason).Msg("workload stopped")
+
+	r, err := e.workload(id)
+	if err != nil {
+		return err
+	}
+
+	stopped := errors.New(reason)
+	result, err := e.buildResult(r.ID, r.Type, stopped, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to build result object for reservation: %s", r.ID)
+	}
+
+	e.emit(r.ID, r.Type, pkg.PhaseFailed, time.Now(), stopped)
+
+	return e.replyRetry(context.Background(), result)
+}
+
+// replyRetry sends result, retrying for up to a minute
+func (e *Engine) replyRetry(ctx context.Context, result *Result) error {
 	bf := backoff.NewExponentialBackOff()
 	bf.MaxInterval = 10 * time.Second
 	bf.MaxElapsedTime = 1 * time.Minute
@@ -418,7 +1346,7 @@ func (e *Engine) DecommissionCached(id string, reason string) error {
 	return backoff.Retry(func() error {
 		err := e.reply(ctx, result)
 		if err != nil {
-			log.Error().Err(err).Msgf("failed to update reservation result with failure: %s", id)
+			log.Error().Err(err).Msgf("failed to update reservation result with failure: %s", result.ID)
 		}
 		return err
 	}, bf)
@@ -464,6 +1392,11 @@ func (e *Engine) signResult(result *Result) error {
 }
 
 func (e *Engine) updateStats() error {
+	// updateStats is called from all the workers, make sure
+	// the explorer receives the statistics in order
+	e.statsM.Lock()
+	defer e.statsM.Unlock()
+
 	wl := e.statser.CurrentWorkloads()
 	r := e.statser.CurrentUnits()
 
@@ -589,3 +1522,45 @@ func NetworkID(userID, name string) pkg.NetID {
 	}
 	return pkg.NetID(string(b))
 }
+
+// provisioner returns the ProvisionerFunc of the built-in or external type typ
+func (e *Engine) provisioner(typ ReservationType) (ProvisionerFunc, bool) {
+	if fn, ok := e.provisioners[typ]; ok {
+		return fn, true
+	}
+
+	return e.external.Provisioner(typ)
+}
+
+// decomissioner returns the DecomissionerFunc of the built-in or external type typ
+func (e *Engine) decomissioner(typ ReservationType) (DecomissionerFunc, bool) {
+	if fn, ok := e.decomissioners[typ]; ok {
+		return fn, true
+	}
+
+	return e.external.Decommissioner(typ)
+}
+
+// RegisterProvisioner routes the reservations of type typ to the
+// external provisioner id. Built-in types can't be registered
+func (e *Engine) RegisterProvisioner(typ string, id pkg.ExternalProvisionerID) error {
+	if e.external == nil {
+		return fmt.Errorf("external provisioners are not supported")
+	}
+
+	if _, ok := e.provisioners[ReservationType(typ)]; ok {
+		return fmt.Errorf("type %s is a built-in type", typ)
+	}
+
+	return e.external.Register(ReservationType(typ), id)
+}
+
+// UnregisterProvisioner stops routing the reservations of type typ
+// to its external provisioner
+func (e *Engine) UnregisterProvisioner(typ string) error {
+	if e.external == nil {
+		return fmt.Errorf("external provisioners are not supported")
+	}
+
+	return e.external.Unregister(ReservationType(typ))
+}
@@ -1,5 +1,299 @@
 package provision
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"golang.org/x/crypto/ed25519"
+)
+
+type testSigner struct{}
+
+func (testSigner) Sign(b []byte) ([]byte, error) { return []byte("signature"), nil }
+
+func TestJobExited(t *testing.T) {
+	require := require.New(t)
+
+	job := &Reservation{ID: "1-1", Type: "job", Result: Result{State: StateOk}}
+
+	var decommissioned []string
+	cache := &TestCache{}
+	cache.On("Get", job.ID).Return(job, nil)
+	cache.On("Exists", job.ID).Return(true, nil)
+	cache.On("Remove", job.ID).Return(nil)
+
+	feedback := &TestFeedback{}
+	feedback.On("Deleted", mock.Anything, job.ID).Return(nil)
+	feedback.On("Feedback", mock.Anything, mock.Anything).Return(nil)
+
+	engine := &Engine{
+		cache:    cache,
+		feedback: feedback,
+		statser:  &TestStatser{},
+		signer:   testSigner{},
+		decomissioners: map[ReservationType]DecomissionerFunc{
+			"job": func(ctx context.Context, r *Reservation) error {
+				decommissioned = append(decommissioned, r.ID)
+				return nil
+			},
+		},
+		graph: newGraph(nil),
+	}
+
+	exit := pkg.JobResult{ExitCode: 2, ExitedAt: time.Now().UTC(), Output: "no such file"}
+	require.NoError(engine.JobExited(job.ID, exit))
+
+	// the job capacity is released
+	require.Equal([]string{"1-1"}, decommissioned)
+	cache.AssertCalled(t, "Remove", job.ID)
+
+	var result *Result
+	for _, call := range feedback.Calls {
+		if call.Method == "Feedback" {
+			result = call.Arguments.Get(1).(*Result)
+		}
+	}
+	require.NotNil(result)
+	require.Equal(StateError, result.State)
+	require.Equal("job exited with code 2", result.Error)
+
+	var data pkg.JobResult
+	require.NoError(json.Unmarshal(result.Data, &data))
+	require.Equal(exit.Output, data.Output)
+	require.EqualValues(2, data.ExitCode)
+}
+
+func TestJobExitedEarly(t *testing.T) {
+	require := require.New(t)
+
+	job := &Reservation{ID: "1-1", Type: "job", Created: time.Now(), Duration: time.Hour}
+
+	cache := &TestCache{}
+	cache.On("Get", job.ID).Return(nil, errors.New("not found")).Twice()
+	cache.On("Get", job.ID).Return(job, nil)
+	cache.On("List").Return([]*Reservation{}, nil)
+	cache.On("Add", job).Return(nil)
+	cache.On("Exists", job.ID).Return(true, nil)
+	cache.On("Remove", job.ID).Return(nil)
+
+	feedback := &recordFeedback{}
+
+	var engine *Engine
+	var decommissioned []string
+	engine = &Engine{
+		cache:    cache,
+		feedback: feedback,
+		statser:  &slotStatser{},
+		signer:   testSigner{},
+		provisioners: map[ReservationType]ProvisionerFunc{
+			"job": func(ctx context.Context, r *Reservation) (interface{}, error) {
+				// the job is done before the engine caches it
+				return nil, engine.JobExited(r.ID, pkg.JobResult{ExitCode: 1})
+			},
+		},
+		decomissioners: map[ReservationType]DecomissionerFunc{
+			"job": func(ctx context.Context, r *Reservation) error {
+				decommissioned = append(decommissioned, r.ID)
+				return nil
+			},
+		},
+		graph: newGraph(nil),
+	}
+
+	require.NoError(engine.provision(context.Background(), job))
+
+	// the exit is applied once the job is cached
+	require.Equal([]string{"1-1"}, decommissioned)
+	require.Equal([]string{"result:1-1", "deleted:1-1", "result:1-1"}, feedback.Calls())
+	require.Len(engine.exits, 0)
+}
+
+func TestJobExitedWhileCaching(t *testing.T) {
+	require := require.New(t)
+
+	job := &Reservation{ID: "1-1", Type: "job", Created: time.Now(), Duration: time.Hour}
+
+	var engine *Engine
+	var exited sync.WaitGroup
+
+	// the cache returns its own copy of the deployed reservation
+	cached := *job
+	cached.Result.State = StateOk
+
+	cache := &TestCache{}
+	cache.On("Get", job.ID).Return(nil, errors.New("not found")).Once()
+	cache.On("Get", job.ID).Return(&cached, nil)
+	cache.On("Add", job).Return(nil).Run(func(mock.Arguments) {
+		// the job exits right after it is cached, before
+		// the engine is done deploying it
+		exited.Add(1)
+		go func() {
+			defer exited.Done()
+			require.NoError(engine.JobExited(job.ID, pkg.JobResult{}))
+		}()
+		time.Sleep(50 * time.Millisecond)
+	})
+	cache.On("Exists", job.ID).Return(true, nil)
+	cache.On("Remove", job.ID).Return(nil)
+
+	var decommissioned []string
+	engine = &Engine{
+		cache:    cache,
+		feedback: &recordFeedback{},
+		statser:  &slotStatser{},
+		signer:   testSigner{},
+		provisioners: map[ReservationType]ProvisionerFunc{
+			"job": func(ctx context.Context, r *Reservation) (interface{}, error) {
+				return nil, nil
+			},
+		},
+		decomissioners: map[ReservationType]DecomissionerFunc{
+			"job": func(ctx context.Context, r *Reservation) error {
+				decommissioned = append(decommissioned, r.ID)
+				return nil
+			},
+		},
+		graph: newGraph(testDependencies),
+	}
+
+	require.NoError(engine.provision(context.Background(), job))
+	exited.Wait()
+
+	// the job is decommissioned once, after it is fully deployed
+	require.Equal([]string{"1-1"}, decommissioned)
+	require.Empty(engine.graph.providers)
+}
+
+func TestWorkloadStopped(t *testing.T) {
+	require := require.New(t)
+
+	container := &Reservation{ID: "1-1", Type: "container", Result: Result{State: StateOk}}
+
+	cache := &TestCache{}
+	cache.On("Get", container.ID).Return(container, nil)
+
+	feedback := &recordFeedback{}
+	engine := &Engine{
+		cache:    cache,
+		feedback: feedback,
+		statser:  &TestStatser{},
+		signer:   testSigner{},
+		graph:    newGraph(nil),
+	}
+
+	require.NoError(engine.WorkloadStopped(container.ID, "container exited with code 0"))
+
+	// the owner is told, but the container is kept
+	require.Equal([]string{"result:1-1"}, feedback.Calls())
+	cache.AssertNotCalled(t, "Remove", mock.Anything)
+}
+
+type testUsers map[string]ed25519.PublicKey
+
+func (u testUsers) PublicKey(userID string) (ed25519.PublicKey, error) {
+	return u[userID], nil
+}
+
+type flakyUsers struct {
+	testUsers
+	failures int
+}
+
+func (u *flakyUsers) PublicKey(userID string) (ed25519.PublicKey, error) {
+	if u.failures > 0 {
+		u.failures--
+		return nil, fmt.Errorf("explorer not reachable")
+	}
+
+	return u.testUsers.PublicKey(userID)
+}
+
+func TestVerify(t *testing.T) {
+	require := require.New(t)
+
+	owner, ownerKey, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	users := &flakyUsers{testUsers: testUsers{"1": owner}, failures: 1}
+	engine := &Engine{
+		users:          users,
+		retryDeadlines: map[ReservationType]time.Duration{"container": 10 * time.Second},
+	}
+
+	r := &Reservation{ID: "1-1", User: "1", Type: "container"}
+	require.NoError(r.Sign(ownerKey))
+
+	// failing to get the key is retried
+	require.NoError(engine.verify(context.Background(), r))
+	require.Equal(0, users.failures)
+
+	r.Signature = nil
+	require.True(errors.Is(engine.verify(context.Background(), r), ErrInvalidSignature))
+
+	// the signature of reservations verified by their source is not checked again
+	r.Verified = true
+	require.NoError(engine.verify(context.Background(), r))
+}
+
+func TestVerifyToken(t *testing.T) {
+	require := require.New(t)
+
+	owner, ownerKey, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	cache := &TestCache{}
+	cache.On("Get", "1-1").Return(&Reservation{ID: "1-1", User: "1"}, nil)
+	cache.On("Get", "1-2").Return(nil, errors.New("not found"))
+	cache.On("Get", "1-3").Return(nil, errors.New("not found"))
+	cache.On("List").Return([]*Reservation{{ID: "2-1", User: "1", Reference: "1-2"}}, nil)
+
+	engine := &Engine{
+		cache: cache,
+		users: testUsers{"1": owner},
+	}
+
+	sign := func(token pkg.AccessToken, key ed25519.PrivateKey) pkg.AccessToken {
+		token.Signature = ed25519.Sign(key, token.SignedBytes())
+		return token
+	}
+
+	token := pkg.AccessToken{Reservation: "1-1", Expiry: time.Now().Add(time.Hour)}
+	require.NoError(engine.VerifyToken("1-1", sign(token, ownerKey)))
+
+	// only the owner can issue tokens
+	require.Error(engine.VerifyToken("1-1", sign(token, otherKey)))
+
+	// a token is only valid for its reservation
+	require.Error(engine.VerifyToken("1-2", sign(token, ownerKey)))
+	require.Error(engine.VerifyToken("1-3", sign(token, ownerKey)))
+
+	// the workload of a reservation deployed under a reference
+	// is found, the token can be issued for either id
+	token.Reservation = "2-1"
+	require.NoError(engine.VerifyToken("1-2", sign(token, ownerKey)))
+	token.Reservation = "1-2"
+	require.NoError(engine.VerifyToken("1-2", sign(token, ownerKey)))
+	token.Reservation = "1-1"
+
+	// expired tokens are refused
+	token.Expiry = time.Now().Add(-time.Minute)
+	require.Error(engine.VerifyToken("1-1", sign(token, ownerKey)))
+
+	// so are tokens valid for too long
+	token.Expiry = time.Now().Add(pkg.AccessTokenMaxLifetime + time.Minute)
+	require.Error(engine.VerifyToken("1-1", sign(token, ownerKey)))
+}
+
 // func TestEngine(t *testing.T) {
 // 	td, err := ioutil.TempDir("", "")
 // 	require.NoError(t, err)
@@ -68,3 +362,132 @@ package provision
 // 	assert.EqualValues(t, 1, workloads.ZDBNamespace)
 // 	assert.EqualValues(t, 0, workloads.K8sVM)
 // }
+
+func TestProcessedKey(t *testing.T) {
+	r := &Reservation{ID: "1-1", Version: 1}
+	updated := &Reservation{ID: "1-1", Version: 2}
+
+	require.Equal(t, processedKey(r), processedKey(&Reservation{ID: "1-1", Version: 1}))
+	require.NotEqual(t, processedKey(r), processedKey(updated))
+
+	renewed := &Reservation{ID: "1-1", Version: 1, Duration: time.Hour}
+	require.NotEqual(t, processedKey(r), processedKey(renewed))
+}
+
+// slotStatser counts the reserved workloads, the capacity
+// checker built on it only has room for one workload
+type slotStatser struct {
+	TestStatser
+	m        sync.Mutex
+	reserved int
+}
+
+func (s *slotStatser) Increment(r *Reservation) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.reserved++
+	return nil
+}
+
+func (s *slotStatser) Decrement(r *Reservation) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.reserved--
+	return nil
+}
+
+func (s *slotStatser) Check(r *Reservation) (pkg.Admission, error) {
+	s.m.Lock()
+	reserved := s.reserved
+	s.m.Unlock()
+
+	// leave time to a concurrent check to happen
+	time.Sleep(10 * time.Millisecond)
+
+	var admission pkg.Admission
+	if reserved > 0 {
+		admission.Missing = []string{"cru"}
+	}
+	return admission, nil
+}
+
+func TestAdmitReserves(t *testing.T) {
+	require := require.New(t)
+
+	statser := &slotStatser{}
+	engine := &Engine{statser: statser, capacity: statser}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	releases := make([]func(), 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			release, err := engine.admit(&Reservation{ID: fmt.Sprintf("%d-1", i), Type: "container"}, nil)
+			if err == nil {
+				releases[i] = release
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	// only one of the reservations fits
+	require.True((errs[0] == nil) != (errs[1] == nil))
+	require.Equal(1, statser.reserved)
+
+	// releasing the admitted reservation frees its capacity
+	for _, release := range releases {
+		if release != nil {
+			release()
+		}
+	}
+	_, err := engine.admit(&Reservation{ID: "2-1", Type: "container"}, nil)
+	require.NoError(err)
+}
+
+// slotQuota gives a quota of one workload to every user
+type slotQuota struct {
+	statser *slotStatser
+}
+
+func (q slotQuota) Check(r *Reservation) error {
+	q.statser.m.Lock()
+	reserved := q.statser.reserved
+	q.statser.m.Unlock()
+
+	// leave time to a concurrent check to happen
+	time.Sleep(10 * time.Millisecond)
+
+	if reserved > 0 {
+		return pkg.ErrQuotaExceeded{Exceeded: []string{"workloads"}}
+	}
+	return nil
+}
+
+func (q slotQuota) Usage() []pkg.UserUsage { return nil }
+
+func TestAdmitQuota(t *testing.T) {
+	require := require.New(t)
+
+	statser := &slotStatser{}
+	engine := &Engine{statser: statser, quota: slotQuota{statser: statser}}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = engine.admit(&Reservation{ID: fmt.Sprintf("%d-1", i), User: "1", Type: "container"}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	// the user can only deploy one of the reservations
+	require.True((errs[0] == nil) != (errs[1] == nil))
+	var quotaErr pkg.ErrQuotaExceeded
+	require.True(errors.As(errs[0], &quotaErr) || errors.As(errs[1], &quotaErr))
+	require.Equal(1, statser.reserved)
+}
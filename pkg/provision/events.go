@@ -0,0 +1,90 @@
+package provision
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// eventsBuffer is the number of events buffered for each
+// subscriber. Events are dropped for subscribers that
+// don't keep up
+const eventsBuffer = 128
+
+// events dispatches the provision events to all the subscribers
+type events struct {
+	sync.Mutex
+	subscribers map[chan pkg.ProvisionEvent]struct{}
+}
+
+func newEvents() *events {
+	return &events{
+		subscribers: make(map[chan pkg.ProvisionEvent]struct{}),
+	}
+}
+
+// subscribe returns a channel that receives all the events
+// published until ctx is done
+func (b *events) subscribe(ctx context.Context) <-chan pkg.ProvisionEvent {
+	ch := make(chan pkg.ProvisionEvent, eventsBuffer)
+
+	b.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.Lock()
+		defer b.Unlock()
+		delete(b.subscribers, ch)
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (b *events) publish(event pkg.ProvisionEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Debug().Str("id", event.ID).Msg("events subscriber is too slow, dropping event")
+		}
+	}
+}
+
+// Events is a zbus stream that sends the lifecycle
+// events of the reservations processed by the engine
+func (e *Engine) Events(ctx context.Context) <-chan pkg.ProvisionEvent {
+	return e.events.subscribe(ctx)
+}
+
+// emit publishes an event for reservation id. start is when the
+// engine started to process the reservation
+func (e *Engine) emit(id string, typ ReservationType, phase pkg.ProvisionPhase, start time.Time, err error) {
+	if e.events == nil {
+		return
+	}
+
+	now := time.Now()
+	event := pkg.ProvisionEvent{
+		ID:       id,
+		Type:     string(typ),
+		Phase:    phase,
+		Time:     now,
+		Duration: now.Sub(start),
+	}
+
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	e.events.publish(event)
+}
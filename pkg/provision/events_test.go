@@ -0,0 +1,44 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestEvents(t *testing.T) {
+	require := require.New(t)
+
+	engine := &Engine{events: newEvents()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := engine.Events(ctx)
+
+	start := time.Now().Add(-time.Second)
+	engine.emit("1-1", "container", pkg.PhaseProvisioning, start, nil)
+	engine.emit("1-1", "container", pkg.PhaseFailed, start, fmt.Errorf("no space left"))
+
+	event := <-ch
+	require.Equal("1-1", event.ID)
+	require.Equal("container", event.Type)
+	require.Equal(pkg.PhaseProvisioning, event.Phase)
+	require.True(event.Duration >= time.Second)
+	require.Empty(event.Error)
+
+	event = <-ch
+	require.Equal(pkg.PhaseFailed, event.Phase)
+	require.Equal("no space left", event.Error)
+
+	cancel()
+	for range ch {
+		// drained once the subscription is removed
+	}
+
+	engine.events.Lock()
+	require.Len(engine.events.subscribers, 0)
+	engine.events.Unlock()
+}
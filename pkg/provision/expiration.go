@@ -0,0 +1,127 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultExpiryWarnings are the durations before the expiration
+// of a reservation at which its owner is warned
+var DefaultExpiryWarnings = []time.Duration{24 * time.Hour, time.Hour}
+
+// expiryCheckInterval is how often the engine looks
+// for reservations that are about to expire
+const expiryCheckInterval = time.Minute
+
+// inGracePeriod returns true if r has expired but its data
+// must be kept until the end of the grace period
+func (e *Engine) inGracePeriod(r *Reservation) bool {
+	if !e.persistent[r.Type] || !r.Expires() {
+		return false
+	}
+
+	return time.Now().Before(r.Expiry().Add(e.gracePeriod))
+}
+
+// watchExpiry periodically warns the owners of
+// the reservations that are about to expire
+func (e *Engine) watchExpiry(ctx context.Context) {
+	warned := make(map[string]time.Duration)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(expiryCheckInterval):
+		}
+
+		if err := e.warnExpiring(time.Now(), warned); err != nil {
+			log.Error().Err(err).Msg("failed to check reservations about to expire")
+		}
+	}
+}
+
+// warnExpiring sends a warning for each deployed reservation that entered
+// one of the warning windows. warned keeps track of the smallest window
+// each reservation has already been warned for. Reservations that don't
+// expire on the node are skipped
+func (e *Engine) warnExpiring(now time.Time, warned map[string]time.Duration) error {
+	reservations, err := e.cache.List()
+	if err != nil {
+		return err
+	}
+
+	expiring := make(map[string]struct{})
+	for _, r := range reservations {
+		if r.Result.State != StateOk || !r.Expires() {
+			continue
+		}
+
+		window, ok := expiryWindow(e.expiryWarnings, r.Expiry().Sub(now))
+		if !ok {
+			continue
+		}
+
+		expiring[r.ID] = struct{}{}
+		if last, ok := warned[r.ID]; ok && last <= window {
+			continue
+		}
+
+		log.Info().Str("id", r.ID).Time("expiry", r.Expiry()).Msg("reservation is about to expire")
+		if err := e.warn(r); err != nil {
+			log.Error().Err(err).Str("id", r.ID).Msg("failed to send expiration warning")
+			continue
+		}
+
+		warned[r.ID] = window
+	}
+
+	// forget about the reservations that expired, were
+	// removed or renewed
+	for id := range warned {
+		if _, ok := expiring[id]; !ok {
+			delete(warned, id)
+		}
+	}
+
+	return nil
+}
+
+// warn sends the expiration warning of r. The warning is the deployed result
+// of r with the expiration as message, the original result is sent again
+// when the reservation is renewed
+func (e *Engine) warn(r *Reservation) error {
+	result := r.Result
+	result.ID = r.ID
+	result.Type = r.Type
+	result.Error = fmt.Sprintf("reservation expires at %s, renew it to keep it deployed", r.Expiry().UTC().Format(time.RFC3339))
+	if err := e.signResult(&result); err != nil {
+		return err
+	}
+
+	return e.feedback.Expiring(e.nodeID, &result, r.Expiry())
+}
+
+// expiryWindow returns the smallest warning duration that
+// is larger than left. ok is false if the reservation
+// has expired or is not in any of the windows yet
+func expiryWindow(warnings []time.Duration, left time.Duration) (window time.Duration, ok bool) {
+	if left <= 0 {
+		return 0, false
+	}
+
+	for _, warning := range warnings {
+		if left > warning {
+			continue
+		}
+
+		if !ok || warning < window {
+			window, ok = warning, true
+		}
+	}
+
+	return window, ok
+}
@@ -1,8 +1,12 @@
 package provision
 
 import (
+	"context"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExpired(t *testing.T) {
@@ -39,3 +43,195 @@ func TestExpired(t *testing.T) {
 		})
 	}
 }
+
+func TestExpiryWindow(t *testing.T) {
+	warnings := []time.Duration{24 * time.Hour, time.Hour}
+
+	window, ok := expiryWindow(warnings, 48*time.Hour)
+	require.False(t, ok)
+
+	window, ok = expiryWindow(warnings, 10*time.Hour)
+	require.True(t, ok)
+	require.Equal(t, 24*time.Hour, window)
+
+	window, ok = expiryWindow(warnings, 10*time.Minute)
+	require.True(t, ok)
+	require.Equal(t, time.Hour, window)
+
+	_, ok = expiryWindow(warnings, -time.Minute)
+	require.False(t, ok)
+}
+
+func TestWarnExpiring(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	reservation := &Reservation{
+		ID:       "1-1",
+		Created:  now.Add(-time.Hour),
+		Duration: 11 * time.Hour,
+		Result:   Result{State: StateOk},
+	}
+
+	cache := &TestCache{}
+	cache.On("List").Return([]*Reservation{reservation}, nil)
+	feedback := &recordFeedback{}
+
+	engine := &Engine{
+		nodeID:         "node",
+		cache:          cache,
+		feedback:       feedback,
+		expiryWarnings: []time.Duration{24 * time.Hour, time.Hour},
+		signer:         testSigner{},
+	}
+
+	warned := make(map[string]time.Duration)
+	require.NoError(engine.warnExpiring(now, warned))
+	require.NoError(engine.warnExpiring(now.Add(time.Minute), warned))
+	require.Equal([]string{"expiring:1-1"}, feedback.Calls())
+
+	// entering the next window sends a new warning
+	require.NoError(engine.warnExpiring(now.Add(9*time.Hour+30*time.Minute), warned))
+	require.Equal([]string{"expiring:1-1", "expiring:1-1"}, feedback.Calls())
+
+	require.NoError(engine.warnExpiring(now.Add(11*time.Hour), warned))
+	require.Len(warned, 0)
+}
+
+func (c *TestCache) Add(r *Reservation) error {
+	return c.Called(r).Error(0)
+}
+
+func TestRenew(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	cached := &Reservation{
+		ID:       "1-1",
+		Type:     "container",
+		Created:  now.Add(-time.Hour),
+		Duration: 2 * time.Hour,
+		Result:   Result{ID: "1-1", State: StateOk, Created: now.Add(-time.Hour)},
+	}
+
+	renewed := *cached
+	renewed.Duration = 24 * time.Hour
+
+	cache := &TestCache{}
+	cache.On("Get", "1-1").Return(cached, nil)
+	cache.On("Remove", "1-1").Return(nil)
+	cache.On("Add", mock.Anything).Return(nil)
+	feedback := &recordFeedback{}
+
+	engine := &Engine{
+		cache:    cache,
+		feedback: feedback,
+		signer:   testSigner{},
+		provisioners: map[ReservationType]ProvisionerFunc{
+			"container": func(ctx context.Context, r *Reservation) (interface{}, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	require.NoError(engine.provision(context.Background(), &renewed))
+
+	// the cached expiration is updated and the result sent again
+	require.Equal(renewed.Expiry(), cached.Expiry())
+	cache.AssertCalled(t, "Add", cached)
+	require.Equal([]string{"result:1-1"}, feedback.Calls())
+}
+
+func TestInGracePeriod(t *testing.T) {
+	engine := &Engine{
+		gracePeriod: time.Hour,
+		persistent:  map[ReservationType]bool{"volume": true},
+	}
+
+	expired := func(typ ReservationType, ago time.Duration) *Reservation {
+		return &Reservation{
+			Type:     typ,
+			Created:  time.Now().Add(-ago - time.Minute),
+			Duration: time.Minute,
+		}
+	}
+
+	require.True(t, engine.inGracePeriod(expired("volume", 10*time.Minute)))
+	require.False(t, engine.inGracePeriod(expired("volume", 2*time.Hour)))
+	require.False(t, engine.inGracePeriod(expired("container", 10*time.Minute)))
+}
+
+type chanSource chan *ReservationJob
+
+func (s chanSource) Reservations(ctx context.Context) <-chan *ReservationJob {
+	return s
+}
+
+func TestExplorerExpiry(t *testing.T) {
+	require := require.New(t)
+
+	// the explorer reservations never expire on the node, the
+	// end of their capacity pool is received as ToDelete
+	now := time.Now()
+	reservation := func(id string) *Reservation {
+		return &Reservation{
+			ID:       id,
+			Type:     "volume",
+			Created:  now.Add(-30 * 24 * time.Hour),
+			Duration: NoExpiry,
+			Result:   Result{State: StateOk},
+		}
+	}
+
+	deployed := reservation("1-1")
+	require.False(deployed.Expired())
+
+	cache := &TestCache{}
+	cache.On("List").Return([]*Reservation{deployed}, nil)
+	cache.On("Exists", "1-2").Return(true, nil)
+	cache.On("Remove", "1-2").Return(nil)
+	feedback := &recordFeedback{}
+
+	source := make(chanSource, 1)
+	decommissioned := make(chan string, 1)
+	engine := &Engine{
+		nodeID:         "node",
+		source:         source,
+		cache:          cache,
+		feedback:       feedback,
+		statser:        &TestStatser{},
+		signer:         testSigner{},
+		gracePeriod:    24 * time.Hour,
+		persistent:     map[ReservationType]bool{"volume": true},
+		expiryWarnings: []time.Duration{24 * time.Hour, time.Hour},
+		decomissioners: map[ReservationType]DecomissionerFunc{
+			"volume": func(ctx context.Context, r *Reservation) error {
+				decommissioned <- r.ID
+				return nil
+			},
+		},
+		exits: make(map[string]pendingExit),
+	}
+
+	// no expiration warning is sent
+	require.NoError(engine.warnExpiring(now, make(map[string]time.Duration)))
+	require.Empty(feedback.Calls())
+
+	// and the data is not kept after the pool runs out
+	deleted := reservation("1-2")
+	deleted.ToDelete = true
+	require.False(engine.inGracePeriod(deleted))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source <- &ReservationJob{Reservation: *deleted}
+	go engine.Run(ctx)
+
+	select {
+	case id := <-decommissioned:
+		require.Equal("1-2", id)
+	case <-time.After(5 * time.Second):
+		require.Fail("reservation of an empty pool was not decommissioned")
+	}
+}
@@ -3,9 +3,11 @@ package explorer
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfexplorer/client"
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/zos/pkg/provision"
@@ -40,7 +42,7 @@ func (e *Feedback) Feedback(nodeID string, r *provision.Result) error {
 		return fmt.Errorf("failed to convert result into schema type: %w", err)
 	}
 
-	return backoff.Retry(func() error {
+	return rejected(backoff.Retry(func() error {
 		err := e.client.Workloads.NodeWorkloadPutResult(nodeID, r.ID, *wr)
 		if err == nil || errors.Is(err, client.ErrRequestFailure) {
 			// we only retry if err is a request failure err.
@@ -49,12 +51,12 @@ func (e *Feedback) Feedback(nodeID string, r *provision.Result) error {
 
 		// otherwise retrying won't fix it, so we can terminate
 		return backoff.Permanent(err)
-	}, e.strategy)
+	}, e.strategy))
 }
 
 // Deleted implements provision.Feedbacker
 func (e *Feedback) Deleted(nodeID, id string) error {
-	return backoff.Retry(func() error {
+	return rejected(backoff.Retry(func() error {
 		err := e.client.Workloads.NodeWorkloadPutDeleted(nodeID, id)
 		if err == nil || errors.Is(err, client.ErrRequestFailure) {
 			// we only retry if err is a request failure err.
@@ -63,12 +65,12 @@ func (e *Feedback) Deleted(nodeID, id string) error {
 
 		// otherwise retrying won't fix it, so we can terminate
 		return backoff.Permanent(err)
-	}, e.strategy)
+	}, e.strategy))
 }
 
 // UpdateStats implements provision.Feedbacker
 func (e *Feedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
-	return backoff.Retry(func() error {
+	return rejected(backoff.Retry(func() error {
 		err := e.client.Directory.NodeUpdateUsedResources(nodeID, u, w)
 		if err == nil || errors.Is(err, client.ErrRequestFailure) {
 			// we only retry if err is a request failure err.
@@ -77,5 +79,32 @@ func (e *Feedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u dire
 
 		// otherwise retrying won't fix it, so we can terminate
 		return backoff.Permanent(err)
-	}, e.strategy)
+	}, e.strategy))
+}
+
+// Expiring implements provision.Feedbacker
+// The explorer has no endpoint to receive expiration warnings from the
+// nodes yet, so the warning is sent as the message of the result of the
+// reservation where the owner can see it
+func (e *Feedback) Expiring(nodeID string, r *provision.Result, expiry time.Time) error {
+	log.Info().Str("id", r.ID).Time("expiry", expiry).Msg("reservation is about to expire")
+	return e.Feedback(nodeID, r)
+}
+
+// rejected marks the errors of the requests the explorer refused with
+// provision.Rejected, sending them again would fail the same way
+func rejected(err error) error {
+	var hErr client.HTTPError
+	if !errors.As(err, &hErr) {
+		return err
+	}
+
+	code := hErr.Response().StatusCode
+	if code >= 400 && code < 500 &&
+		code != http.StatusRequestTimeout &&
+		code != http.StatusTooManyRequests {
+		return provision.Rejected(err)
+	}
+
+	return err
 }
@@ -0,0 +1,247 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// ExternalProvisioners keeps track of the reservation types deployed by
+// external daemons over zbus. The registrations are persisted so the
+// reservations of a type can still be decommissioned after a restart,
+// even if the daemon didn't register again yet
+type ExternalProvisioners struct {
+	sync.RWMutex
+	path   string
+	client zbus.Client
+	types  map[ReservationType]pkg.ExternalProvisionerID
+}
+
+// NewExternalProvisioners loads the registrations stored in the file at path
+func NewExternalProvisioners(path string, client zbus.Client) (*ExternalProvisioners, error) {
+	x := &ExternalProvisioners{
+		path:   path,
+		client: client,
+		types:  make(map[ReservationType]pkg.ExternalProvisionerID),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return x, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read external provisioners file %s", path)
+	}
+
+	if err := json.Unmarshal(data, &x.types); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode external provisioners file %s", path)
+	}
+
+	return x, nil
+}
+
+// Register routes the reservations of type typ to the provisioner id
+func (x *ExternalProvisioners) Register(typ ReservationType, id pkg.ExternalProvisionerID) error {
+	if len(typ) == 0 {
+		return fmt.Errorf("reservation type is required")
+	}
+
+	if len(id.Module) == 0 || len(id.Name) == 0 {
+		return fmt.Errorf("module and object name of the provisioner are required")
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	x.types[typ] = id
+	if err := x.save(); err != nil {
+		delete(x.types, typ)
+		return err
+	}
+
+	log.Info().
+		Str("type", string(typ)).
+		Str("module", id.Module).
+		Str("object", id.Name).
+		Msg("external provisioner registered")
+
+	return nil
+}
+
+// Unregister stops routing the reservations of type typ
+func (x *ExternalProvisioners) Unregister(typ ReservationType) error {
+	x.Lock()
+	defer x.Unlock()
+
+	id, ok := x.types[typ]
+	if !ok {
+		return fmt.Errorf("no external provisioner registered for type %s", typ)
+	}
+
+	delete(x.types, typ)
+	if err := x.save(); err != nil {
+		x.types[typ] = id
+		return err
+	}
+
+	log.Info().Str("type", string(typ)).Msg("external provisioner unregistered")
+	return nil
+}
+
+func (x *ExternalProvisioners) save() error {
+	data, err := json.Marshal(x.types)
+	if err != nil {
+		return err
+	}
+
+	tmp := x.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0660); err != nil {
+		return errors.Wrap(err, "failed to write external provisioners file")
+	}
+
+	return os.Rename(tmp, x.path)
+}
+
+// get returns the provisioner handling the reservations of type typ
+func (x *ExternalProvisioners) get(typ ReservationType) (pkg.ExternalProvisioner, bool) {
+	if x == nil {
+		return nil, false
+	}
+
+	x.RLock()
+	defer x.RUnlock()
+
+	id, ok := x.types[typ]
+	if !ok {
+		return nil, false
+	}
+
+	return &externalClient{
+		client: x.client,
+		module: id.Module,
+		object: zbus.ObjectID{Name: id.Name, Version: zbus.Version(id.Version)},
+	}, true
+}
+
+// Provisioner returns the ProvisionerFunc of the external type typ
+func (x *ExternalProvisioners) Provisioner(typ ReservationType) (ProvisionerFunc, bool) {
+	p, ok := x.get(typ)
+	if !ok {
+		return nil, false
+	}
+
+	return func(ctx context.Context, r *Reservation) (interface{}, error) {
+		return p.Provision(workloadRequest(r))
+	}, true
+}
+
+// Decommissioner returns the DecomissionerFunc of the external type typ
+func (x *ExternalProvisioners) Decommissioner(typ ReservationType) (DecomissionerFunc, bool) {
+	p, ok := x.get(typ)
+	if !ok {
+		return nil, false
+	}
+
+	return func(ctx context.Context, r *Reservation) error {
+		return p.Decommission(workloadRequest(r))
+	}, true
+}
+
+// Units returns the resource units used by r. ok is false if
+// the type of r is not handled by an external provisioner
+func (x *ExternalProvisioners) Units(r *Reservation) (u pkg.ResourceUnits, ok bool, err error) {
+	p, ok := x.get(r.Type)
+	if !ok {
+		return u, false, nil
+	}
+
+	u, err = p.ResourceUnits(workloadRequest(r))
+	return u, true, err
+}
+
+func workloadRequest(r *Reservation) pkg.WorkloadRequest {
+	return pkg.WorkloadRequest{
+		ID:       r.ID,
+		User:     r.User,
+		Type:     string(r.Type),
+		Created:  r.Created,
+		Duration: r.Duration,
+		Data:     r.Data,
+	}
+}
+
+// externalClient calls an ExternalProvisioner over zbus
+type externalClient struct {
+	client zbus.Client
+	module string
+	object zbus.ObjectID
+}
+
+var _ pkg.ExternalProvisioner = (*externalClient)(nil)
+
+func (c *externalClient) request(method string, w pkg.WorkloadRequest) (*zbus.Response, error) {
+	result, err := c.client.Request(c.module, c.object, method, w)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to call external provisioner %s.%s", c.module, c.object)
+	}
+
+	return result, nil
+}
+
+func (c *externalClient) Provision(w pkg.WorkloadRequest) (json.RawMessage, error) {
+	result, err := c.request("Provision", w)
+	if err != nil {
+		return nil, err
+	}
+
+	var data json.RawMessage
+	if err := result.Unmarshal(0, &data); err != nil {
+		return nil, err
+	}
+
+	var ret error = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret); err != nil {
+		return nil, err
+	}
+
+	return data, ret
+}
+
+func (c *externalClient) Decommission(w pkg.WorkloadRequest) error {
+	result, err := c.request("Decommission", w)
+	if err != nil {
+		return err
+	}
+
+	var ret error = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret); err != nil {
+		return err
+	}
+
+	return ret
+}
+
+func (c *externalClient) ResourceUnits(w pkg.WorkloadRequest) (u pkg.ResourceUnits, err error) {
+	result, err := c.request("ResourceUnits", w)
+	if err != nil {
+		return u, err
+	}
+
+	if err := result.Unmarshal(0, &u); err != nil {
+		return u, err
+	}
+
+	var ret error = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret); err != nil {
+		return u, err
+	}
+
+	return u, ret
+}
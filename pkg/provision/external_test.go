@@ -0,0 +1,95 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// testExternalClient answers the zbus requests sent to an external provisioner
+type testExternalClient struct {
+	zbus.Client
+	calls []string
+}
+
+func (c *testExternalClient) Request(module string, object zbus.ObjectID, method string, args ...interface{}) (*zbus.Response, error) {
+	w := args[0].(pkg.WorkloadRequest)
+	c.calls = append(c.calls, fmt.Sprintf("%s.%s.%s:%s", module, object.Name, method, w.ID))
+
+	switch method {
+	case "Provision":
+		return zbus.NewResponse("", "", json.RawMessage(`{"ip":"10.0.0.1"}`), nil)
+	case "Decommission":
+		return zbus.NewResponse("", "", fmt.Errorf("workload %s not found", w.ID))
+	case "ResourceUnits":
+		return zbus.NewResponse("", "", pkg.ResourceUnits{CRU: 2}, nil)
+	}
+
+	return nil, fmt.Errorf("unknown method %s", method)
+}
+
+func TestExternalProvisioners(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "external-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "external.json")
+	client := &testExternalClient{}
+	external, err := NewExternalProvisioners(path, client)
+	require.NoError(err)
+
+	engine := &Engine{
+		provisioners: map[ReservationType]ProvisionerFunc{
+			"container": func(ctx context.Context, r *Reservation) (interface{}, error) { return nil, nil },
+		},
+		external: external,
+	}
+
+	id := pkg.ExternalProvisionerID{Module: "gpud", Name: "gpu", Version: "0.0.1"}
+	require.Error(engine.RegisterProvisioner("container", id))
+	require.NoError(engine.RegisterProvisioner("gpu", id))
+
+	r := &Reservation{ID: "1-1", Type: "gpu", Data: json.RawMessage(`{}`)}
+
+	provision, ok := engine.provisioner("gpu")
+	require.True(ok)
+	result, err := provision(context.Background(), r)
+	require.NoError(err)
+	require.Equal(json.RawMessage(`{"ip":"10.0.0.1"}`), result)
+
+	decommission, ok := engine.decomissioner("gpu")
+	require.True(ok)
+	require.EqualError(decommission(context.Background(), r), "workload 1-1 not found")
+
+	units, ok, err := external.Units(r)
+	require.NoError(err)
+	require.True(ok)
+	require.Equal(uint64(2), units.CRU)
+
+	require.Equal([]string{
+		"gpud.gpu.Provision:1-1",
+		"gpud.gpu.Decommission:1-1",
+		"gpud.gpu.ResourceUnits:1-1",
+	}, client.calls)
+
+	// registrations survive a restart
+	external, err = NewExternalProvisioners(path, client)
+	require.NoError(err)
+	_, ok = external.Provisioner("gpu")
+	require.True(ok)
+
+	require.NoError(external.Unregister("gpu"))
+	_, ok = external.Decommissioner("gpu")
+	require.False(ok)
+	require.Error(external.Unregister("gpu"))
+}
@@ -0,0 +1,407 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+// dependenciesCheckInterval is how often the engine looks for reservations
+// that waited too long for their dependencies
+const dependenciesCheckInterval = 10 * time.Second
+
+// ErrInUse is returned when a reservation can't be decommissioned
+// because other deployed reservations depend on it
+type ErrInUse struct {
+	ID         string
+	Dependents []string
+}
+
+func (e ErrInUse) Error() string {
+	return fmt.Sprintf("reservation %s is used by %s", e.ID, strings.Join(e.Dependents, ", "))
+}
+
+// waiting is a reservation waiting for its dependencies to be deployed
+type waiting struct {
+	reservation *Reservation
+	missing     []string
+	since       time.Time
+	deadline    time.Time
+}
+
+// graph keeps track of the dependencies between the deployed reservations.
+// A nil graph has no dependencies
+type graph struct {
+	sync.Mutex
+	deps DependenciesFunc
+
+	// providers and users map a resource to the
+	// reservations providing and using it
+	providers map[string]map[string]struct{}
+	users     map[string]map[string]struct{}
+
+	waiting map[string]*waiting
+	ready   []*Reservation
+
+	// refused are the reservations which decommission has been refused
+	// because they are in use. They are moved to the released list
+	// once nothing uses them anymore
+	refused  map[string]*Reservation
+	released []*Reservation
+
+	notify chan struct{}
+}
+
+func newGraph(deps DependenciesFunc) *graph {
+	if deps == nil {
+		return nil
+	}
+
+	return &graph{
+		deps:      deps,
+		providers: make(map[string]map[string]struct{}),
+		users:     make(map[string]map[string]struct{}),
+		waiting:   make(map[string]*waiting),
+		refused:   make(map[string]*Reservation),
+		notify:    make(chan struct{}, 1),
+	}
+}
+
+func (g *graph) resources(r *Reservation) (provides []string, uses []string) {
+	provides, uses = g.deps(r)
+
+	// a reservation always provides itself
+	provides = append(provides, r.ID)
+	if r.Reference != "" {
+		provides = append(provides, r.Reference)
+	}
+
+	return provides, uses
+}
+
+// add registers the deployed reservation r. The reservations waiting
+// only for r are moved to the ready list
+func (g *graph) add(r *Reservation) {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	provides, uses := g.resources(r)
+	for _, key := range provides {
+		link(g.providers, key, r.ID)
+	}
+	for _, key := range uses {
+		link(g.users, key, r.ID)
+	}
+
+	for id, w := range g.waiting {
+		w.missing = g.missing(w.reservation)
+		if len(w.missing) > 0 {
+			continue
+		}
+
+		delete(g.waiting, id)
+		g.ready = append(g.ready, w.reservation)
+	}
+
+	if len(g.ready) > 0 {
+		g.signal()
+	}
+}
+
+// remove unregisters the decommissioned reservation r. The refused
+// reservations that were only used by r are moved to the released list
+func (g *graph) remove(r *Reservation) {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	provides, uses := g.resources(r)
+	for _, key := range provides {
+		unlink(g.providers, key, r.ID)
+	}
+	for _, key := range uses {
+		unlink(g.users, key, r.ID)
+	}
+
+	delete(g.refused, r.ID)
+	g.releaseUnused()
+}
+
+// refuse keeps r, which decommission has been refused because it is in use,
+// until the reservations using it are removed
+func (g *graph) refuse(r *Reservation) {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.refused[r.ID] = r
+}
+
+// release moves the refused reservations that are
+// not used anymore to the released list
+func (g *graph) release() {
+	if g == nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.releaseUnused()
+}
+
+// releaseUnused is release with g locked
+func (g *graph) releaseUnused() {
+	for id, r := range g.refused {
+		if len(g.dependentsOf(r)) > 0 {
+			continue
+		}
+
+		delete(g.refused, id)
+		g.released = append(g.released, r)
+	}
+
+	if len(g.released) > 0 {
+		g.signal()
+	}
+}
+
+func (g *graph) signal() {
+	select {
+	case g.notify <- struct{}{}:
+	default:
+	}
+}
+
+// park checks that all the resources used by r are deployed. If some are missing,
+// r waits until they are deployed or the deadline is reached, and the missing
+// resources are returned
+func (g *graph) park(r *Reservation, since, deadline time.Time) []string {
+	if g == nil {
+		return nil
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	missing := g.missing(r)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	g.waiting[r.ID] = &waiting{
+		reservation: r,
+		missing:     missing,
+		since:       since,
+		deadline:    deadline,
+	}
+
+	return missing
+}
+
+func (g *graph) missing(r *Reservation) []string {
+	var missing []string
+	_, uses := g.resources(r)
+	for _, key := range uses {
+		if len(g.providers[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+
+	return missing
+}
+
+// dependents returns the IDs of the deployed reservations that use a
+// resource provided by r and by no other reservation
+func (g *graph) dependents(r *Reservation) []string {
+	if g == nil {
+		return nil
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	return g.dependentsOf(r)
+}
+
+// dependentsOf is dependents with g locked
+func (g *graph) dependentsOf(r *Reservation) []string {
+	ids := make(map[string]struct{})
+	provides, _ := g.resources(r)
+	for _, key := range provides {
+		if len(g.providers[key]) > 1 {
+			// another reservation provides the same resource
+			// for example a newer version of a network
+			continue
+		}
+
+		for id := range g.users[key] {
+			if id != r.ID {
+				ids[id] = struct{}{}
+			}
+		}
+	}
+
+	dependents := make([]string, 0, len(ids))
+	for id := range ids {
+		dependents = append(dependents, id)
+	}
+	sort.Strings(dependents)
+
+	return dependents
+}
+
+// readyC is signaled when reservations are ready to be provisioned
+// or released reservations are ready to be decommissioned
+func (g *graph) readyC() <-chan struct{} {
+	if g == nil {
+		return nil
+	}
+
+	return g.notify
+}
+
+// takeReady returns the reservations that don't wait
+// for any resource anymore
+func (g *graph) takeReady() []*Reservation {
+	g.Lock()
+	defer g.Unlock()
+
+	ready := g.ready
+	g.ready = nil
+	return ready
+}
+
+// takeReleased returns the refused reservations
+// that are not used anymore
+func (g *graph) takeReleased() []*Reservation {
+	g.Lock()
+	defer g.Unlock()
+
+	released := g.released
+	g.released = nil
+	return released
+}
+
+// expired returns the waiting reservations that reached their deadline
+func (g *graph) expired(now time.Time) []*waiting {
+	if g == nil {
+		return nil
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	var expired []*waiting
+	for id, w := range g.waiting {
+		if now.Before(w.deadline) {
+			continue
+		}
+
+		delete(g.waiting, id)
+		expired = append(expired, w)
+	}
+
+	return expired
+}
+
+func link(m map[string]map[string]struct{}, key, id string) {
+	ids, ok := m[key]
+	if !ok {
+		ids = make(map[string]struct{})
+		m[key] = ids
+	}
+
+	ids[id] = struct{}{}
+}
+
+func unlink(m map[string]map[string]struct{}, key, id string) {
+	delete(m[key], id)
+	if len(m[key]) == 0 {
+		delete(m, key)
+	}
+}
+
+// loadGraph registers all the deployed reservations from the cache
+func (e *Engine) loadGraph() error {
+	reservations, err := e.cache.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list cached reservations")
+	}
+
+	for _, r := range reservations {
+		if r.Result.State != StateOk {
+			continue
+		}
+
+		e.graph.add(r)
+	}
+
+	// the reservations refused before the restart
+	// might not be used anymore
+	e.graph.release()
+
+	return nil
+}
+
+// decommissionDependents handles the deployed reservations that depend on r before
+// r is decommissioned. If cascade is enabled they are decommissioned first, otherwise
+// ErrInUse is returned
+func (e *Engine) decommissionDependents(ctx context.Context, r *Reservation) error {
+	dependents := e.graph.dependents(r)
+	if len(dependents) == 0 {
+		return nil
+	}
+
+	if !e.cascade {
+		return ErrInUse{ID: r.ID, Dependents: dependents}
+	}
+
+	for _, id := range dependents {
+		dependent, err := e.cache.Get(id)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get dependent reservation %s", id)
+		}
+
+		log.Info().Str("id", id).Str("dependency", r.ID).Msg("decommissioning dependent reservation")
+		if err := e.decommission(ctx, dependent); err != nil {
+			return errors.Wrapf(err, "failed to decommission dependent reservation %s", id)
+		}
+	}
+
+	return nil
+}
+
+// refuse reports to the owner of r that its decommission is refused because
+// other reservations use it. r is decommissioned once they are all removed
+func (e *Engine) refuse(ctx context.Context, r *Reservation, start time.Time, reason error) {
+	log.Warn().Err(reason).Str("id", r.ID).Msg("decommission refused, waiting for dependent reservations to be removed")
+
+	result, err := e.buildResult(r.ID, r.Type, reason, nil)
+	if err != nil {
+		log.Error().Err(err).Str("id", r.ID).Msg("failed to build result object for reservation")
+	} else if err := e.reply(ctx, result); err != nil {
+		log.Error().Err(err).Msg("failed to send result to BCDB")
+	}
+
+	e.graph.refuse(r)
+	e.setState(r, LifecycleInUse)
+	e.emit(r.ID, r.Type, pkg.PhaseFailed, start, reason)
+}
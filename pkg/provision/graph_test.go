@@ -0,0 +1,260 @@
+package provision
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func (c *TestCache) Get(id string) (*Reservation, error) {
+	returns := c.Called(id)
+	r, _ := returns.Get(0).(*Reservation)
+	return r, returns.Error(1)
+}
+
+// testDependencies makes containers use the network named in their data
+func testDependencies(r *Reservation) (provides []string, uses []string) {
+	switch r.Type {
+	case "network":
+		return []string{"network:" + string(r.Data)}, nil
+	case "container":
+		return nil, []string{"network:" + string(r.Data)}
+	}
+
+	return nil, nil
+}
+
+func TestGraphWait(t *testing.T) {
+	require := require.New(t)
+
+	g := newGraph(testDependencies)
+	network := &Reservation{ID: "1-1", Type: "network", Data: []byte("net")}
+	container := &Reservation{ID: "2-1", Type: "container", Data: []byte("net")}
+
+	now := time.Now()
+	require.Equal([]string{"network:net"}, g.park(container, now, now.Add(time.Minute)))
+	require.Empty(g.takeReady())
+
+	g.add(network)
+	select {
+	case <-g.readyC():
+	default:
+		require.Fail("ready channel not signaled")
+	}
+	require.Equal([]*Reservation{container}, g.takeReady())
+
+	// the network is deployed, no need to wait anymore
+	require.Empty(g.park(container, now, now.Add(time.Minute)))
+}
+
+func TestGraphExpired(t *testing.T) {
+	require := require.New(t)
+
+	g := newGraph(testDependencies)
+	container := &Reservation{ID: "2-1", Type: "container", Data: []byte("net")}
+
+	now := time.Now()
+	g.park(container, now, now.Add(time.Minute))
+	require.Empty(g.expired(now))
+
+	expired := g.expired(now.Add(time.Minute))
+	require.Len(expired, 1)
+	require.Equal(container, expired[0].reservation)
+	require.Equal([]string{"network:net"}, expired[0].missing)
+
+	// not waiting anymore once expired
+	require.Empty(g.expired(now.Add(time.Hour)))
+}
+
+func TestRecoverWaiting(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "journal-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	journal, err := NewJournal(root)
+	require.NoError(err)
+
+	// the data is stored in the journal, so it must be valid json
+	network := &Reservation{ID: "1-1", Type: "network", Data: []byte(`"net"`), Result: Result{State: StateOk}}
+	container := &Reservation{ID: "2-1", Type: "container", Data: []byte(`"net"`)}
+	require.NoError(journal.Set(container, LifecycleWaiting))
+
+	cache := &TestCache{}
+	cache.On("Exists", "2-1").Return(false, nil)
+	cache.On("List").Return([]*Reservation{network}, nil)
+
+	engine := &Engine{
+		cache:    cache,
+		feedback: &TestFeedback{},
+		statser:  &TestStatser{},
+		journal:  journal,
+		graph:    newGraph(testDependencies),
+	}
+
+	// the reservation parked before the restart waits again
+	// and is ready once the deployed network is loaded
+	require.NoError(engine.recover(context.Background()))
+	require.Empty(engine.graph.takeReady())
+
+	require.NoError(engine.loadGraph())
+	ready := engine.graph.takeReady()
+	require.Len(ready, 1)
+	require.Equal("2-1", ready[0].ID)
+}
+
+func TestRecoverInUse(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "journal-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	journal, err := NewJournal(root)
+	require.NoError(err)
+
+	network := &Reservation{ID: "1-1", Type: "network", Data: []byte(`"net"`), Result: Result{State: StateOk}}
+	require.NoError(journal.Set(network, LifecycleInUse))
+
+	// the container using the network has been removed before the restart
+	cache := &TestCache{}
+	cache.On("Exists", "1-1").Return(true, nil)
+	cache.On("List").Return([]*Reservation{network}, nil)
+
+	engine := &Engine{
+		cache:    cache,
+		feedback: &TestFeedback{},
+		statser:  &TestStatser{},
+		journal:  journal,
+		graph:    newGraph(testDependencies),
+	}
+
+	require.NoError(engine.recover(context.Background()))
+	require.Empty(engine.graph.takeReleased())
+
+	require.NoError(engine.loadGraph())
+	released := engine.graph.takeReleased()
+	require.Len(released, 1)
+	require.Equal("1-1", released[0].ID)
+}
+
+func TestGraphDependents(t *testing.T) {
+	require := require.New(t)
+
+	g := newGraph(testDependencies)
+	network := &Reservation{ID: "1-1", Type: "network", Data: []byte("net")}
+	c1 := &Reservation{ID: "2-1", Type: "container", Data: []byte("net")}
+	c2 := &Reservation{ID: "3-1", Type: "container", Data: []byte("net")}
+
+	g.add(network)
+	g.add(c2)
+	g.add(c1)
+	require.Equal([]string{"2-1", "3-1"}, g.dependents(network))
+	require.Empty(g.dependents(c1))
+
+	// a newer version of the network provides the same resource
+	// so the old one can go away
+	update := &Reservation{ID: "4-1", Type: "network", Data: []byte("net")}
+	g.add(update)
+	require.Empty(g.dependents(network))
+
+	g.remove(update)
+	g.remove(c1)
+	require.Equal([]string{"3-1"}, g.dependents(network))
+}
+
+func TestGraphNil(t *testing.T) {
+	var g *graph = newGraph(nil)
+	r := &Reservation{ID: "1-1", Type: "container"}
+
+	g.add(r)
+	require.Empty(t, g.park(r, time.Now(), time.Now()))
+	require.Empty(t, g.dependents(r))
+	require.Nil(t, g.readyC())
+	g.remove(r)
+}
+
+func TestDecommissionInUse(t *testing.T) {
+	network := &Reservation{ID: "1-1", Type: "network", Data: []byte("net"), Result: Result{State: StateOk}}
+	container := &Reservation{ID: "2-1", Type: "container", Data: []byte("net"), Result: Result{State: StateOk}}
+
+	setup := func(cascade bool) (*Engine, *[]string, *TestCache, *TestFeedback) {
+		var decommissioned []string
+		decommission := func(ctx context.Context, r *Reservation) error {
+			decommissioned = append(decommissioned, r.ID)
+			return nil
+		}
+
+		cache := &TestCache{}
+		cache.On("Exists", mock.Anything).Return(true, nil)
+		cache.On("Remove", mock.Anything).Return(nil)
+		cache.On("Get", container.ID).Return(container, nil)
+
+		feedback := &TestFeedback{}
+		feedback.On("Deleted", mock.Anything, mock.Anything).Return(nil)
+		feedback.On("Feedback", mock.Anything, mock.Anything).Return(nil)
+
+		engine := &Engine{
+			cache:    cache,
+			feedback: feedback,
+			statser:  &TestStatser{},
+			signer:   testSigner{},
+			decomissioners: map[ReservationType]DecomissionerFunc{
+				"network":   decommission,
+				"container": decommission,
+			},
+			graph:   newGraph(testDependencies),
+			cascade: cascade,
+		}
+		engine.graph.add(network)
+		engine.graph.add(container)
+
+		return engine, &decommissioned, cache, feedback
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		engine, decommissioned, _, feedback := setup(false)
+
+		err := engine.decommission(context.Background(), network)
+		require.Equal(t, ErrInUse{ID: "1-1", Dependents: []string{"2-1"}}, err)
+		require.Empty(t, *decommissioned)
+		require.Empty(t, engine.graph.takeReleased())
+
+		// the owner is told why the network is not deleted
+		feedback.AssertNotCalled(t, "Deleted", mock.Anything, "1-1")
+		result := feedback.Calls[0].Arguments.Get(1).(*Result)
+		require.Equal(t, "1-1", result.ID)
+		require.Equal(t, StateError, result.State)
+		require.Equal(t, "reservation 1-1 is used by 2-1", result.Error)
+
+		// the network is released once the container is removed
+		require.NoError(t, engine.decommission(context.Background(), container))
+		select {
+		case <-engine.graph.readyC():
+		default:
+			require.Fail(t, "ready channel not signaled")
+		}
+		require.Equal(t, []*Reservation{network}, engine.graph.takeReleased())
+
+		require.NoError(t, engine.decommission(context.Background(), network))
+		require.Equal(t, []string{"2-1", "1-1"}, *decommissioned)
+		feedback.AssertCalled(t, "Deleted", mock.Anything, "1-1")
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		engine, decommissioned, cache, _ := setup(true)
+
+		require.NoError(t, engine.decommission(context.Background(), network))
+		require.Equal(t, []string{"2-1", "1-1"}, *decommissioned)
+		cache.AssertCalled(t, "Remove", "2-1")
+		cache.AssertCalled(t, "Remove", "1-1")
+		require.Empty(t, engine.graph.providers)
+		require.Empty(t, engine.graph.users)
+	})
+}
@@ -2,9 +2,12 @@ package provision
 
 import (
 	"context"
+	"crypto/ed25519"
+	"time"
 
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/zos/pkg"
 )
 
 // ReservationSource interface. The source
@@ -27,6 +30,22 @@ type ProvisionerFunc func(ctx context.Context, reservation *Reservation) (interf
 // DecomissionerFunc is the function called by the Engine to decomission a workload
 type DecomissionerFunc func(ctx context.Context, reservation *Reservation) error
 
+// UpdaterFunc is the function called by the Engine to update a workload in place.
+// old is the reservation currently deployed and new the reservation with the new
+// configuration. Both carry the ID of the running workload
+type UpdaterFunc func(ctx context.Context, old, new *Reservation) (interface{}, error)
+
+// ReservationKeysFunc returns the list of resources a reservation touches
+// (for example the network or the volumes it uses). Reservations that share
+// at least one key are always processed in the order they were received, while
+// reservations without common keys can be processed in parallel
+type ReservationKeysFunc func(r *Reservation) []string
+
+// DependenciesFunc returns the resources provided by a reservation and the resources
+// it needs to be deployed, identified with the same keys as ReservationKeysFunc.
+// A reservation always provides its own ID, it doesn't need to be returned
+type DependenciesFunc func(r *Reservation) (provides []string, uses []string)
+
 // ReservationConverterFunc is used to convert from the explorer workloads type into the
 // internal Reservation type
 type ReservationConverterFunc func(w workloads.Workloader) (*Reservation, error)
@@ -41,6 +60,7 @@ type ReservationCache interface {
 	Get(id string) (*Reservation, error)
 	Remove(id string) error
 	Exists(id string) (bool, error)
+	List() ([]*Reservation, error)
 	NetworkExists(id string) (bool, error)
 	Sync(Statser) error
 }
@@ -51,6 +71,45 @@ type Feedbacker interface {
 	Feedback(nodeID string, r *Result) error
 	Deleted(nodeID, id string) error
 	UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error
+	// Expiring warns that the reservation of r expires at expiry
+	// so the owner can be reminded to renew it. r is the signed result
+	// of the reservation with the warning as message
+	Expiring(nodeID string, r *Result, expiry time.Time) error
+}
+
+// UserKeyGetter is used by the engine to retrieve the public key
+// of the user that signed a reservation
+type UserKeyGetter interface {
+	PublicKey(userID string) (ed25519.PublicKey, error)
+}
+
+// CapacityChecker is used by the engine to make sure the node has enough
+// free capacity to deploy a reservation before provisioning it
+type CapacityChecker interface {
+	Check(r *Reservation) (pkg.Admission, error)
+}
+
+// QuotaChecker is used by the engine to make sure the user of a reservation
+// stays within the quota the node gives him
+type QuotaChecker interface {
+	// Check returns pkg.ErrQuotaExceeded if deploying r puts its user
+	// over its quota. The engine reserves the resources of an admitted
+	// reservation in the Statser under the same lock as the check
+	Check(r *Reservation) error
+	// Usage returns the resources reserved by each user
+	Usage() []pkg.UserUsage
+}
+
+// Meter records the resources consumed by the
+// deployed reservations over time
+type Meter interface {
+	// Report returns the signed report of the resources
+	// consumed by user between from and to
+	Report(user string, from, to time.Time) (pkg.UsageReport, error)
+	// Decommissioned removes r from the cache with remove and records the
+	// usage of r since the last sample. No sample is taken in between, so
+	// the usage of r is recorded exactly once
+	Decommissioned(r *Reservation, remove func() error) error
 }
 
 // Signer interface is used to sign reservation result before
@@ -0,0 +1,187 @@
+package provision
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// LifecycleState is the state of a reservation while it
+// is processed by the engine
+type LifecycleState string
+
+const (
+	// LifecycleReceived the reservation has been received by the engine
+	LifecycleReceived LifecycleState = "received"
+	// LifecycleWaiting the reservation waits for its dependencies to be deployed
+	LifecycleWaiting LifecycleState = "waiting"
+	// LifecycleProvisioning the workload is being deployed
+	LifecycleProvisioning LifecycleState = "provisioning"
+	// LifecycleDeployed the workload is deployed
+	LifecycleDeployed LifecycleState = "deployed"
+	// LifecycleFailed the workload could not be deployed
+	LifecycleFailed LifecycleState = "failed"
+	// LifecycleInUse the decommission of the workload is refused and waits
+	// for the reservations using it to be removed
+	LifecycleInUse LifecycleState = "in-use"
+	// LifecycleDecommissioning the workload is being removed
+	LifecycleDecommissioning LifecycleState = "decommissioning"
+	// LifecycleDeleted the workload has been removed
+	LifecycleDeleted LifecycleState = "deleted"
+)
+
+// Final returns true if the state is not an intermediate state
+func (s LifecycleState) Final() bool {
+	switch s {
+	case LifecycleDeployed, LifecycleFailed, LifecycleDeleted:
+		return true
+	}
+
+	return false
+}
+
+// journalRetention is how long failed and deleted
+// reservations are kept in the journal
+const journalRetention = 7 * 24 * time.Hour
+
+// JournalEntry is the persisted state of a reservation
+type JournalEntry struct {
+	State       LifecycleState `json:"state"`
+	Updated     time.Time      `json:"updated"`
+	Reservation *Reservation   `json:"reservation"`
+}
+
+// Journal persists the lifecycle state of the reservations processed
+// by the engine, so operations interrupted by a crash or a reboot
+// can be recovered the next time the engine starts
+type Journal struct {
+	sync.RWMutex
+	root string
+}
+
+// NewJournal creates a new journal that stores
+// the reservations states in root
+func NewJournal(root string) (*Journal, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create journal directory %s", root)
+	}
+
+	return &Journal{root: root}, nil
+}
+
+// Set persists the state of reservation r
+func (j *Journal) Set(r *Reservation, state LifecycleState) error {
+	j.Lock()
+	defer j.Unlock()
+
+	entry := JournalEntry{
+		State:       state,
+		Updated:     time.Now(),
+		Reservation: r,
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode journal entry")
+	}
+
+	// write to a temporary file first so a crash never
+	// leaves a partially written entry behind
+	tmp, err := ioutil.TempFile(j.root, ".entry-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(j.root, r.ID))
+}
+
+// Get returns the journal entry of reservation id
+func (j *Journal) Get(id string) (JournalEntry, error) {
+	j.RLock()
+	defer j.RUnlock()
+
+	return j.get(id)
+}
+
+// Remove deletes the entry of reservation id from the journal
+func (j *Journal) Remove(id string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	err := os.Remove(filepath.Join(j.root, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List returns all the entries of the journal
+func (j *Journal) List() ([]JournalEntry, error) {
+	j.RLock()
+	defer j.RUnlock()
+
+	infos, err := ioutil.ReadDir(j.root)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]JournalEntry, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() || info.Name()[0] == '.' {
+			continue
+		}
+
+		entry, err := j.get(info.Name())
+		if err != nil {
+			log.Error().Err(err).Str("id", info.Name()).Msg("failed to read journal entry, skipping")
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (j *Journal) get(id string) (JournalEntry, error) {
+	var entry JournalEntry
+
+	data, err := ioutil.ReadFile(filepath.Join(j.root, id))
+	if os.IsNotExist(err) {
+		return entry, errors.Wrapf(err, "reservation %s not found in journal", id)
+	} else if err != nil {
+		return entry, err
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, errors.Wrapf(err, "failed to decode journal entry %s", id)
+	}
+
+	if entry.Reservation == nil {
+		return entry, errors.Errorf("journal entry %s has no reservation", id)
+	}
+
+	return entry, nil
+}
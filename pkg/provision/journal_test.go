@@ -0,0 +1,142 @@
+package provision
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+)
+
+type TestCache struct {
+	mock.Mock
+	ReservationCache
+}
+
+func (c *TestCache) Exists(id string) (bool, error) {
+	returns := c.Called(id)
+	return returns.Bool(0), returns.Error(1)
+}
+
+func (c *TestCache) Remove(id string) error {
+	return c.Called(id).Error(0)
+}
+
+type TestFeedback struct {
+	mock.Mock
+}
+
+func (f *TestFeedback) Feedback(nodeID string, r *Result) error {
+	return f.Called(nodeID, r).Error(0)
+}
+
+func (f *TestFeedback) Deleted(nodeID, id string) error {
+	return f.Called(nodeID, id).Error(0)
+}
+
+func (f *TestFeedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	return nil
+}
+
+func (f *TestFeedback) Expiring(nodeID string, r *Result, expiry time.Time) error {
+	return f.Called(nodeID, r, expiry).Error(0)
+}
+
+type TestStatser struct {
+	Statser
+}
+
+func (s *TestStatser) Decrement(r *Reservation) error                     { return nil }
+func (s *TestStatser) CurrentUnits() directory.ResourceAmount             { return directory.ResourceAmount{} }
+func (s *TestStatser) CurrentWorkloads() directory.WorkloadAmount         { return directory.WorkloadAmount{} }
+func (s *TestStatser) CheckMemoryRequirements(*Reservation, uint64) error { return nil }
+
+func TestJournal(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "journal-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	journal, err := NewJournal(root)
+	require.NoError(err)
+
+	r := &Reservation{ID: "1-1", Type: "container"}
+	require.NoError(journal.Set(r, LifecycleProvisioning))
+	require.NoError(journal.Set(r, LifecycleDeployed))
+
+	entry, err := journal.Get("1-1")
+	require.NoError(err)
+	require.Equal(LifecycleDeployed, entry.State)
+	require.Equal("1-1", entry.Reservation.ID)
+
+	entries, err := journal.List()
+	require.NoError(err)
+	require.Len(entries, 1)
+
+	require.NoError(journal.Remove("1-1"))
+	_, err = journal.Get("1-1")
+	require.Error(err)
+}
+
+func TestEngineRecover(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "journal-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	journal, err := NewJournal(root)
+	require.NoError(err)
+
+	received := &Reservation{ID: "1-1", Type: "volume"}
+	provisioning := &Reservation{ID: "2-1", Type: "volume"}
+	decommissioning := &Reservation{ID: "3-1", Type: "volume", Result: Result{State: StateOk}}
+
+	require.NoError(journal.Set(received, LifecycleReceived))
+	require.NoError(journal.Set(provisioning, LifecycleProvisioning))
+	require.NoError(journal.Set(decommissioning, LifecycleDecommissioning))
+
+	var decommissioned []string
+	cache := &TestCache{}
+	feedback := &TestFeedback{}
+
+	cache.On("Exists", "2-1").Return(false, nil)
+	cache.On("Exists", "3-1").Return(true, nil)
+	cache.On("Remove", "3-1").Return(nil)
+	feedback.On("Deleted", "node", "3-1").Return(nil)
+
+	engine := &Engine{
+		nodeID:   "node",
+		cache:    cache,
+		feedback: feedback,
+		statser:  &TestStatser{},
+		journal:  journal,
+		decomissioners: map[ReservationType]DecomissionerFunc{
+			"volume": func(ctx context.Context, r *Reservation) error {
+				decommissioned = append(decommissioned, r.ID)
+				return nil
+			},
+		},
+	}
+
+	require.NoError(engine.recover(context.Background()))
+
+	// partial provision is rolled back and the decommission is resumed
+	require.Equal([]string{"2-1", "3-1"}, decommissioned)
+	cache.AssertExpectations(t)
+	feedback.AssertExpectations(t)
+
+	_, err = journal.Get("1-1")
+	require.Error(err)
+	_, err = journal.Get("2-1")
+	require.Error(err)
+
+	entry, err := journal.Get("3-1")
+	require.NoError(err)
+	require.Equal(LifecycleDeleted, entry.State)
+}
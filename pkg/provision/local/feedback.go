@@ -0,0 +1,113 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// Feedback is an implementation of the provision.Feedbacker that
+// stores the provision results next to the reservations of a local Store
+// in <root>/results/<id>.json
+type Feedback struct {
+	root string
+}
+
+// NewFeedback creates a local Feedback that writes results under root
+func NewFeedback(root string) (*Feedback, error) {
+	root = filepath.Join(root, "results")
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create local results directory %s", root)
+	}
+
+	return &Feedback{root: root}, nil
+}
+
+// Feedback implements provision.Feedbacker
+func (f *Feedback) Feedback(nodeID string, r *provision.Result) error {
+	if err := validID(r.ID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode result")
+	}
+
+	return ioutil.WriteFile(f.path(r.ID), data, 0660)
+}
+
+// Deleted implements provision.Feedbacker
+func (f *Feedback) Deleted(nodeID, id string) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+
+	result := provision.Result{ID: id}
+	if data, err := ioutil.ReadFile(f.path(id)); err == nil {
+		if err := json.Unmarshal(data, &result); err != nil {
+			log.Error().Err(err).Str("id", id).Msg("failed to decode existing result")
+		}
+	}
+
+	result.State = provision.StateDeleted
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode result")
+	}
+
+	return ioutil.WriteFile(f.path(id), data, 0660)
+}
+
+// UpdateStats implements provision.Feedbacker
+func (f *Feedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	log.Debug().
+		Str("node", nodeID).
+		Str("workloads", fmt.Sprintf("%+v", w)).
+		Str("units", fmt.Sprintf("%+v", u)).
+		Msg("local statistics update")
+	return nil
+}
+
+// Expiring implements provision.Feedbacker
+// The warning replaces the result of the reservation, so it
+// is returned with the result until the reservation is renewed
+func (f *Feedback) Expiring(nodeID string, r *provision.Result, expiry time.Time) error {
+	log.Info().
+		Str("node", nodeID).
+		Str("id", r.ID).
+		Time("expiry", expiry).
+		Msg("reservation is about to expire")
+	return f.Feedback(nodeID, r)
+}
+
+// Get returns the result of a reservation
+func (f *Feedback) Get(id string) (*provision.Result, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(f.path(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var result provision.Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode result %s", id)
+	}
+
+	return &result, nil
+}
+
+func (f *Feedback) path(id string) string {
+	return filepath.Join(f.root, id+reservationExt)
+}
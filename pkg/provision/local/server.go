@@ -0,0 +1,147 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// Server exposes a local Store over http on a unix socket
+// so reservations can be pushed to the node without touching
+// the files directly
+//
+// The following endpoints are available:
+//  POST   /reservations       create or update a reservation
+//  GET    /reservations/{id}  get a reservation
+//  DELETE /reservations/{id}  mark a reservation to be deleted
+//  GET    /results/{id}       get the provision result of a reservation
+type Server struct {
+	store    *Store
+	feedback *Feedback
+}
+
+// NewServer creates a new local reservation server.
+// feedback can be nil, in that case results are not exposed
+func NewServer(store *Store, feedback *Feedback) *Server {
+	return &Server{
+		store:    store,
+		feedback: feedback,
+	}
+}
+
+// Serve listens on the unix socket and serves requests until ctx is done
+func (s *Server) Serve(ctx context.Context, socket string) error {
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove old socket %s", socket)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", socket)
+	}
+
+	server := http.Server{
+		Handler: s.Handler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close local reservation server")
+		}
+	}()
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// Handler returns the http handler of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reservations", s.create)
+	mux.HandleFunc("/reservations/", s.reservation)
+	mux.HandleFunc("/results/", s.result)
+
+	return mux
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var reservation provision.Reservation
+	if err := json.NewDecoder(r.Body).Decode(&reservation); err != nil {
+		http.Error(w, errors.Wrap(err, "failed to decode reservation").Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.Add(&reservation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, reservation)
+}
+
+func (s *Server) reservation(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/reservations/")
+
+	switch r.Method {
+	case http.MethodGet:
+		reservation, err := s.store.Get(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, reservation)
+
+	case http.MethodDelete:
+		if err := s.store.Delete(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) result(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.feedback == nil {
+		http.Error(w, "results are not available", http.StatusNotFound)
+		return
+	}
+
+	result, err := s.feedback.Get(strings.TrimPrefix(r.URL.Path, "/results/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error().Err(err).Msg("failed to write response")
+	}
+}
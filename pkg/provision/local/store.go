@@ -0,0 +1,307 @@
+// Package local implements a reservation source that reads reservations
+// from a directory on the node instead of the TFExplorer. It is meant for
+// lab setups, air-gapped farms and integration tests.
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+const (
+	reservationExt = ".json"
+	sequenceFile   = ".sequence"
+)
+
+// entry is the content of a reservation file. The sequence is
+// assigned by the store every time the reservation is written
+type entry struct {
+	provision.Reservation
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// Store is an implementation of the provision.ReservationPoller that
+// reads signed reservations from a local directory. Each reservation is a
+// JSON encoded provision.Reservation stored in <root>/<id>.json
+//
+// Every write of a reservation gives it a new sequence number, stored with
+// the reservation, which is used as the poll cursor. So a reservation that
+// is written again (for example to set the to_delete flag) is sent to the
+// engine once more. The last sequence number is also persisted in
+// <root>/.sequence so it never goes back, even if files are removed.
+//
+// Reservation files copied in the directory by hand have no sequence, they
+// get one the next time the store is polled.
+type Store struct {
+	sync.RWMutex
+	root           string
+	provisionOrder map[provision.ReservationType]int
+	sequence       uint64
+}
+
+// NewStore creates a local reservation store rooted at root
+// provisionOrder is used the same way as explorer.NewPoller to order the
+// reservations before sending them to the engine
+func NewStore(root string, provisionOrder map[provision.ReservationType]int) (*Store, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create local reservation directory %s", root)
+	}
+
+	s := &Store{
+		root:           root,
+		provisionOrder: provisionOrder,
+	}
+
+	if err := s.loadSequence(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadSequence sets the sequence of the store to the highest
+// of the persisted sequence and the sequences of the entries
+func (s *Store) loadSequence() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.root, sequenceFile))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read local reservation sequence")
+	} else if err == nil {
+		s.sequence, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "invalid local reservation sequence")
+		}
+	}
+
+	ids, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		e, err := s.get(id)
+		if err != nil {
+			continue
+		}
+
+		if e.Sequence > s.sequence {
+			s.sequence = e.Sequence
+		}
+	}
+
+	return nil
+}
+
+// Get implements provision.ReservationGetter
+func (s *Store) Get(gwid string) (*provision.Reservation, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	e, err := s.get(gwid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e.Reservation, nil
+}
+
+// Poll implements provision.ReservationPoller
+func (s *Store) Poll(nodeID pkg.Identifier, from uint64) ([]*provision.Reservation, uint64, error) {
+	// reservations without sequence are written back, hence the write lock
+	s.Lock()
+	defer s.Unlock()
+
+	ids, err := s.list()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// if nothing changed since last poll we return the same
+	// cursor as the previous call so the poll source knows
+	// all the reservations have been processed
+	var lastID uint64
+	if from > 0 {
+		lastID = from - 1
+	}
+
+	result := make([]*provision.Reservation, 0, len(ids))
+	for _, id := range ids {
+		e, err := s.get(id)
+		if err != nil {
+			log.Error().Err(err).Str("id", id).Msg("failed to load local reservation, skipping")
+			continue
+		}
+
+		if e.Sequence == 0 {
+			if err := s.add(e); err != nil {
+				log.Error().Err(err).Str("id", id).Msg("failed to assign sequence to local reservation, skipping")
+				continue
+			}
+		}
+
+		if e.Sequence < from {
+			continue
+		}
+
+		r := &e.Reservation
+
+		if r.NodeID != "" && r.NodeID != nodeID.Identity() {
+			log.Warn().Str("id", id).Str("node", r.NodeID).Msg("local reservation is not for this node, skipping")
+			continue
+		}
+
+		if e.Sequence > lastID {
+			lastID = e.Sequence
+		}
+
+		result = append(result, r)
+	}
+
+	if s.provisionOrder != nil {
+		// sorts the workloads in the oder they need to be processed by provisiond
+		sort.SliceStable(result, func(i int, j int) bool {
+			return s.provisionOrder[result[i].Type] < s.provisionOrder[result[j].Type]
+		})
+	}
+
+	return result, lastID, nil
+}
+
+// Add writes a reservation into the store. If a reservation
+// with the same ID already exists, it is overwritten
+func (s *Store) Add(r *provision.Reservation) error {
+	if err := validID(r.ID); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.add(&entry{Reservation: *r})
+}
+
+// add writes e with the next sequence number
+func (s *Store) add(e *entry) error {
+	sequence := s.sequence + 1
+
+	// the sequence is persisted first, so it is never
+	// reused even if the entry is removed afterward
+	if err := s.write(sequenceFile, []byte(strconv.FormatUint(sequence, 10))); err != nil {
+		return errors.Wrap(err, "failed to persist local reservation sequence")
+	}
+	s.sequence = sequence
+	e.Sequence = sequence
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode reservation")
+	}
+
+	return s.write(e.ID+reservationExt, data)
+}
+
+// write data to the file name of the store
+func (s *Store) write(name string, data []byte) error {
+	// write to a temporary file first so a poll never
+	// reads a partially written file
+	tmp, err := ioutil.TempFile(s.root, ".reservation-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.root, name))
+}
+
+// Delete marks a reservation to be deleted
+func (s *Store) Delete(id string) error {
+	r, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	r.ToDelete = true
+	return s.Add(r)
+}
+
+// list returns the IDs of the reservations of the store
+func (s *Store) list() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list local reservations")
+	}
+
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, reservationExt) {
+			continue
+		}
+
+		ids = append(ids, strings.TrimSuffix(name, reservationExt))
+	}
+
+	return ids, nil
+}
+
+func (s *Store) get(id string) (*entry, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "reservation %s not found", id)
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var e entry
+	if err := json.NewDecoder(f).Decode(&e); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode reservation %s", id)
+	}
+
+	if e.ID == "" {
+		e.ID = id
+	}
+
+	return &e, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.root, id+reservationExt)
+}
+
+// validID makes sure a reservation ID can safely be used as a file name
+func validID(id string) error {
+	if len(id) == 0 {
+		return fmt.Errorf("reservation has no id")
+	}
+
+	if strings.ContainsAny(id, "/\\") || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("invalid reservation id '%s'", id)
+	}
+
+	return nil
+}
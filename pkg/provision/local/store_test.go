@@ -0,0 +1,111 @@
+package local
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestStorePoll(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "local-store")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	order := map[provision.ReservationType]int{
+		"network":   0,
+		"container": 1,
+	}
+
+	store, err := NewStore(root, order)
+	require.NoError(err)
+
+	nodeID := pkg.StrIdentifier("node-id")
+
+	require.NoError(store.Add(&provision.Reservation{ID: "2-1", Type: "container"}))
+	require.NoError(store.Add(&provision.Reservation{ID: "1-1", Type: "network"}))
+	require.NoError(store.Add(&provision.Reservation{ID: "3-1", Type: "container", NodeID: "other-node"}))
+
+	reservations, lastID, err := store.Poll(nodeID, 0)
+	require.NoError(err)
+	require.Len(reservations, 2)
+	require.Equal("1-1", reservations[0].ID)
+	require.Equal("2-1", reservations[1].ID)
+
+	// nothing changed, the cursor must not move
+	reservations, again, err := store.Poll(nodeID, lastID+1)
+	require.NoError(err)
+	require.Len(reservations, 0)
+	require.Equal(lastID, again)
+
+	require.NoError(store.Delete("2-1"))
+
+	reservations, next, err := store.Poll(nodeID, lastID+1)
+	require.NoError(err)
+	require.Len(reservations, 1)
+	require.Equal("2-1", reservations[0].ID)
+	require.True(reservations[0].ToDelete)
+	require.True(next > lastID)
+}
+
+func TestStoreSequence(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "local-store")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	store, err := NewStore(root, nil)
+	require.NoError(err)
+
+	nodeID := pkg.StrIdentifier("node-id")
+
+	require.NoError(store.Add(&provision.Reservation{ID: "1-1", Type: "container"}))
+	_, lastID, err := store.Poll(nodeID, 0)
+	require.NoError(err)
+
+	// a reservation copied by hand gets a sequence on the next poll
+	err = ioutil.WriteFile(filepath.Join(root, "2-1.json"), []byte(`{"type": "container"}`), 0644)
+	require.NoError(err)
+
+	reservations, next, err := store.Poll(nodeID, lastID+1)
+	require.NoError(err)
+	require.Len(reservations, 1)
+	require.Equal("2-1", reservations[0].ID)
+	require.True(next > lastID)
+
+	// the sequence survives a restart and the removal of the last entry
+	require.NoError(os.Remove(filepath.Join(root, "2-1.json")))
+	store, err = NewStore(root, nil)
+	require.NoError(err)
+
+	require.NoError(store.Add(&provision.Reservation{ID: "3-1", Type: "container"}))
+	reservations, last, err := store.Poll(nodeID, next+1)
+	require.NoError(err)
+	require.Len(reservations, 1)
+	require.Equal("3-1", reservations[0].ID)
+	require.True(last > next)
+}
+
+func TestStoreInvalidID(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "local-store")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	store, err := NewStore(root, nil)
+	require.NoError(err)
+
+	require.Error(store.Add(&provision.Reservation{}))
+	require.Error(store.Add(&provision.Reservation{ID: "../1-1"}))
+
+	_, err = store.Get("../../etc/passwd")
+	require.Error(err)
+}
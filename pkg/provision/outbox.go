@@ -0,0 +1,330 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+)
+
+const (
+	outboxResult   = "result"
+	outboxDeleted  = "deleted"
+	outboxStats    = "stats"
+	outboxExpiring = "expiring"
+
+	outboxExt = ".json"
+)
+
+// outboxMessage is a feedback call waiting to be sent
+type outboxMessage struct {
+	Kind      string                   `json:"kind"`
+	NodeID    string                   `json:"node_id"`
+	Result    *Result                  `json:"result,omitempty"`
+	ID        string                   `json:"id,omitempty"`
+	Workloads directory.WorkloadAmount `json:"workloads"`
+	Resources directory.ResourceAmount `json:"resources"`
+	Expiry    *time.Time               `json:"expiry,omitempty"`
+}
+
+type rejectedError struct {
+	err error
+}
+
+func (e rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e rejectedError) Unwrap() error {
+	return e.err
+}
+
+// Rejected marks err as the refusal of a message by the receiver of the
+// feedback. Feedbackers use it to let the Outbox know that sending the
+// message again would fail the same way
+func Rejected(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return rejectedError{err: err}
+}
+
+// IsRejected returns true if err is marked with Rejected
+func IsRejected(err error) bool {
+	return errors.As(err, &rejectedError{})
+}
+
+// Outbox is a Feedbacker that queues all the feedback on disk
+// before sending them to the wrapped Feedbacker. Messages are sent
+// in the order they are queued, and failures are retried with a backoff
+// until the wrapped Feedbacker accepts them or rejects them (see Rejected).
+// Since the queue is on disk pending messages are sent again after a restart.
+// Only the latest statistics are kept in the queue, they replace the ones
+// not sent yet.
+//
+// Outbox.Run must be running for the messages to be sent
+type Outbox struct {
+	sync.Mutex
+	root     string
+	feedback Feedbacker
+	next     uint64
+	notify   chan struct{}
+	// stats is the sequence number of the queued
+	// statistics message, if hasStats is true
+	stats    uint64
+	hasStats bool
+}
+
+var _ Feedbacker = (*Outbox)(nil)
+
+// NewOutbox creates an outbox that stores the messages in root
+// and sends them to feedback
+func NewOutbox(root string, feedback Feedbacker) (*Outbox, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create outbox directory %s", root)
+	}
+
+	o := &Outbox{
+		root:     root,
+		feedback: feedback,
+		notify:   make(chan struct{}, 1),
+	}
+
+	pending, err := o.pending()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) > 0 {
+		log.Info().Int("count", len(pending)).Msg("found pending feedback messages in outbox")
+		o.next = pending[len(pending)-1] + 1
+	}
+
+	for _, seq := range pending {
+		msg, err := o.get(seq)
+		if err != nil || msg.Kind != outboxStats {
+			continue
+		}
+
+		if o.hasStats {
+			o.remove(o.stats)
+		}
+		o.stats, o.hasStats = seq, true
+	}
+
+	return o, nil
+}
+
+// Feedback implements Feedbacker
+func (o *Outbox) Feedback(nodeID string, r *Result) error {
+	return o.push(outboxMessage{Kind: outboxResult, NodeID: nodeID, Result: r})
+}
+
+// Deleted implements Feedbacker
+func (o *Outbox) Deleted(nodeID, id string) error {
+	return o.push(outboxMessage{Kind: outboxDeleted, NodeID: nodeID, ID: id})
+}
+
+// UpdateStats implements Feedbacker
+func (o *Outbox) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	return o.push(outboxMessage{Kind: outboxStats, NodeID: nodeID, Workloads: w, Resources: u})
+}
+
+// Expiring implements Feedbacker
+func (o *Outbox) Expiring(nodeID string, r *Result, expiry time.Time) error {
+	return o.push(outboxMessage{Kind: outboxExpiring, NodeID: nodeID, Result: r, Expiry: &expiry})
+}
+
+// Run sends the queued messages until ctx is done
+func (o *Outbox) Run(ctx context.Context) {
+	for {
+		o.flush(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+func (o *Outbox) flush(ctx context.Context) {
+	for {
+		pending, err := o.pending()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to list outbox messages")
+			return
+		}
+
+		if len(pending) == 0 {
+			return
+		}
+
+		seq := pending[0]
+		msg, err := o.get(seq)
+		if err != nil {
+			log.Error().Err(err).Uint64("seq", seq).Msg("dropping invalid outbox message")
+			o.remove(seq)
+			continue
+		}
+
+		bo := backoff.NewExponentialBackOff()
+		bo.MaxInterval = time.Minute
+		bo.MaxElapsedTime = 0 // retry until it succeeds
+
+		err = backoff.RetryNotify(func() error {
+			err := o.send(msg)
+			if IsRejected(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}, backoff.WithContext(bo, ctx), func(err error, d time.Duration) {
+			log.Warn().Err(err).Str("kind", msg.Kind).Msgf("failed to send feedback, retrying in %s", d)
+		})
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			// retrying won't change anything, the message is dropped
+			// so it doesn't block the ones queued after it
+			log.Error().Err(err).Str("kind", msg.Kind).Msg("feedback rejected, dropping message")
+		}
+
+		o.remove(seq)
+	}
+}
+
+func (o *Outbox) send(msg outboxMessage) error {
+	switch msg.Kind {
+	case outboxResult:
+		return o.feedback.Feedback(msg.NodeID, msg.Result)
+	case outboxDeleted:
+		return o.feedback.Deleted(msg.NodeID, msg.ID)
+	case outboxStats:
+		return o.feedback.UpdateStats(msg.NodeID, msg.Workloads, msg.Resources)
+	case outboxExpiring:
+		if msg.Result == nil || msg.Expiry == nil {
+			break
+		}
+		return o.feedback.Expiring(msg.NodeID, msg.Result, *msg.Expiry)
+	}
+
+	return backoff.Permanent(fmt.Errorf("unknown outbox message kind '%s'", msg.Kind))
+}
+
+func (o *Outbox) push(msg outboxMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode outbox message")
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	// write to a temporary file first so a crash never
+	// leaves a partially written message behind
+	tmp, err := ioutil.TempFile(o.root, ".message-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), o.path(o.next)); err != nil {
+		return errors.Wrap(err, "failed to queue outbox message")
+	}
+
+	if msg.Kind == outboxStats {
+		// the statistics not sent yet are outdated
+		if o.hasStats {
+			o.remove(o.stats)
+		}
+		o.stats, o.hasStats = o.next, true
+	}
+	o.next++
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// pending returns the sequence numbers of the queued messages in order
+func (o *Outbox) pending() ([]uint64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	infos, err := ioutil.ReadDir(o.root)
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([]uint64, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, outboxExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, outboxExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seqs = append(seqs, seq)
+	}
+
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func (o *Outbox) get(seq uint64) (msg outboxMessage, err error) {
+	data, err := ioutil.ReadFile(o.path(seq))
+	if err != nil {
+		return msg, err
+	}
+
+	err = json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+func (o *Outbox) remove(seq uint64) {
+	if err := os.Remove(o.path(seq)); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Uint64("seq", seq).Msg("failed to remove outbox message")
+	}
+}
+
+func (o *Outbox) path(seq uint64) string {
+	return filepath.Join(o.root, fmt.Sprintf("%020d%s", seq, outboxExt))
+}
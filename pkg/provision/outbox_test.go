@@ -0,0 +1,159 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+)
+
+type recordFeedback struct {
+	sync.Mutex
+	fail   int
+	reject int
+	calls  []string
+}
+
+func (f *recordFeedback) record(call string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.fail > 0 {
+		f.fail--
+		return fmt.Errorf("explorer is down")
+	}
+
+	if f.reject > 0 {
+		f.reject--
+		return Rejected(fmt.Errorf("bad request"))
+	}
+
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *recordFeedback) Feedback(nodeID string, r *Result) error {
+	return f.record("result:" + r.ID)
+}
+
+func (f *recordFeedback) Deleted(nodeID, id string) error {
+	return f.record("deleted:" + id)
+}
+
+func (f *recordFeedback) UpdateStats(nodeID string, w directory.WorkloadAmount, u directory.ResourceAmount) error {
+	return f.record("stats")
+}
+
+func (f *recordFeedback) Expiring(nodeID string, r *Result, expiry time.Time) error {
+	return f.record("expiring:" + r.ID)
+}
+
+func (f *recordFeedback) Calls() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func TestOutbox(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "outbox-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	feedback := &recordFeedback{fail: 2}
+	outbox, err := NewOutbox(root, feedback)
+	require.NoError(err)
+
+	require.NoError(outbox.Feedback("node", &Result{ID: "1-1"}))
+	require.NoError(outbox.Deleted("node", "2-1"))
+
+	// messages survive a restart
+	outbox, err = NewOutbox(root, feedback)
+	require.NoError(err)
+	require.NoError(outbox.UpdateStats("node", directory.WorkloadAmount{}, directory.ResourceAmount{}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go outbox.Run(ctx)
+
+	require.Eventually(func() bool {
+		return len(feedback.Calls()) == 3
+	}, 10*time.Second, 50*time.Millisecond)
+
+	require.Equal([]string{"result:1-1", "deleted:2-1", "stats"}, feedback.Calls())
+
+	pending, err := outbox.pending()
+	require.NoError(err)
+	require.Empty(pending)
+}
+
+func TestOutboxRejected(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "outbox-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	feedback := &recordFeedback{reject: 1}
+	outbox, err := NewOutbox(root, feedback)
+	require.NoError(err)
+
+	require.NoError(outbox.Feedback("node", &Result{ID: "1-1"}))
+	require.NoError(outbox.Deleted("node", "2-1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go outbox.Run(ctx)
+
+	// the rejected message is dropped without blocking the next one
+	require.Eventually(func() bool {
+		return len(feedback.Calls()) == 1
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal([]string{"deleted:2-1"}, feedback.Calls())
+}
+
+func TestOutboxStats(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "outbox-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	outbox, err := NewOutbox(root, &recordFeedback{})
+	require.NoError(err)
+
+	stats := func(used uint16) {
+		require.NoError(outbox.UpdateStats("node", directory.WorkloadAmount{Container: used}, directory.ResourceAmount{}))
+	}
+
+	stats(1)
+	require.NoError(outbox.Feedback("node", &Result{ID: "1-1"}))
+	stats(2)
+
+	// the stats queued before a restart are replaced too
+	outbox, err = NewOutbox(root, &recordFeedback{})
+	require.NoError(err)
+	stats(3)
+
+	pending, err := outbox.pending()
+	require.NoError(err)
+	require.Len(pending, 2)
+
+	msg, err := outbox.get(pending[0])
+	require.NoError(err)
+	require.Equal(outboxResult, msg.Kind)
+
+	msg, err = outbox.get(pending[1])
+	require.NoError(err)
+	require.Equal(outboxStats, msg.Kind)
+	require.EqualValues(3, msg.Workloads.Container)
+}
@@ -0,0 +1,456 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/app"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/provision/primitives"
+	"github.com/threefoldtech/zos/pkg/versioned"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	reservationsBucket = []byte("reservations")
+	typeIndex          = []byte("index-type")
+	userIndex          = []byte("index-user")
+	networkIndex       = []byte("index-network")
+	expiryIndex        = []byte("index-expiry")
+
+	buckets = [][]byte{reservationsBucket, typeIndex, userIndex, networkIndex, expiryIndex}
+)
+
+// indexSep separates the indexed value from the reservation ID
+// in the keys of the index buckets
+const indexSep = 0
+
+// Bolt is a reservation cache using an embedded bolt database as backend.
+// Next to the reservations, the database keeps indexes by type, user,
+// network ID and expiration date so lookups don't need to decode
+// all the reservations
+type Bolt struct {
+	db *bolt.DB
+}
+
+var _ provision.ReservationCache = (*Bolt)(nil)
+
+// NewBoltStore opens the reservation cache stored in the database at path.
+// If legacy is not empty and points to the directory of an Fs store, the
+// reservations it contains are imported in the database and the directory
+// is renamed so the migration only happens once
+func NewBoltStore(path, legacy string) (*Bolt, error) {
+	store, err := openBolt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(legacy) != 0 {
+		if err := store.migrate(legacy); err != nil {
+			store.Close()
+			return nil, errors.Wrapf(err, "failed to migrate reservations from %s", legacy)
+		}
+	}
+
+	if app.IsFirstBoot("provisiond") {
+		log.Info().Msg("first boot, empty reservation cache")
+		if err := store.removeAllButPersistent(); err != nil {
+			store.Close()
+			return nil, err
+		}
+
+		if err := app.MarkBooted("provisiond"); err != nil {
+			store.Close()
+			return nil, errors.Wrap(err, "fail to mark provisiond as booted")
+		}
+	}
+
+	reservations, err := store.List()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	if err := updateReservationResults(reservations, store.put); err != nil {
+		log.Error().Err(err).Msgf("error while updating reservation results")
+	}
+
+	return store, nil
+}
+
+func openBolt(path string) (*Bolt, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0660, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open reservation database %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to initialize reservation database")
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// migrate imports all the reservations of the Fs store located at root
+func (s *Bolt) migrate(root string) error {
+	infos, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Info().Str("root", root).Msg("migrating reservation cache to database")
+
+	legacy := &Fs{root: root}
+	for _, info := range infos {
+		if info.IsDir() || info.Size() == 0 {
+			continue
+		}
+
+		r, err := legacy.get(info.Name())
+		if err != nil {
+			log.Error().Err(err).Str("id", info.Name()).Msg("failed to read cached reservation, skipping")
+			continue
+		}
+
+		if err := s.put(r); err != nil {
+			return errors.Wrapf(err, "failed to import reservation %s", r.ID)
+		}
+	}
+
+	// keep the old cache around for inspection, the Fs
+	// backend refuses to start once it has been migrated
+	return os.Rename(root, migratedPath(root))
+}
+
+// migratedPath returns where the Fs store at root
+// is moved once migrated to the database
+func migratedPath(root string) string {
+	return fmt.Sprintf("%s.migrated", root)
+}
+
+func (s *Bolt) removeAllButPersistent() error {
+	reservations, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reservations {
+		if r.Type == primitives.VolumeReservation {
+			continue
+		}
+
+		log.Info().Msgf("Removing %s from cache", r.ID)
+		if err := s.Remove(r.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Add a reservation to the store
+func (s *Bolt) Add(r *provision.Reservation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(reservationsBucket).Get([]byte(r.ID)) != nil {
+			return fmt.Errorf("reservation %s already in the store", r.ID)
+		}
+
+		return s.set(tx, r)
+	})
+}
+
+// put adds the reservation to the store, replacing
+// the existing one with the same ID
+func (s *Bolt) put(r *provision.Reservation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.unset(tx, r.ID); err != nil {
+			return err
+		}
+
+		return s.set(tx, r)
+	})
+}
+
+func (s *Bolt) set(tx *bolt.Tx, r *provision.Reservation) error {
+	var buf bytes.Buffer
+	writer, err := versioned.NewWriter(&buf, reservationSchemaLastVersion)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(writer).Encode(r); err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(reservationsBucket).Put([]byte(r.ID), buf.Bytes()); err != nil {
+		return err
+	}
+
+	keys, err := indexKeys(r)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range keys {
+		if err := tx.Bucket(entry.bucket).Put(entry.key, []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Bolt) unset(tx *bolt.Tx, id string) error {
+	bucket := tx.Bucket(reservationsBucket)
+	data := bucket.Get([]byte(id))
+	if data == nil {
+		return nil
+	}
+
+	r, err := decode(data)
+	if err != nil {
+		return err
+	}
+
+	keys, err := indexKeys(r)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range keys {
+		if err := tx.Bucket(entry.bucket).Delete(entry.key); err != nil {
+			return err
+		}
+	}
+
+	return bucket.Delete([]byte(id))
+}
+
+// Remove a reservation from the store
+func (s *Bolt) Remove(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.unset(tx, id)
+	})
+}
+
+// Get retrieves a specific reservation using its ID
+// if returns a non nil error if the reservation is not present in the store
+func (s *Bolt) Get(id string) (r *provision.Reservation, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		r, err = s.get(tx, id)
+		return err
+	})
+
+	return r, err
+}
+
+func (s *Bolt) get(tx *bolt.Tx, id string) (*provision.Reservation, error) {
+	data := tx.Bucket(reservationsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, errors.Wrapf(os.ErrNotExist, "reservation %s not found", id)
+	}
+
+	return decode(data)
+}
+
+// Exists checks if the reservation ID is in the store
+func (s *Bolt) Exists(id string) (exists bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(reservationsBucket).Get([]byte(id)) != nil
+		return nil
+	})
+
+	return exists, err
+}
+
+// List returns all the reservations in the store
+func (s *Bolt) List() (reservations []*provision.Reservation, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(reservationsBucket).ForEach(func(k, v []byte) error {
+			r, err := decode(v)
+			if err != nil {
+				return fmt.Errorf("failed get reservation: %w", err)
+			}
+
+			reservations = append(reservations, r)
+			return nil
+		})
+	})
+
+	return reservations, err
+}
+
+// ListByType returns all the reservations of type typ
+func (s *Bolt) ListByType(typ provision.ReservationType) ([]*provision.Reservation, error) {
+	return s.lookup(typeIndex, []byte(typ))
+}
+
+// ListByUser returns all the reservations of user
+func (s *Bolt) ListByUser(user string) ([]*provision.Reservation, error) {
+	return s.lookup(userIndex, []byte(user))
+}
+
+// NetworkExists exists checks if a network exists in cache already. Like
+// the Fs store, only the reservations of type network are matched, network
+// resources are indexed too but are not considered
+func (s *Bolt) NetworkExists(id string) (exists bool, err error) {
+	prefix := indexKey([]byte(id), "")
+	err = s.db.View(func(tx *bolt.Tx) error {
+		types := tx.Bucket(typeIndex).Cursor()
+		cursor := tx.Bucket(networkIndex).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			key := indexKey([]byte(primitives.NetworkReservation), string(k[len(prefix):]))
+			if found, _ := types.Seek(key); bytes.Equal(found, key) {
+				exists = true
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return exists, err
+}
+
+// GetExpired returns all id the the reservations that are expired
+// at the time of the function call
+func (s *Bolt) GetExpired() (reservations []*provision.Reservation, err error) {
+	now := time.Now()
+	err = s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(expiryIndex).Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			if int64(binary.BigEndian.Uint64(k[:8])) > now.Unix() {
+				// the index is sorted, all the reservations
+				// left expire later
+				break
+			}
+
+			r, err := s.get(tx, string(k[9:]))
+			if err != nil {
+				return err
+			}
+
+			if r.Expired() {
+				reservations = append(reservations, r)
+			}
+		}
+
+		return nil
+	})
+
+	return reservations, err
+}
+
+// Sync update the statser with all the reservation present in the cache
+func (s *Bolt) Sync(statser provision.Statser) error {
+	reservations, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	return incrementCounters(statser, reservations)
+}
+
+// Close makes sure the backend of the store is closed properly
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
+
+// lookup returns the reservations that have value in index
+func (s *Bolt) lookup(index, value []byte) (reservations []*provision.Reservation, err error) {
+	prefix := indexKey(value, "")
+	err = s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(index).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			r, err := s.get(tx, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+
+			reservations = append(reservations, r)
+		}
+
+		return nil
+	})
+
+	return reservations, err
+}
+
+func indexKey(value []byte, id string) []byte {
+	key := make([]byte, 0, len(value)+1+len(id))
+	key = append(key, value...)
+	key = append(key, indexSep)
+	return append(key, id...)
+}
+
+type indexEntry struct {
+	bucket []byte
+	key    []byte
+}
+
+// indexKeys returns the keys of reservation r in each of the index buckets
+func indexKeys(r *provision.Reservation) ([]indexEntry, error) {
+	expiry := make([]byte, 8)
+	binary.BigEndian.PutUint64(expiry, uint64(r.Created.Add(r.Duration).Unix()))
+
+	entries := []indexEntry{
+		{bucket: typeIndex, key: indexKey([]byte(r.Type), r.ID)},
+		{bucket: userIndex, key: indexKey([]byte(r.User), r.ID)},
+		{bucket: expiryIndex, key: indexKey(expiry, r.ID)},
+	}
+
+	if r.Type == primitives.NetworkReservation || r.Type == primitives.NetworkResourceReservation {
+		nr := pkg.NetResource{}
+		if err := json.Unmarshal(r.Data, &nr); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal network from reservation: %w", err)
+		}
+
+		netID := provision.NetworkID(r.User, nr.Name)
+		entries = append(entries, indexEntry{bucket: networkIndex, key: indexKey([]byte(netID), r.ID)})
+	}
+
+	return entries, nil
+}
+
+func decode(data []byte) (*provision.Reservation, error) {
+	reader, err := versioned.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	validV1 := versioned.MustParseRange(fmt.Sprintf("<=%s", reservationSchemaV1))
+	if !validV1(reader.Version()) {
+		return nil, fmt.Errorf("unknown reservation object version (%s)", reader.Version())
+	}
+
+	var reservation provision.Reservation
+	if err := json.NewDecoder(reader).Decode(&reservation); err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestBoltStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	s, err := openBolt(filepath.Join(root, "reservations.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	data, err := json.Marshal(pkg.NetResource{Name: "tf_devnet"})
+	require.NoError(t, err)
+
+	network := &provision.Reservation{
+		ID:       "1-1",
+		Created:  time.Now().UTC().Add(-time.Minute).Round(time.Second),
+		Duration: time.Second * 10,
+		Data:     data,
+		Type:     "network",
+		User:     "1",
+	}
+	volume := &provision.Reservation{
+		ID:       "2-1",
+		Created:  time.Now().UTC().Round(time.Second),
+		Duration: time.Hour,
+		Data:     json.RawMessage(`{}`),
+		Type:     "volume",
+		User:     "2",
+	}
+
+	require.NoError(t, s.Add(network))
+	require.NoError(t, s.Add(volume))
+	require.Error(t, s.Add(volume))
+
+	actual, err := s.Get(network.ID)
+	require.NoError(t, err)
+	assert.Equal(t, network.Duration, actual.Duration)
+	assert.Equal(t, network.Created, actual.Created)
+
+	_, err = s.Get("foo")
+	require.Error(t, err)
+
+	exists, err := s.Exists(volume.ID)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	expired, err := s.GetExpired()
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, network.ID, expired[0].ID)
+
+	byType, err := s.ListByType("volume")
+	require.NoError(t, err)
+	require.Len(t, byType, 1)
+	assert.Equal(t, volume.ID, byType[0].ID)
+
+	byUser, err := s.ListByUser("1")
+	require.NoError(t, err)
+	require.Len(t, byUser, 1)
+	assert.Equal(t, network.ID, byUser[0].ID)
+
+	exists, err = s.NetworkExists(string(provision.NetworkID("1", "tf_devnet")))
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = s.NetworkExists(string(provision.NetworkID("1", "tf_mainnet")))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, s.Remove(network.ID))
+
+	exists, err = s.NetworkExists(string(provision.NetworkID("1", "tf_devnet")))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	expired, err = s.GetExpired()
+	require.NoError(t, err)
+	assert.Len(t, expired, 0)
+
+	all, err := s.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestBoltMigrate(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	legacy := filepath.Join(root, "reservations")
+	require.NoError(t, os.MkdirAll(legacy, 0770))
+
+	fs := &Fs{root: legacy}
+	for _, id := range []string{"1-1", "2-1"} {
+		require.NoError(t, fs.Add(&provision.Reservation{
+			ID:       id,
+			Created:  time.Now(),
+			Duration: time.Hour,
+			Data:     json.RawMessage(`{}`),
+			Type:     "volume",
+		}))
+	}
+
+	s, err := openBolt(filepath.Join(root, "reservations.db"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.migrate(legacy))
+
+	all, err := s.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// the old cache is moved away so it's only migrated once
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err))
+	require.NoError(t, s.migrate(legacy))
+
+	// and can't be used by the fs backend anymore
+	_, err = NewFSStore(legacy)
+	require.Error(t, err)
+}
+
+// TestNetworkExistsBackends makes sure both backends agree on which
+// networks exist, the engine relies on it to count the network workloads
+func TestNetworkExistsBackends(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	require.NoError(t, os.Mkdir(filepath.Join(root, "fs"), 0770))
+	bolt, err := openBolt(filepath.Join(root, "reservations.db"))
+	require.NoError(t, err)
+	defer bolt.Close()
+
+	backends := map[string]provision.ReservationCache{
+		"fs":   &Fs{root: filepath.Join(root, "fs")},
+		"bolt": bolt,
+	}
+
+	devnet, err := json.Marshal(pkg.NetResource{Name: "tf_devnet"})
+	require.NoError(t, err)
+	mainnet, err := json.Marshal(pkg.NetResource{Name: "tf_mainnet"})
+	require.NoError(t, err)
+
+	reservations := []*provision.Reservation{
+		{ID: "1-1", Type: "network", User: "1", Data: devnet, Created: time.Now(), Duration: time.Hour},
+		{ID: "2-1", Type: "network_resource", User: "1", Data: mainnet, Created: time.Now(), Duration: time.Hour},
+	}
+
+	for name, s := range backends {
+		t.Run(name, func(t *testing.T) {
+			for _, r := range reservations {
+				require.NoError(t, s.Add(r))
+			}
+
+			exists, err := s.NetworkExists(string(provision.NetworkID("1", "tf_devnet")))
+			require.NoError(t, err)
+			assert.True(t, exists)
+
+			exists, err = s.NetworkExists(string(provision.NetworkID("1", "tf_mainnet")))
+			require.NoError(t, err)
+			assert.False(t, exists, "network resources are not matched")
+
+			exists, err = s.NetworkExists(string(provision.NetworkID("2", "tf_devnet")))
+			require.NoError(t, err)
+			assert.False(t, exists)
+
+			require.NoError(t, s.Remove("1-1"))
+			exists, err = s.NetworkExists(string(provision.NetworkID("1", "tf_devnet")))
+			require.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
@@ -31,8 +31,14 @@ type Fs struct {
 	root string
 }
 
-// NewFSStore creates a in memory reservation store
+// NewFSStore creates a in memory reservation store. It refuses to start if the
+// store was migrated to the Bolt backend, since the reservations deployed after
+// the migration are only known to the database
 func NewFSStore(root string) (*Fs, error) {
+	if _, err := os.Stat(migratedPath(root)); err == nil {
+		return nil, fmt.Errorf("reservation cache %s was migrated to the bolt backend, it can't be used with the fs backend anymore", root)
+	}
+
 	store := &Fs{
 		root: root,
 	}
@@ -53,7 +59,7 @@ func NewFSStore(root string) (*Fs, error) {
 
 	log.Info().Msg("restart detected, keep reservation cache intact")
 
-	if err := store.updateReservationResults(root); err != nil {
+	if err := store.updateReservationResults(); err != nil {
 		log.Error().Err(err).Msgf("error while updating reservation results")
 		return store, nil
 	}
@@ -62,13 +68,22 @@ func NewFSStore(root string) (*Fs, error) {
 }
 
 // Updates reservation results for reservations in cache that don't have a result set.
-func (s *Fs) updateReservationResults(rootPath string) error {
-	log.Info().Msg("updating reservation results")
+func (s *Fs) updateReservationResults() error {
 	reservations, err := s.list()
 	if err != nil {
 		return err
 	}
 
+	return updateReservationResults(reservations, func(r *provision.Reservation) error {
+		return s.add(r, true)
+	})
+}
+
+// updateReservationResults fetches the result of the reservations
+// that don't have one from the explorer and saves them with save
+func updateReservationResults(reservations []*provision.Reservation, save func(*provision.Reservation) error) error {
+	log.Info().Msg("updating reservation results")
+
 	client, err := app.ExplorerClient()
 	if err != nil {
 		return err
@@ -98,7 +113,7 @@ func (s *Fs) updateReservationResults(rootPath string) error {
 			Signature: provisionResult.Signature,
 		}
 
-		err = s.add(reservation, true)
+		err = save(reservation)
 		if err != nil {
 			log.Error().Err(err).Msg("error while updating reservation in cache")
 			continue
@@ -154,7 +169,12 @@ func (s *Fs) Sync(statser provision.Statser) error {
 	s.RLock()
 	defer s.RUnlock()
 
-	return s.incrementCounters(statser)
+	reservations, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	return incrementCounters(statser, reservations)
 }
 
 // Add a reservation to the store
@@ -265,7 +285,7 @@ func (s *Fs) Get(id string) (*provision.Reservation, error) {
 func (s *Fs) getType(id string) (provision.ReservationType, error) {
 	r, err := s.get(id)
 	if err != nil {
-		return provision.ReservationType(0), err
+		return provision.ReservationType(""), err
 	}
 	return r.Type, nil
 }
@@ -286,6 +306,11 @@ func (s *Fs) Exists(id string) (bool, error) {
 	return false, err
 }
 
+// List returns all the reservations in the store
+func (s *Fs) List() ([]*provision.Reservation, error) {
+	return s.list()
+}
+
 // NetworkExists exists checks if a network exists in cache already
 func (s *Fs) NetworkExists(id string) (bool, error) {
 	reservations, err := s.list()
@@ -337,14 +362,9 @@ func (s *Fs) list() ([]*provision.Reservation, error) {
 
 // incrementCounters will increment counters for all workloads
 // for network workloads it will only increment those that have a unique name
-func (s *Fs) incrementCounters(statser provision.Statser) error {
+func incrementCounters(statser provision.Statser, reservations []*provision.Reservation) error {
 	uniqueNetworkReservations := make(map[pkg.NetID]*provision.Reservation)
 
-	reservations, err := s.list()
-	if err != nil {
-		return err
-	}
-
 	for _, r := range reservations {
 		if r.Expired() || r.Result.State != provision.StateOk {
 			continue
@@ -0,0 +1,183 @@
+package primitives
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/zbus"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/capacity"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+// minimumZosMemory is the amount of memory kept for the system itself
+const minimumZosMemory = 2 * gib
+
+// CapacityChecker implements provision.CapacityChecker. It compares the
+// resource units requested by a reservation with the total capacity of the
+// node minus what is already reserved according to the counters
+type CapacityChecker struct {
+	counters *Counters
+
+	total        func() (*capacity.Capacity, error)
+	cache        func() (pkg.Filesystem, error)
+	ipv4         func() bool
+	reservations func() ([]*provision.Reservation, error)
+}
+
+// NewCapacityChecker creates a capacity checker that uses the resource
+// oracle to get the total capacity of the node. The reservations in cache
+// are used to refuse public ips that are already reserved
+func NewCapacityChecker(counters *Counters, cache provision.ReservationCache, client zbus.Client) *CapacityChecker {
+	storage := stubs.NewStorageModuleStub(client)
+	network := stubs.NewNetworkerStub(client)
+
+	return &CapacityChecker{
+		counters:     counters,
+		total:        capacity.NewResourceOracle(storage).Total,
+		cache:        storage.GetCacheFS,
+		ipv4:         network.PublicIPv4Support,
+		reservations: cache.List,
+	}
+}
+
+// Check implements provision.CapacityChecker
+func (c *CapacityChecker) Check(r *provision.Reservation) (pkg.Admission, error) {
+	var admission pkg.Admission
+
+	u, err := c.counters.reservationUnits(r)
+	if err != nil {
+		return admission, errors.Wrap(err, "failed to compute reservation resource units")
+	}
+
+	admission.Requested = pkg.ResourceUnits{
+		CRU: u.CRU,
+		MRU: u.MRU,
+		SRU: u.SRU,
+		HRU: u.HRU,
+	}
+
+	if r.Type == PublicIPReservation {
+		admission.Requested.IPV4U = 1
+	}
+
+	total, err := c.total()
+	if err != nil {
+		return admission, errors.Wrap(err, "failed to get total capacity of the node")
+	}
+
+	// the oracle reports memory and storage in GiB
+	available := pkg.ResourceUnits{
+		CRU: total.CRU,
+		MRU: sub(total.MRU*gib, minimumZosMemory),
+		SRU: total.SRU * gib,
+		HRU: total.HRU * gib,
+	}
+
+	// the cache of the node is not counted as reserved
+	// but lives in the same pools as the workloads
+	cache, err := c.cache()
+	if err != nil {
+		return admission, errors.Wrap(err, "failed to get node cache filesystem")
+	}
+
+	switch cache.DiskType {
+	case pkg.SSDDevice:
+		available.SRU = sub(available.SRU, cache.Usage.Size)
+	case pkg.HDDDevice:
+		available.HRU = sub(available.HRU, cache.Usage.Size)
+	}
+
+	available.CRU = sub(available.CRU, c.counters.CRU.Current())
+	available.MRU = sub(available.MRU, c.counters.MRU.Current())
+	available.SRU = sub(available.SRU, c.counters.SRU.Current())
+	available.HRU = sub(available.HRU, c.counters.HRU.Current())
+
+	admission.Available = available
+	admission.PublicIPv4 = c.ipv4()
+
+	if admission.Requested.CRU > available.CRU {
+		admission.Missing = append(admission.Missing, "cru")
+	}
+	if admission.Requested.MRU > available.MRU {
+		admission.Missing = append(admission.Missing, "mru")
+	}
+	if admission.Requested.SRU > available.SRU {
+		admission.Missing = append(admission.Missing, "sru")
+	}
+	if admission.Requested.HRU > available.HRU {
+		admission.Missing = append(admission.Missing, "hru")
+	}
+	if admission.Requested.IPV4U > 0 {
+		reserved := false
+		if admission.PublicIPv4 {
+			if reserved, err = c.publicIPReserved(r); err != nil {
+				return admission, err
+			}
+		}
+
+		if !admission.PublicIPv4 || reserved {
+			admission.Missing = append(admission.Missing, "ipv4u")
+		}
+	}
+
+	return admission, nil
+}
+
+// publicIPReserved returns true if the ip requested by the public ip
+// reservation r is already reserved by another reservation
+func (c *CapacityChecker) publicIPReserved(r *provision.Reservation) (bool, error) {
+	var config PublicIP
+	if err := json.Unmarshal(r.Data, &config); err != nil {
+		return false, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	reservations, err := c.reservations()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list cached reservations")
+	}
+
+	for _, cached := range reservations {
+		// renewals and updates keep their ip
+		if cached.Type != PublicIPReservation || cached.ID == r.ID || cached.ID == r.Reference {
+			continue
+		}
+
+		var used PublicIP
+		if err := json.Unmarshal(cached.Data, &used); err != nil {
+			return false, errors.Wrapf(err, "failed to decode public ip reservation %s", cached.ID)
+		}
+
+		if used.IP.IP.Equal(config.IP.IP) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// reservationUnits returns the resource units used by a reservation
+func (c *Counters) reservationUnits(r *provision.Reservation) (resourceUnits, error) {
+	switch r.Type {
+	case VolumeReservation:
+		return processVolume(r)
+	case ContainerReservation, JobReservation:
+		return processContainer(r)
+	case ZDBReservation:
+		return processZdb(r)
+	case KubernetesReservation:
+		return processKubernetes(r)
+	case VirtualMachineReservation:
+		return processVirtualMachine(r)
+	}
+
+	return c.externalUnits(r)
+}
+
+func sub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,119 @@
+package primitives
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/capacity"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestCapacityChecker(t *testing.T) {
+	counters := &Counters{}
+	checker := &CapacityChecker{
+		counters: counters,
+		total: func() (*capacity.Capacity, error) {
+			return &capacity.Capacity{CRU: 4, MRU: 10, SRU: 100, HRU: 0}, nil
+		},
+		cache: func() (pkg.Filesystem, error) {
+			return pkg.Filesystem{
+				DiskType: pkg.SSDDevice,
+				Usage:    pkg.Usage{Size: 10 * gib},
+			}, nil
+		},
+		ipv4: func() bool { return false },
+	}
+
+	mustMarshal := func(v interface{}) json.RawMessage {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return b
+	}
+
+	volume := func(size uint64, typ pkg.DeviceType) *provision.Reservation {
+		return &provision.Reservation{
+			Type: VolumeReservation,
+			Data: mustMarshal(Volume{Size: size, Type: typ}),
+		}
+	}
+
+	t.Run("fits", func(t *testing.T) {
+		admission, err := checker.Check(volume(50, pkg.SSDDevice))
+		require.NoError(t, err)
+		assert.True(t, admission.Admitted())
+		assert.Equal(t, 50*gib, admission.Requested.SRU)
+		assert.Equal(t, 90*gib, admission.Available.SRU)
+		assert.Equal(t, 8*gib, admission.Available.MRU)
+	})
+
+	t.Run("no hdd", func(t *testing.T) {
+		admission, err := checker.Check(volume(1, pkg.HDDDevice))
+		require.NoError(t, err)
+		assert.False(t, admission.Admitted())
+		assert.Equal(t, []string{"hru"}, admission.Missing)
+	})
+
+	t.Run("reserved", func(t *testing.T) {
+		counters.SRU.Increment(60 * gib)
+		defer counters.SRU.Decrement(60 * gib)
+
+		admission, err := checker.Check(volume(50, pkg.SSDDevice))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"sru"}, admission.Missing)
+
+		err = pkg.ErrInsufficientCapacity{Admission: admission}
+		assert.Equal(t, "insufficient capacity: not enough sru available", err.Error())
+	})
+
+	t.Run("container", func(t *testing.T) {
+		admission, err := checker.Check(&provision.Reservation{
+			Type: ContainerReservation,
+			Data: mustMarshal(Container{
+				Capacity: ContainerCapacity{CPU: 8, Memory: 1024},
+			}),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cru"}, admission.Missing)
+	})
+
+	t.Run("public ip", func(t *testing.T) {
+		admission, err := checker.Check(&provision.Reservation{
+			Type: PublicIPReservation,
+			Data: mustMarshal(PublicIP{}),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ipv4u"}, admission.Missing)
+	})
+
+	t.Run("public ip reserved", func(t *testing.T) {
+		publicIP := func(id, ip string) *provision.Reservation {
+			return &provision.Reservation{
+				ID:   id,
+				Type: PublicIPReservation,
+				Data: mustMarshal(PublicIP{IP: net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(24, 32)}}),
+			}
+		}
+
+		checker.ipv4 = func() bool { return true }
+		checker.reservations = func() ([]*provision.Reservation, error) {
+			return []*provision.Reservation{publicIP("1-1", "185.69.166.10")}, nil
+		}
+
+		admission, err := checker.Check(publicIP("2-1", "185.69.166.11"))
+		require.NoError(t, err)
+		assert.True(t, admission.Admitted())
+
+		admission, err = checker.Check(publicIP("2-1", "185.69.166.10"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ipv4u"}, admission.Missing)
+
+		// a renewal keeps its ip
+		admission, err = checker.Check(publicIP("1-1", "185.69.166.10"))
+		require.NoError(t, err)
+		assert.True(t, admission.Admitted())
+	})
+}
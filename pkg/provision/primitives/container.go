@@ -7,6 +7,7 @@ import (
 	"net"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -35,13 +36,15 @@ type Mount struct {
 	Mountpoint string `json:"mountpoint"`
 }
 
-// Logs defines a custom backend with variable settings
+// Logs defines a custom backend with variable settings. Data depends on
+// the type of the backend: LogsData for redis, LogsFile for file and
+// LogsConsole for console
 type Logs struct {
-	Type string   `json:"type"`
-	Data LogsData `json:"data"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
 }
 
-// LogsData structure
+// LogsData structure of a redis logs backend
 type LogsData struct {
 	// Stdout is the redis url for stdout (redis://host/channel)
 	Stdout string `json:"stdout"`
@@ -56,6 +59,41 @@ type LogsData struct {
 	SecretStderr string `json:"secret_stderr"`
 }
 
+// LogsFile structure of a file logs backend. The logs are rotated when
+// the file reaches max_size, and the last max_files rotated files are kept
+type LogsFile struct {
+	// VolumeID of a volume mounted in the container where the logs are
+	// written. If not set, the logs are kept in the node cache and the
+	// size of the files is limited
+	VolumeID string `json:"volume_id,omitempty"`
+	// Path of the logs file inside the volume, default to logs/container.log
+	Path string `json:"path,omitempty"`
+	// MaxSize of the logs file in bytes, default to 10MiB
+	MaxSize uint64 `json:"max_size,omitempty"`
+	// MaxFiles is the number of rotated files kept, default to 5
+	MaxFiles uint `json:"max_files,omitempty"`
+}
+
+// LogsConsole structure of a console logs backend. The last logs of
+// the container are kept in memory and can be read by the owner of the
+// reservation. Every container has a console of the default size if
+// none is configured
+type LogsConsole struct {
+	// Size of the console in bytes, default to 64KiB
+	Size uint64 `json:"size,omitempty"`
+}
+
+const (
+	// maxConsoleSize is the maximum size of a console
+	maxConsoleSize = mib
+	// maxCacheLogsSize and maxCacheLogsFiles limit the
+	// logs files kept in the node cache
+	maxCacheLogsSize  = 10 * mib
+	maxCacheLogsFiles = 5
+	// defaultVolumeLogsPath is the path of the logs file in a volume
+	defaultVolumeLogsPath = "logs/container.log"
+)
+
 //Container creation info
 type Container struct {
 	// URL of the flist
@@ -76,12 +114,81 @@ type Container struct {
 	Mounts []Mount `json:"mounts"`
 	// Network network info for container
 	Network Network `json:"network"`
+	// Networks extra networks the container joins, through the
+	// interfaces eth1, eth2, ... in this order. Only the ip range of
+	// these networks is routed through them, the default routes stay
+	// on the first network. Public IPv6 and yggdrasil are not supported
+	// on extra networks
+	Networks []Network `json:"networks,omitempty"`
 	// ContainerCapacity is the amount of resource to allocate to the container
 	Capacity ContainerCapacity `json:"capacity"`
 	// Logs contains a list of endpoint where to send containerlogs
 	Logs []Logs `json:"logs,omitempty"`
 	// Stats container metrics backend
 	Stats []stats.Stats `json:"stats,omitempty"`
+	// HealthCheck optional probe of the container health
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	// RestartPolicy defines when the container is restarted, restart
+	// on exit up to 3 times in a row if not set
+	RestartPolicy *RestartPolicy `json:"restart_policy,omitempty"`
+}
+
+// RestartPolicy defines when a container is restarted after its entrypoint
+// exits. Restarts are delayed with an exponential backoff
+type RestartPolicy struct {
+	// Policy is one of never, on-exit, on-failure or always
+	Policy pkg.RestartMode `json:"policy"`
+	// MaxRetries is the number of restarts in a row after which a failing
+	// container is decommissioned. Only used by on-exit and on-failure,
+	// default to 3
+	MaxRetries uint `json:"max_retries,omitempty"`
+}
+
+// toRestartPolicy converts the reservation restart policy to the container module type
+func (r *RestartPolicy) toRestartPolicy() pkg.RestartPolicy {
+	if r == nil {
+		return pkg.RestartPolicy{}
+	}
+
+	return pkg.RestartPolicy{
+		Mode:       r.Policy,
+		MaxRetries: r.MaxRetries,
+	}
+}
+
+// HealthCheck defines how the health of a container is probed. Exactly one
+// of exec, tcp or http must be set. An unhealthy container is restarted, and
+// its reservation is decommissioned if it stays unhealthy
+type HealthCheck struct {
+	// Exec command run inside the container, healthy if it exits with 0
+	Exec string `json:"exec,omitempty"`
+	// TCP address (host:port) to connect to from the container network namespace
+	TCP string `json:"tcp,omitempty"`
+	// HTTP url to get from the container network namespace, healthy on 2xx or 3xx
+	HTTP string `json:"http,omitempty"`
+	// Interval between two probes in seconds, default to 10
+	Interval uint `json:"interval"`
+	// Timeout of a probe in seconds, default to 5
+	Timeout uint `json:"timeout"`
+	// Threshold is the number of consecutive failed probes
+	// before the container is restarted, default to 3
+	Threshold uint `json:"threshold"`
+}
+
+// toHealthCheck converts the reservation health check to the container module type
+func (h *HealthCheck) toHealthCheck() *pkg.HealthCheck {
+	if h == nil {
+		return nil
+	}
+
+	return &pkg.HealthCheck{
+		Exec:      h.Exec,
+		TCP:       h.TCP,
+		HTTP:      h.HTTP,
+		Interval:  time.Duration(h.Interval) * time.Second,
+		Timeout:   time.Duration(h.Timeout) * time.Second,
+		Threshold: h.Threshold,
+	}
 }
 
 // ContainerResult is the information return to the BCDB
@@ -91,6 +198,17 @@ type ContainerResult struct {
 	IPv6  string `json:"ipv6"`
 	IPv4  string `json:"ipv4"`
 	IPYgg string `json:"yggdrasil"`
+	// Networks lists all the networks the container joined, the
+	// first network included
+	Networks []ContainerNetworkResult `json:"networks,omitempty"`
+}
+
+// ContainerNetworkResult is the address of a container in one of its networks
+type ContainerNetworkResult struct {
+	NetworkID pkg.NetID `json:"network_id"`
+	Interface string    `json:"interface"`
+	IPv4      string    `json:"ipv4"`
+	IPv6      string    `json:"ipv6"`
 }
 
 // ContainerCapacity is the amount of resource to allocate to the container
@@ -103,6 +221,17 @@ type ContainerCapacity struct {
 	DiskType pkg.DeviceType `json:"disk_type"`
 	// DiskSize of the root fs in MiB
 	DiskSize uint64 `json:"disk_size"`
+	// ReadBps and WriteBps limit the disk bandwidth of the container in bytes per second
+	ReadBps  uint64 `json:"read_bps,omitempty"`
+	WriteBps uint64 `json:"write_bps,omitempty"`
+	// ReadIOPS and WriteIOPS limit the disk operations of the container per second
+	ReadIOPS  uint64 `json:"read_iops,omitempty"`
+	WriteIOPS uint64 `json:"write_iops,omitempty"`
+	// Pids is the maximum number of processes in the container
+	Pids int64 `json:"pids,omitempty"`
+	// Swap in MiB the container can use on top of its memory. 0 disables
+	// swap, if not set the swap is not limited
+	Swap *uint64 `json:"swap,omitempty"`
 }
 
 func (p *Provisioner) containerProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
@@ -139,6 +268,13 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		return ContainerResult{}, errors.Wrap(err, "container provision schema not valid")
 	}
 
+	job := reservation.Type == JobReservation
+	if job {
+		if err := validateJobConfig(config); err != nil {
+			return ContainerResult{}, errors.Wrap(err, "job provision schema not valid")
+		}
+	}
+
 	netID := provision.NetworkID(reservation.User, string(config.Network.NetworkID))
 	log.Debug().
 		Str("network-id", string(netID)).
@@ -150,6 +286,12 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		return ContainerResult{}, fmt.Errorf("network %s is not installed on this node", config.Network.NetworkID)
 	}
 
+	for _, network := range config.Networks {
+		if _, err := networkMgr.GetSubnet(provision.NetworkID(reservation.User, string(network.NetworkID))); err != nil {
+			return ContainerResult{}, fmt.Errorf("network %s is not installed on this node", network.NetworkID)
+		}
+	}
+
 	// check to make sure the requested volume are accessible
 	for _, mount := range config.Mounts {
 		volumeRes, err := p.cache.Get(mount.VolumeID)
@@ -169,38 +311,16 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 	}
 
 	for k, v := range config.SecretEnv {
-		v, err := decryptSecret(v, reservation.User, reservation.Version, p.zbus)
+		v, err := p.decryptSecret(v, reservation.User, reservation.Version)
 		if err != nil {
 			return ContainerResult{}, errors.Wrapf(err, "failed to decrypt secret env var '%s'", k)
 		}
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	var logs []logger.Logs
-	for _, log := range config.Logs {
-		stdout := log.Data.Stdout
-		stderr := log.Data.Stderr
-
-		if len(log.Data.SecretStdout) > 0 {
-			stdout, err = decryptSecret(log.Data.SecretStdout, reservation.User, reservation.Version, p.zbus)
-			if err != nil {
-				return ContainerResult{}, errors.Wrap(err, "failed to decrypt log.secret_stdout var")
-			}
-		}
-
-		if len(log.Data.SecretStderr) > 0 {
-			stderr, err = decryptSecret(log.Data.SecretStderr, reservation.User, reservation.Version, p.zbus)
-			if err != nil {
-				return ContainerResult{}, errors.Wrap(err, "failed to decrypt log.secret_stdout var")
-			}
-		}
-		logs = append(logs, logger.Logs{
-			Type: log.Type,
-			Data: logger.LogsRedis{
-				Stdout: stdout,
-				Stderr: stderr,
-			},
-		})
+	logs, err := p.containerLogs(reservation, config)
+	if err != nil {
+		return ContainerResult{}, err
 	}
 
 	// prepare container network
@@ -232,6 +352,57 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		}
 	}()
 
+	networks := []ContainerNetworkResult{
+		{
+			NetworkID: config.Network.NetworkID,
+			Interface: "eth0",
+			IPv4:      join.IPv4.String(),
+		},
+	}
+
+	// join the extra networks, the namespace of the container
+	// is the one created by the first join
+	for i, network := range config.Networks {
+		extraID := provision.NetworkID(reservation.User, string(network.NetworkID))
+		iface := fmt.Sprintf("eth%d", i+1)
+
+		ips := make([]string, len(network.IPs))
+		for i, ip := range network.IPs {
+			ips[i] = ip.String()
+		}
+
+		var extra pkg.Member
+		extra, err = networkMgr.Join(extraID, containerID, pkg.ContainerNetworkConfig{
+			IPs:       ips,
+			Interface: iface,
+		})
+		if err != nil {
+			return ContainerResult{}, errors.Wrapf(err, "failed to join network %s", network.NetworkID)
+		}
+
+		defer func() {
+			if err != nil {
+				if err := networkMgr.Leave(extraID, containerID); err != nil {
+					log.Error().Err(err).Str("interface", iface).Msgf("failed leave container network")
+				}
+			}
+		}()
+
+		log.Info().
+			Str("ipv6", extra.IPv6.String()).
+			Str("ipv4", extra.IPv4.String()).
+			Str("interface", iface).
+			Str("container", reservation.ID).
+			Msg("assigned an IP")
+
+		networks = append(networks, ContainerNetworkResult{
+			NetworkID: network.NetworkID,
+			Interface: iface,
+			IPv4:      extra.IPv4.String(),
+			IPv6:      extra.IPv6.String(),
+		})
+	}
+
 	// mount root flist
 	log.Debug().Str("flist", config.FList).Msg("mounting flist")
 	rootfsMntOpt := pkg.MountOptions{
@@ -246,7 +417,7 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 	var mnt string
 	mnt, err = flistClient.NamedMount(provision.FilesystemName(*reservation), config.FList, config.FlistStorage, rootfsMntOpt)
 	if err != nil {
-		return ContainerResult{}, err
+		return ContainerResult{}, flistError(err)
 	}
 
 	// prepare mount info for volumes
@@ -285,6 +456,12 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		}
 	}()
 
+	var swap *uint64
+	if config.Capacity.Swap != nil {
+		bytes := *config.Capacity.Swap * mib
+		swap = &bytes
+	}
+
 	var id pkg.ContainerID
 	id, err = containerClient.Run(
 		tenantNS,
@@ -300,8 +477,19 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 			Interactive: config.Interactive,
 			CPU:         config.Capacity.CPU,
 			Memory:      config.Capacity.Memory * mib,
+			IO: pkg.IOLimits{
+				ReadBps:   config.Capacity.ReadBps,
+				WriteBps:  config.Capacity.WriteBps,
+				ReadIOPS:  config.Capacity.ReadIOPS,
+				WriteIOPS: config.Capacity.WriteIOPS,
+			},
+			Pids:        config.Capacity.Pids,
+			Swap:        swap,
 			Logs:        logs,
 			Stats:       config.Stats,
+			Job:         job,
+			HealthCheck: config.HealthCheck.toHealthCheck(),
+			Restart:     config.RestartPolicy.toRestartPolicy(),
 		},
 	)
 	if err != nil {
@@ -318,16 +506,106 @@ func (p *Provisioner) containerProvisionImpl(ctx context.Context, reservation *p
 		}
 		join.IPv6 = ip
 	}
+	networks[0].IPv6 = join.IPv6.String()
 
 	log.Info().Msgf("container created with id: '%s'", id)
 	return ContainerResult{
-		ID:    string(id),
-		IPv6:  join.IPv6.String(),
-		IPv4:  join.IPv4.String(),
-		IPYgg: join.YggdrasilIP.String(),
+		ID:       string(id),
+		IPv6:     join.IPv6.String(),
+		IPv4:     join.IPv4.String(),
+		IPYgg:    join.YggdrasilIP.String(),
+		Networks: networks,
 	}, nil
 }
 
+// containerLogs prepares the logs backends of the container
+func (p *Provisioner) containerLogs(reservation *provision.Reservation, config Container) ([]logger.Logs, error) {
+	storageClient := stubs.NewStorageModuleStub(p.zbus)
+
+	var logs []logger.Logs
+	for _, l := range config.Logs {
+		var (
+			backend logger.Logs
+			err     error
+		)
+
+		switch l.Type {
+		case logger.RedisType:
+			var data LogsData
+			if err := json.Unmarshal(l.Data, &data); err != nil {
+				return nil, errors.Wrap(err, "invalid redis logs backend")
+			}
+
+			stdout := data.Stdout
+			stderr := data.Stderr
+
+			if len(data.SecretStdout) > 0 {
+				stdout, err = p.decryptSecret(data.SecretStdout, reservation.User, reservation.Version)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to decrypt log.secret_stdout var")
+				}
+			}
+
+			if len(data.SecretStderr) > 0 {
+				stderr, err = p.decryptSecret(data.SecretStderr, reservation.User, reservation.Version)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to decrypt log.secret_stderr var")
+				}
+			}
+
+			backend, err = logger.New(l.Type, logger.LogsRedis{
+				Stdout: stdout,
+				Stderr: stderr,
+			})
+		case logger.FileType:
+			var data LogsFile
+			if err := json.Unmarshal(l.Data, &data); err != nil {
+				return nil, errors.Wrap(err, "invalid file logs backend")
+			}
+
+			file := logger.LogsFile{
+				MaxSize:  data.MaxSize,
+				MaxFiles: data.MaxFiles,
+			}
+
+			// without a volume, contd keeps the logs in its cache
+			if data.VolumeID != "" {
+				volume, err := storageClient.Path(data.VolumeID)
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to get the mountpoint path of the volume %s", data.VolumeID)
+				}
+
+				logsPath := data.Path
+				if logsPath == "" {
+					logsPath = defaultVolumeLogsPath
+				}
+				// the container can write to the volume, so the path
+				// is resolved by zlogs without following symlinks
+				file.Root = volume.Path
+				file.Path = strings.TrimPrefix(path.Join("/", logsPath), "/")
+			}
+
+			backend, err = logger.New(l.Type, file)
+		case logger.ConsoleType:
+			var data LogsConsole
+			if err := json.Unmarshal(l.Data, &data); err != nil {
+				return nil, errors.Wrap(err, "invalid console logs backend")
+			}
+
+			backend, err = logger.New(l.Type, logger.LogsConsole{Size: data.Size})
+		default:
+			return nil, fmt.Errorf("unsupported logs backend '%s'", l.Type)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, backend)
+	}
+
+	return logs, nil
+}
+
 func (p *Provisioner) containerDecommission(ctx context.Context, reservation *provision.Reservation) error {
 	container := stubs.NewContainerModuleStub(p.zbus)
 	flist := stubs.NewFlisterStub(p.zbus)
@@ -363,6 +641,17 @@ func (p *Provisioner) containerDecommission(ctx context.Context, reservation *pr
 		log.Error().Err(err).Str("container", string(containerID)).Msg("failed to inspect container for decomission")
 	}
 
+	// leave the extra networks first, leaving the first network
+	// deletes the namespace of the container
+	for _, network := range config.Networks {
+		netID := provision.NetworkID(reservation.User, string(network.NetworkID))
+		if _, err := networkMgr.GetSubnet(netID); err == nil {
+			if err := networkMgr.Leave(netID, string(containerID)); err != nil {
+				return errors.Wrapf(err, "failed to leave container network %s", network.NetworkID)
+			}
+		}
+	}
+
 	netID := provision.NetworkID(reservation.User, string(config.Network.NetworkID))
 	if _, err := networkMgr.GetSubnet(netID); err == nil { // simple check to make sure the network still exists on the node
 		if err := networkMgr.Leave(netID, string(containerID)); err != nil {
@@ -421,6 +710,39 @@ func validateContainerConfig(config Container) error {
 		return fmt.Errorf("missing container IP address")
 	}
 
+	// the networks of a node are /24 subnets, two networks with
+	// ips in the same /24 would route the same addresses
+	subnets := make(map[string]pkg.NetID)
+	for _, network := range append([]Network{config.Network}, config.Networks...) {
+		for _, ip := range network.IPs {
+			subnet := ip.Mask(net.CIDRMask(24, 32)).String()
+			if other, ok := subnets[subnet]; ok && other != network.NetworkID {
+				return fmt.Errorf("networks %s and %s have overlapping subnets", other, network.NetworkID)
+			}
+			subnets[subnet] = network.NetworkID
+		}
+	}
+
+	joined := map[pkg.NetID]struct{}{config.Network.NetworkID: {}}
+	for _, network := range config.Networks {
+		if network.NetworkID == "" {
+			return fmt.Errorf("network ID cannot be empty")
+		}
+
+		if _, ok := joined[network.NetworkID]; ok {
+			return fmt.Errorf("container cannot join network %s more than once", network.NetworkID)
+		}
+		joined[network.NetworkID] = struct{}{}
+
+		if len(network.IPs) == 0 {
+			return fmt.Errorf("missing container IP address in network %s", network.NetworkID)
+		}
+
+		if network.PublicIP6 || network.YggdrasilIP {
+			return fmt.Errorf("public IPv6 and yggdrasil are only available on the first network")
+		}
+	}
+
 	if config.FList == "" {
 		return fmt.Errorf("missing flist url")
 	}
@@ -433,6 +755,121 @@ func validateContainerConfig(config Container) error {
 		return fmt.Errorf("cannot create a container with 0 CPU allocated")
 	}
 
+	if config.Capacity.Pids < 0 {
+		return fmt.Errorf("pids limit cannot be negative")
+	}
+
+	if check := config.HealthCheck; check != nil {
+		probes := 0
+		for _, probe := range []string{check.Exec, check.TCP, check.HTTP} {
+			if len(probe) != 0 {
+				probes++
+			}
+		}
+
+		if probes != 1 {
+			return fmt.Errorf("health check must define exactly one of exec, tcp or http")
+		}
+	}
+
+	if err := validateLogs(config); err != nil {
+		return err
+	}
+
+	if policy := config.RestartPolicy; policy != nil {
+		switch policy.Policy {
+		case pkg.RestartNever, pkg.RestartAlways:
+			if policy.MaxRetries != 0 {
+				return fmt.Errorf("max retries is only supported by the on-exit and on-failure restart policies")
+			}
+		case pkg.RestartOnExit, pkg.RestartOnFailure:
+		default:
+			return fmt.Errorf("invalid restart policy '%s'", policy.Policy)
+		}
+	}
+
+	return nil
+}
+
+// validateJobConfig checks the options a job does not support
+func validateJobConfig(config Container) error {
+	if config.Interactive {
+		return fmt.Errorf("a job cannot be interactive")
+	}
+
+	if len(config.Logs) != 0 {
+		return fmt.Errorf("a job cannot use logs backends, its output is returned in the result")
+	}
+
+	if config.RestartPolicy != nil {
+		return fmt.Errorf("a job is never restarted, it cannot have a restart policy")
+	}
+
+	return nil
+}
+
+// validateLogs checks the logs backends of the container
+func validateLogs(config Container) error {
+	volumes := make(map[string]struct{})
+	for _, mount := range config.Mounts {
+		volumes[mount.VolumeID] = struct{}{}
+	}
+
+	consoles := 0
+	files := make(map[string]struct{})
+	for _, l := range config.Logs {
+		switch l.Type {
+		case logger.RedisType:
+		case logger.FileType:
+			var data LogsFile
+			if err := json.Unmarshal(l.Data, &data); err != nil {
+				return errors.Wrap(err, "invalid file logs backend")
+			}
+
+			// backends writing the same file would rotate it under each other
+			file := data.VolumeID + ":" + path.Join("/", data.Path)
+			if _, ok := files[file]; ok {
+				return fmt.Errorf("several file logs backends write the same file")
+			}
+			files[file] = struct{}{}
+
+			if data.VolumeID != "" {
+				if _, ok := volumes[data.VolumeID]; !ok {
+					return fmt.Errorf("logs volume %s is not mounted in the container", data.VolumeID)
+				}
+
+				if data.Path != "" && path.Join("/", data.Path) == "/" {
+					return fmt.Errorf("invalid logs file path '%s'", data.Path)
+				}
+				continue
+			}
+
+			if data.Path != "" {
+				return fmt.Errorf("the path of a logs file can only be set on a volume")
+			}
+
+			if data.MaxSize > maxCacheLogsSize || data.MaxFiles > maxCacheLogsFiles {
+				return fmt.Errorf("logs files kept on the node are limited to %d files of %d bytes", maxCacheLogsFiles, maxCacheLogsSize)
+			}
+		case logger.ConsoleType:
+			var data LogsConsole
+			if err := json.Unmarshal(l.Data, &data); err != nil {
+				return errors.Wrap(err, "invalid console logs backend")
+			}
+
+			if data.Size > maxConsoleSize {
+				return fmt.Errorf("console size cannot be bigger than %d bytes", maxConsoleSize)
+			}
+
+			consoles++
+			if consoles > 1 {
+				return fmt.Errorf("a container can only have one console")
+			}
+		default:
+			return fmt.Errorf("unsupported logs backend '%s'", l.Type)
+		}
+	}
+
 	return nil
 }
 
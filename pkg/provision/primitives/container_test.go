@@ -0,0 +1,121 @@
+package primitives
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestValidateContainerNetworks(t *testing.T) {
+	container := Container{
+		FList: "https://hub.grid.tf/tf-official-apps/ubuntu-bionic-build.flist",
+		Network: Network{
+			NetworkID: "net1",
+			IPs:       []net.IP{net.ParseIP("10.1.1.2")},
+		},
+		Networks: []Network{
+			{
+				NetworkID: "net2",
+				IPs:       []net.IP{net.ParseIP("10.2.1.2")},
+			},
+		},
+		Capacity: ContainerCapacity{
+			CPU:    1,
+			Memory: 1024,
+		},
+	}
+	require.NoError(t, validateContainerConfig(container))
+
+	invalid := container
+	invalid.Networks = []Network{{NetworkID: "net2"}}
+	require.Error(t, validateContainerConfig(invalid))
+
+	invalid = container
+	invalid.Networks = []Network{{NetworkID: "net1", IPs: []net.IP{net.ParseIP("10.1.1.3")}}}
+	require.Error(t, validateContainerConfig(invalid))
+
+	invalid = container
+	invalid.Networks = []Network{{NetworkID: "net2", IPs: []net.IP{net.ParseIP("10.2.1.2")}, PublicIP6: true}}
+	require.Error(t, validateContainerConfig(invalid))
+
+	invalid = container
+	invalid.Networks = []Network{{NetworkID: "net2", IPs: []net.IP{net.ParseIP("10.1.1.3")}}}
+	require.Error(t, validateContainerConfig(invalid))
+}
+
+func TestValidateContainerRestartPolicy(t *testing.T) {
+	container := Container{
+		FList: "https://hub.grid.tf/tf-official-apps/ubuntu-bionic-build.flist",
+		Network: Network{
+			NetworkID: "net1",
+			IPs:       []net.IP{net.ParseIP("10.1.1.2")},
+		},
+		Capacity: ContainerCapacity{
+			CPU:    1,
+			Memory: 1024,
+		},
+	}
+
+	invalid := container
+	invalid.RestartPolicy = &RestartPolicy{Policy: "sometimes"}
+	require.Error(t, validateContainerConfig(invalid))
+
+	invalid = container
+	invalid.RestartPolicy = &RestartPolicy{Policy: pkg.RestartAlways, MaxRetries: 3}
+	require.Error(t, validateContainerConfig(invalid))
+
+	valid := container
+	valid.RestartPolicy = &RestartPolicy{Policy: pkg.RestartOnFailure, MaxRetries: 10}
+	require.NoError(t, validateContainerConfig(valid))
+
+	valid.RestartPolicy = &RestartPolicy{Policy: pkg.RestartOnExit, MaxRetries: 10}
+	require.NoError(t, validateContainerConfig(valid))
+}
+
+func TestValidateContainerLogs(t *testing.T) {
+	container := Container{
+		FList: "https://hub.grid.tf/tf-official-apps/ubuntu-bionic-build.flist",
+		Network: Network{
+			NetworkID: "net1",
+			IPs:       []net.IP{net.ParseIP("10.1.1.2")},
+		},
+		Mounts: []Mount{{VolumeID: "1-1", Mountpoint: "/data"}},
+		Capacity: ContainerCapacity{
+			CPU:    1,
+			Memory: 1024,
+		},
+	}
+
+	logs := func(typ string, data string) []Logs {
+		return []Logs{{Type: typ, Data: json.RawMessage(data)}}
+	}
+
+	for _, valid := range [][]Logs{
+		logs("redis", `{"stdout": "redis://host/stdout"}`),
+		logs("file", `{}`),
+		logs("file", `{"volume_id": "1-1", "path": "/var/log/app.log", "max_size": 1073741824}`),
+		logs("console", `{"size": 1024}`),
+		append(logs("file", `{}`), logs("file", `{"volume_id": "1-1"}`)...),
+	} {
+		container.Logs = valid
+		require.NoError(t, validateContainerConfig(container))
+	}
+
+	for _, invalid := range [][]Logs{
+		logs("unknown", `{}`),
+		logs("file", `{"volume_id": "2-1"}`),
+		logs("file", `{"volume_id": "1-1", "path": "../.."}`),
+		logs("file", `{"path": "/var/log/app.log"}`),
+		logs("file", `{"max_size": 1073741824}`),
+		logs("console", `{"size": 1073741824}`),
+		append(logs("console", `{}`), logs("console", `{}`)...),
+		append(logs("file", `{}`), logs("file", `{"max_files": 2}`)...),
+		append(logs("file", `{"volume_id": "1-1", "path": "/app.log"}`), logs("file", `{"volume_id": "1-1", "path": "app.log"}`)...),
+	} {
+		container.Logs = invalid
+		require.Error(t, validateContainerConfig(container))
+	}
+}
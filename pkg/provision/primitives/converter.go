@@ -3,7 +3,6 @@ package primitives
 import (
 	"encoding/json"
 	"fmt"
-	"math"
 	"net"
 	"strings"
 
@@ -63,6 +62,14 @@ func ContainerToProvisionType(w workloads.Workloader, reservationID string) (Con
 			PublicIP6:   c.NetworkConnection[0].PublicIp6,
 			YggdrasilIP: c.NetworkConnection[0].YggdrasilIP,
 		}
+
+		// the other connections are extra networks
+		for _, conn := range c.NetworkConnection[1:] {
+			container.Networks = append(container.Networks, Network{
+				IPs:       []net.IP{conn.Ipaddress},
+				NetworkID: pkg.NetID(conn.NetworkId),
+			})
+		}
 	}
 
 	for i, mount := range c.Volumes {
@@ -83,14 +90,19 @@ func ContainerToProvisionType(w workloads.Workloader, reservationID string) (Con
 			}
 		}
 
+		data, err := json.Marshal(LogsData{
+			Stdout:       lg.Data.Stdout,
+			Stderr:       lg.Data.Stderr,
+			SecretStdout: lg.Data.SecretStdout,
+			SecretStderr: lg.Data.SecretStderr,
+		})
+		if err != nil {
+			return Container{}, "", err
+		}
+
 		container.Logs[i] = Logs{
 			Type: lg.Type,
-			Data: LogsData{
-				Stdout:       lg.Data.Stdout,
-				Stderr:       lg.Data.Stderr,
-				SecretStdout: lg.Data.SecretStdout,
-				SecretStderr: lg.Data.SecretStderr,
-			},
+			Data: data,
 		}
 	}
 
@@ -268,7 +280,7 @@ func WorkloadToProvisionType(w workloads.Workloader) (*provision.Reservation, er
 		User:      fmt.Sprintf("%d", w.GetCustomerTid()),
 		Type:      provision.ReservationType(w.GetWorkloadType().String()),
 		Created:   w.GetEpoch().Time,
-		Duration:  math.MaxInt64, //ensure we never decomission based on expiration time. Since the capacity pool introduction this is not needed anymore
+		Duration:  provision.NoExpiry, //ensure we never decomission based on expiration time. Since the capacity pool introduction this is not needed anymore
 		Signature: []byte(w.GetCustomerSignature()),
 		ToDelete:  nextAction == workloads.NextActionDelete || nextAction == workloads.NextActionDeleted,
 		Reference: w.GetReference(),
@@ -322,6 +334,10 @@ func WorkloadToProvisionType(w workloads.Workloader) (*provision.Reservation, er
 		return nil, err
 	}
 
+	// the explorer checks the signature of the customer before it sets the
+	// epoch of the workload, so the signed challenge can't be rebuilt here
+	reservation.Verified = true
+
 	return reservation, nil
 }
 
@@ -332,7 +348,7 @@ func ResultToSchemaType(r provision.Result) (*workloads.Result, error) {
 	switch r.Type {
 	case VolumeReservation:
 		rType = workloads.WorkloadTypeVolume
-	case ContainerReservation:
+	case ContainerReservation, JobReservation:
 		rType = workloads.WorkloadTypeContainer
 	case ZDBReservation:
 		rType = workloads.WorkloadTypeZDB
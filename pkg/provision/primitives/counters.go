@@ -3,6 +3,8 @@ package primitives
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
 	"sync/atomic"
 
 	"github.com/pkg/errors"
@@ -54,6 +56,12 @@ func (c *CounterUint64) Current() uint64 {
 	return atomic.LoadUint64((*uint64)(c))
 }
 
+// ExternalUnits returns the resource units of the reservations of the
+// types deployed by external provisioners. ok is false for other types
+type ExternalUnits interface {
+	Units(r *provision.Reservation) (u pkg.ResourceUnits, ok bool, err error)
+}
+
 // Counters tracks the amount of primitives workload deployed and
 // the amount of resource unit used
 type Counters struct {
@@ -67,6 +75,77 @@ type Counters struct {
 	HRU CounterUint64 // HDD storage in bytes
 	MRU CounterUint64 // Memory storage in bytes
 	CRU CounterUint64 // CPU count absolute
+
+	// External computes the resource units of the reservation
+	// types unknown to the counters. Can be nil
+	External ExternalUnits
+
+	// users tracks what each user reserved
+	users   map[string]*userCounters
+	usersMu sync.Mutex
+}
+
+// userCounters tracks the resource units and
+// amount of workloads reserved by a single user
+type userCounters struct {
+	workloads CounterUint64
+
+	SRU   CounterUint64
+	HRU   CounterUint64
+	MRU   CounterUint64
+	CRU   CounterUint64
+	IPV4U CounterUint64
+}
+
+func (c *Counters) user(id string) *userCounters {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	if c.users == nil {
+		c.users = make(map[string]*userCounters)
+	}
+
+	u, ok := c.users[id]
+	if !ok {
+		u = &userCounters{}
+		c.users[id] = u
+	}
+
+	return u
+}
+
+// UserUsage returns the resource units and the amount
+// of workloads reserved by user
+func (c *Counters) UserUsage(user string) pkg.UserUsage {
+	u := c.user(user)
+	return pkg.UserUsage{
+		User: user,
+		Used: pkg.ResourceUnits{
+			CRU:   u.CRU.Current(),
+			MRU:   u.MRU.Current(),
+			SRU:   u.SRU.Current(),
+			HRU:   u.HRU.Current(),
+			IPV4U: u.IPV4U.Current(),
+		},
+		Workloads: u.workloads.Current(),
+	}
+}
+
+// Users returns the IDs of the users that reserved workloads on the node
+func (c *Counters) Users() []string {
+	c.usersMu.Lock()
+	defer c.usersMu.Unlock()
+
+	users := make([]string, 0, len(c.users))
+	for id, u := range c.users {
+		if u.workloads.Current() == 0 {
+			continue
+		}
+		users = append(users, id)
+	}
+	sort.Strings(users)
+
+	return users
 }
 
 // CurrentWorkloads return the number of each workloads provisioned on the system
@@ -108,7 +187,7 @@ func (c *Counters) Increment(r *provision.Reservation) error {
 	case VolumeReservation:
 		c.volumes.Increment(1)
 		u, err = processVolume(r)
-	case ContainerReservation:
+	case ContainerReservation, JobReservation:
 		c.containers.Increment(1)
 		u, err = processContainer(r)
 	case ZDBReservation:
@@ -117,13 +196,15 @@ func (c *Counters) Increment(r *provision.Reservation) error {
 	case KubernetesReservation:
 		c.vms.Increment(1)
 		u, err = processKubernetes(r)
+	case VirtualMachineReservation:
+		c.vms.Increment(1)
+		u, err = processVirtualMachine(r)
 	case NetworkReservation, NetworkResourceReservation:
 		c.networks.Increment(1)
 		u = resourceUnits{}
 		err = nil
 	default:
-		u = resourceUnits{}
-		err = nil
+		u, err = c.externalUnits(r)
 	}
 	if err != nil {
 		return err
@@ -134,6 +215,16 @@ func (c *Counters) Increment(r *provision.Reservation) error {
 	c.SRU.Increment(u.SRU)
 	c.HRU.Increment(u.HRU)
 
+	user := c.user(r.User)
+	user.workloads.Increment(1)
+	user.CRU.Increment(u.CRU)
+	user.MRU.Increment(u.MRU)
+	user.SRU.Increment(u.SRU)
+	user.HRU.Increment(u.HRU)
+	if r.Type == PublicIPReservation {
+		user.IPV4U.Increment(1)
+	}
+
 	return nil
 }
 
@@ -149,7 +240,7 @@ func (c *Counters) Decrement(r *provision.Reservation) error {
 	case VolumeReservation:
 		c.volumes.Decrement(1)
 		u, err = processVolume(r)
-	case ContainerReservation:
+	case ContainerReservation, JobReservation:
 		c.containers.Decrement(1)
 		u, err = processContainer(r)
 	case ZDBReservation:
@@ -158,13 +249,15 @@ func (c *Counters) Decrement(r *provision.Reservation) error {
 	case KubernetesReservation:
 		c.vms.Decrement(1)
 		u, err = processKubernetes(r)
+	case VirtualMachineReservation:
+		c.vms.Decrement(1)
+		u, err = processVirtualMachine(r)
 	case NetworkReservation, NetworkResourceReservation:
 		c.networks.Decrement(1)
 		u = resourceUnits{}
 		err = nil
 	default:
-		u = resourceUnits{}
-		err = nil
+		u, err = c.externalUnits(r)
 	}
 	if err != nil {
 		return err
@@ -175,9 +268,39 @@ func (c *Counters) Decrement(r *provision.Reservation) error {
 	c.SRU.Decrement(u.SRU)
 	c.HRU.Decrement(u.HRU)
 
+	user := c.user(r.User)
+	user.workloads.Decrement(1)
+	user.CRU.Decrement(u.CRU)
+	user.MRU.Decrement(u.MRU)
+	user.SRU.Decrement(u.SRU)
+	user.HRU.Decrement(u.HRU)
+	if r.Type == PublicIPReservation {
+		user.IPV4U.Decrement(1)
+	}
+
 	return nil
 }
 
+// externalUnits returns the resource units of a reservation
+// deployed by an external provisioner
+func (c *Counters) externalUnits(r *provision.Reservation) (u resourceUnits, err error) {
+	if c.External == nil {
+		return u, nil
+	}
+
+	units, ok, err := c.External.Units(r)
+	if err != nil || !ok {
+		return u, err
+	}
+
+	return resourceUnits{
+		CRU: units.CRU,
+		MRU: units.MRU,
+		SRU: units.SRU,
+		HRU: units.HRU,
+	}, nil
+}
+
 type resourceUnits struct {
 	SRU uint64 `json:"sru,omitempty"`
 	HRU uint64 `json:"hru,omitempty"`
@@ -192,7 +315,7 @@ func (c *Counters) CheckMemoryRequirements(r *provision.Reservation, totalMemAva
 	var err error
 
 	switch r.Type {
-	case ContainerReservation:
+	case ContainerReservation, JobReservation:
 		requestedUnits, err = processContainer(r)
 		if err != nil {
 			return err
@@ -203,6 +326,12 @@ func (c *Counters) CheckMemoryRequirements(r *provision.Reservation, totalMemAva
 		if err != nil {
 			return err
 		}
+
+	case VirtualMachineReservation:
+		requestedUnits, err = processVirtualMachine(r)
+		if err != nil {
+			return err
+		}
 	}
 
 	if requestedUnits.MRU != 0 {
@@ -352,3 +481,17 @@ func processKubernetes(r *provision.Reservation) (u resourceUnits, err error) {
 
 	return u, nil
 }
+
+func processVirtualMachine(r *provision.Reservation) (u resourceUnits, err error) {
+	var vm VirtualMachine
+	if err = json.Unmarshal(r.Data, &vm); err != nil {
+		return u, err
+	}
+
+	// memory and disk size are in MiB, the vdisks are on ssd
+	u.CRU = uint64(vm.CPU)
+	u.MRU = vm.Memory * mib
+	u.SRU = vm.DiskSize * mib
+
+	return u, nil
+}
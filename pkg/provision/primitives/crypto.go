@@ -4,20 +4,104 @@ import (
 	"crypto/ed25519"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfexplorer/schema"
-	"github.com/threefoldtech/zbus"
 	"github.com/threefoldtech/zos/pkg/app"
 	"github.com/threefoldtech/zos/pkg/stubs"
 )
 
-func decryptSecret(secret, userID string, reservationVersion int, client zbus.Client) (string, error) {
+// UserKeys is a cache of the users public keys. Keys are retrieved
+// from the explorer phonebook the first time they are needed, then
+// kept in memory and persisted in root so they survive a restart.
+//
+// Keys can also be seeded by writing the hex encoded key of a user
+// in <root>/<user id>, which is useful for nodes running without explorer
+type UserKeys struct {
+	sync.RWMutex
+	root  string
+	keys  map[string]ed25519.PublicKey
+	fetch func(userID string) (ed25519.PublicKey, error)
+}
+
+// NewUserKeys creates a user key cache persisted in root
+func NewUserKeys(root string) (*UserKeys, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create user keys directory %s", root)
+	}
+
+	return &UserKeys{
+		root:  root,
+		keys:  make(map[string]ed25519.PublicKey),
+		fetch: fetchUserPublicKey,
+	}, nil
+}
+
+// PublicKey implements provision.UserKeyGetter
+func (u *UserKeys) PublicKey(userID string) (ed25519.PublicKey, error) {
+	if len(userID) == 0 || strings.ContainsAny(userID, "/\\") || strings.HasPrefix(userID, ".") {
+		return nil, fmt.Errorf("invalid user id '%s'", userID)
+	}
+
+	u.RLock()
+	key, ok := u.keys[userID]
+	u.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	// check again, the key could have been loaded while
+	// we were waiting for the lock
+	if key, ok := u.keys[userID]; ok {
+		return key, nil
+	}
+
+	path := filepath.Join(u.root, userID)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err == nil && len(key) == ed25519.PublicKeySize {
+			u.keys[userID] = key
+			return key, nil
+		}
+		log.Warn().Str("user", userID).Msg("persisted user key is invalid, fetching it again")
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read persisted key of user %s", userID)
+	}
+
+	key, err = u.fetch(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key of user %s has the wrong size", userID)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)), 0660); err != nil {
+		log.Error().Err(err).Str("user", userID).Msg("failed to persist user public key")
+	}
+
+	u.keys[userID] = key
+	return key, nil
+}
+
+func (p *Provisioner) decryptSecret(secret, userID string, reservationVersion int) (string, error) {
 	if len(secret) == 0 {
 		return "", nil
 	}
 
-	identity := stubs.NewIdentityManagerStub(client)
+	identity := stubs.NewIdentityManagerStub(p.zbus)
 
 	bytes, err := hex.DecodeString(secret)
 	if err != nil {
@@ -32,7 +116,7 @@ func decryptSecret(secret, userID string, reservationVersion int, client zbus.Cl
 	case 0:
 		out, err = identity.Decrypt(bytes)
 	default:
-		userPubKey, err = fetchUserPublicKey(userID)
+		userPubKey, err = p.users.PublicKey(userID)
 		if err != nil {
 			return "", fmt.Errorf("failed to retrieve user %s public key: %w", userID, err)
 		}
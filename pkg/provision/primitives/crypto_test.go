@@ -0,0 +1,66 @@
+package primitives
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserKeys(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "user-keys")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	pk, _, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	calls := 0
+	fetch := func(userID string) (ed25519.PublicKey, error) {
+		calls++
+		if userID != "1" {
+			return nil, fmt.Errorf("user not found")
+		}
+		return pk, nil
+	}
+
+	users, err := NewUserKeys(root)
+	require.NoError(err)
+	users.fetch = fetch
+
+	key, err := users.PublicKey("1")
+	require.NoError(err)
+	require.Equal(pk, key)
+
+	// second call is served from memory
+	_, err = users.PublicKey("1")
+	require.NoError(err)
+	require.Equal(1, calls)
+
+	_, err = users.PublicKey("2")
+	require.Error(err)
+
+	_, err = users.PublicKey("../1")
+	require.Error(err)
+
+	// the key is persisted so a new cache doesn't fetch it again
+	data, err := ioutil.ReadFile(filepath.Join(root, "1"))
+	require.NoError(err)
+	require.Equal(hex.EncodeToString(pk), string(data))
+
+	users, err = NewUserKeys(root)
+	require.NoError(err)
+	users.fetch = fetch
+
+	key, err = users.PublicKey("1")
+	require.NoError(err)
+	require.Equal(pk, key)
+	require.Equal(2, calls)
+}
@@ -0,0 +1,63 @@
+package primitives
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// ensureFList mounts the flist at url read only. The mount is named
+// after the flist hash so all the vms using the same image share it
+func ensureFList(flister pkg.Flister, prefix, url string) (string, error) {
+	hash, err := flister.FlistHash(url)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s:%s", prefix, hash)
+
+	return flister.NamedMount(name, url, "", pkg.ReadOnlyMountOptions)
+}
+
+// hubUnreachable are parts of the messages of the errors returned by the
+// flist module when the hub can't be reached. The flist module is called
+// over zbus, so its errors can only be told apart by their message
+var hubUnreachable = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"network is unreachable",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"Client.Timeout exceeded",
+	"unexpected EOF",
+}
+
+// hubStatus matches the http status codes returned by the hub when it
+// fails to answer, as reported by the flist module
+var hubStatus = regexp.MustCompile(`(response|flist): (5\d\d|429)\b`)
+
+// flistError marks the error of a failed flist mount as retryable if the hub
+// is not reachable. Other errors, like a missing or invalid flist, can't be
+// fixed by trying again
+func flistError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	if hubStatus.MatchString(msg) {
+		return provision.Retryable(err)
+	}
+
+	for _, part := range hubUnreachable {
+		if strings.Contains(msg, part) {
+			return provision.Retryable(err)
+		}
+	}
+
+	return err
+}
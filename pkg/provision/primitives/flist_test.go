@@ -0,0 +1,34 @@
+package primitives
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestFlistError(t *testing.T) {
+	require := require.New(t)
+
+	require.NoError(flistError(nil))
+
+	transient := []string{
+		`Get "https://hub.grid.tf/user/app.flist": dial tcp 185.69.166.141:443: connect: connection refused`,
+		`Get "https://hub.grid.tf/user/app.flist.md5": dial tcp: lookup hub.grid.tf: no such host`,
+		"fail to download flist: 502 Bad Gateway",
+		"fail to fetch hash, response: 503",
+	}
+	for _, msg := range transient {
+		require.True(provision.IsRetryable(flistError(errors.New(msg))), msg)
+	}
+
+	permanent := []string{
+		"fail to download flist: 404 Not Found",
+		"fail to fetch hash, response: 404",
+		"exit status 1",
+	}
+	for _, msg := range permanent {
+		require.False(provision.IsRetryable(flistError(errors.New(msg))), msg)
+	}
+}
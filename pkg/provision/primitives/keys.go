@@ -0,0 +1,88 @@
+package primitives
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// ReservationKeys implements provision.ReservationKeysFunc. It returns the
+// network, volumes and public IP used by a reservation so the engine never
+// processes two reservations working on the same resource at the same time.
+// Public IP reservations of the same address are processed in order too, so
+// the capacity check sees the address already reserved
+func ReservationKeys(r *provision.Reservation) []string {
+	provides, uses := ReservationDependencies(r)
+	keys := append(provides, uses...)
+
+	if r.Type == PublicIPReservation {
+		var config PublicIP
+		if err := json.Unmarshal(r.Data, &config); err == nil {
+			keys = append(keys, fmt.Sprintf("public-ip:%s", config.IP.IP))
+		}
+	}
+
+	return keys
+}
+
+// ReservationDependencies implements provision.DependenciesFunc. Networks provide
+// the network they configure, containers use their network and volumes and
+// virtual machines use their network and public IP
+func ReservationDependencies(r *provision.Reservation) (provides []string, uses []string) {
+	switch r.Type {
+	case NetworkReservation, NetworkResourceReservation:
+		var nr pkg.NetResource
+		if err := json.Unmarshal(r.Data, &nr); err != nil {
+			return nil, nil
+		}
+		return []string{networkKey(provision.NetworkID(r.User, nr.Name))}, nil
+
+	case ContainerReservation, JobReservation:
+		var config Container
+		if err := json.Unmarshal(r.Data, &config); err != nil {
+			return nil, nil
+		}
+
+		uses = []string{networkKey(provision.NetworkID(r.User, string(config.Network.NetworkID)))}
+		for _, network := range config.Networks {
+			uses = append(uses, networkKey(provision.NetworkID(r.User, string(network.NetworkID))))
+		}
+		for _, mount := range config.Mounts {
+			// the volume ID is the ID of the volume reservation
+			uses = append(uses, mount.VolumeID)
+		}
+		return nil, uses
+
+	case KubernetesReservation:
+		var config Kubernetes
+		if err := json.Unmarshal(r.Data, &config); err != nil {
+			return nil, nil
+		}
+
+		uses = []string{networkKey(provision.NetworkID(r.User, string(config.NetworkID)))}
+		if config.PublicIP != 0 {
+			uses = append(uses, pubIPResID(config.PublicIP))
+		}
+		return nil, uses
+
+	case VirtualMachineReservation:
+		var config VirtualMachine
+		if err := json.Unmarshal(r.Data, &config); err != nil {
+			return nil, nil
+		}
+
+		uses = []string{networkKey(provision.NetworkID(r.User, string(config.NetworkID)))}
+		if config.PublicIP != 0 {
+			uses = append(uses, pubIPResID(config.PublicIP))
+		}
+		return nil, uses
+	}
+
+	return nil, nil
+}
+
+func networkKey(id pkg.NetID) string {
+	return fmt.Sprintf("network:%s", id)
+}
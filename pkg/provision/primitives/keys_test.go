@@ -0,0 +1,78 @@
+package primitives
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestReservationKeys(t *testing.T) {
+	mustMarshal := func(v interface{}) json.RawMessage {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return b
+	}
+
+	network := &provision.Reservation{
+		ID:   "1-1",
+		User: "1",
+		Type: NetworkResourceReservation,
+		Data: mustMarshal(pkg.NetResource{Name: "net"}),
+	}
+
+	container := &provision.Reservation{
+		ID:   "2-1",
+		User: "1",
+		Type: ContainerReservation,
+		Data: mustMarshal(Container{
+			Network: Network{NetworkID: "net"},
+			Mounts:  []Mount{{VolumeID: "3-1"}},
+		}),
+	}
+
+	other := &provision.Reservation{
+		ID:   "4-1",
+		User: "2",
+		Type: ContainerReservation,
+		Data: mustMarshal(Container{
+			Network: Network{NetworkID: "net"},
+		}),
+	}
+
+	netKeys := ReservationKeys(network)
+	require.Len(t, netKeys, 1)
+
+	containerKeys := ReservationKeys(container)
+	assert.Contains(t, containerKeys, netKeys[0])
+	assert.Contains(t, containerKeys, "3-1")
+
+	// same network name but different user is a different network
+	assert.NotContains(t, ReservationKeys(other), netKeys[0])
+
+	// extra networks are used too
+	multi := &provision.Reservation{
+		ID:   "5-1",
+		User: "1",
+		Type: ContainerReservation,
+		Data: mustMarshal(Container{
+			Network:  Network{NetworkID: "other"},
+			Networks: []Network{{NetworkID: "net"}},
+		}),
+	}
+	assert.Contains(t, ReservationKeys(multi), netKeys[0])
+
+	// public ips of the same address share a key
+	publicIP := func(id string) *provision.Reservation {
+		return &provision.Reservation{
+			ID:   id,
+			Type: PublicIPReservation,
+			Data: mustMarshal(PublicIP{IP: net.IPNet{IP: net.ParseIP("185.69.166.10"), Mask: net.CIDRMask(24, 32)}}),
+		}
+	}
+	assert.Equal(t, ReservationKeys(publicIP("6-1")), ReservationKeys(publicIP("7-1")))
+}
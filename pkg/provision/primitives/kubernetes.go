@@ -60,17 +60,6 @@ func (p *Provisioner) kubernetesProvision(ctx context.Context, reservation *prov
 	return p.kubernetesProvisionImpl(ctx, reservation)
 }
 
-func ensureFList(flister pkg.Flister, url string) (string, error) {
-	hash, err := flister.FlistHash(url)
-	if err != nil {
-		return "", err
-	}
-
-	name := fmt.Sprintf("k8s:%s", hash)
-
-	return flister.NamedMount(name, url, "", pkg.ReadOnlyMountOptions)
-}
-
 func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *provision.Reservation) (result KubernetesResult, err error) {
 	var (
 		storage = stubs.NewVDiskModuleStub(p.zbus)
@@ -89,18 +78,6 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 
 	netID := provision.NetworkID(reservation.User, string(config.NetworkID))
 
-	// check if the network tap already exists
-	// if it does, it's most likely that a vm with the same network id and node id already exists
-	// this will cause the reservation to fail
-	exists, err := network.TapExists(netID)
-	if err != nil {
-		return result, errors.Wrap(err, "could not check if tap device exists")
-	}
-
-	if exists {
-		return result, errors.New("kubernetes vm with same network already exists")
-	}
-
 	// check if public ipv4 is supported, should this be requested
 	if config.PublicIP != 0 && !network.PublicIPv4Support() {
 		return result, errors.New("public ipv4 is requested, but not supported on this node")
@@ -109,7 +86,7 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 	result.ID = reservation.ID
 	result.IP = config.IP.String()
 
-	config.PlainClusterSecret, err = decryptSecret(config.ClusterSecret, reservation.User, reservation.Version, p.zbus)
+	config.PlainClusterSecret, err = p.decryptSecret(config.ClusterSecret, reservation.User, reservation.Version)
 	if err != nil {
 		return result, errors.Wrap(err, "failed to decrypt namespace password")
 	}
@@ -124,9 +101,9 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 		return result, nil
 	}
 
-	imagePath, err := ensureFList(flist, k3osFlistURL)
+	imagePath, err := ensureFList(flist, "k8s", k3osFlistURL)
 	if err != nil {
-		return result, errors.Wrap(err, "could not mount k3os flist")
+		return result, flistError(errors.Wrap(err, "could not mount k3os flist"))
 	}
 
 	var diskPath string
@@ -151,15 +128,28 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 		}
 	}()
 
+	// the vm is not running, so a tap device left for the reservation
+	// is stale and can be replaced
+	exists, err := network.TapExists(reservation.ID)
+	if err != nil {
+		return result, errors.Wrap(err, "could not check if tap device exists")
+	}
+
+	if exists {
+		if err = network.RemoveTap(reservation.ID); err != nil {
+			return result, errors.Wrap(err, "could not remove stale tap device")
+		}
+	}
+
 	var iface string
-	iface, err = network.SetupTap(netID)
+	iface, err = network.SetupTap(netID, reservation.ID)
 	if err != nil {
 		return result, errors.Wrap(err, "could not set up tap device")
 	}
 
 	defer func() {
 		if err != nil {
-			_ = network.RemoveTap(netID)
+			_ = network.RemoveTap(reservation.ID)
 		}
 	}()
 
@@ -178,7 +168,7 @@ func (p *Provisioner) kubernetesProvisionImpl(ctx context.Context, reservation *
 	}
 
 	var netInfo pkg.VMNetworkInfo
-	netInfo, err = p.buildNetworkInfo(ctx, reservation.Version, reservation.User, iface, pubIface, config)
+	netInfo, err = p.buildNetworkInfo(ctx, reservation.Version, reservation.User, iface, pubIface, config.NetworkID, config.IP, config.PublicIP)
 	if err != nil {
 		return result, errors.Wrap(err, "could not generate network info")
 	}
@@ -315,11 +305,15 @@ func (p *Provisioner) kubernetesDecomission(ctx context.Context, reservation *pr
 		}
 	}
 
-	netID := provision.NetworkID(reservation.User, string(cfg.NetworkID))
-	if err := network.RemoveTap(netID); err != nil {
+	if err := network.RemoveTap(reservation.ID); err != nil {
 		return errors.Wrap(err, "could not clean up tap device")
 	}
 
+	netID := provision.NetworkID(reservation.User, string(cfg.NetworkID))
+	if err := p.removeLegacyTap(reservation, netID); err != nil {
+		return errors.Wrap(err, "could not clean up legacy tap device")
+	}
+
 	if cfg.PublicIP != 0 {
 		if err := network.RemovePubTap(pubIPResID(cfg.PublicIP)); err != nil {
 			return errors.Wrap(err, "could not clean up public tap device")
@@ -333,17 +327,56 @@ func (p *Provisioner) kubernetesDecomission(ctx context.Context, reservation *pr
 	return nil
 }
 
-func (p *Provisioner) buildNetworkInfo(ctx context.Context, rversion int, userID string, iface string, pubIface string, cfg Kubernetes) (pkg.VMNetworkInfo, error) {
+// removeLegacyTap removes the tap device named after the network, used by the
+// vms deployed before the taps were named after the reservation, once the
+// last vm of the network other than reservation is gone
+func (p *Provisioner) removeLegacyTap(reservation *provision.Reservation, netID pkg.NetID) error {
+	reservations, err := p.cache.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list cached reservations")
+	}
+
+	if vmsInNetwork(reservations, reservation.ID, netID) {
+		return nil
+	}
+
 	network := stubs.NewNetworkerStub(p.zbus)
+	return network.RemoveTap(string(netID))
+}
 
-	netID := provision.NetworkID(userID, string(cfg.NetworkID))
+// vmsInNetwork returns true if a vm other than the reservation
+// with id exclude is connected to the network netID
+func vmsInNetwork(reservations []*provision.Reservation, exclude string, netID pkg.NetID) bool {
+	key := networkKey(netID)
+	for _, r := range reservations {
+		if r.ID == exclude || (r.Type != KubernetesReservation && r.Type != VirtualMachineReservation) {
+			continue
+		}
+
+		_, uses := ReservationDependencies(r)
+		for _, use := range uses {
+			if use == key {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// buildNetworkInfo builds the network configuration of a vm with the private
+// ip in the network networkID, and the public ip reserved by publicIP if not 0
+func (p *Provisioner) buildNetworkInfo(ctx context.Context, rversion int, userID string, iface string, pubIface string, networkID pkg.NetID, ip net.IP, publicIP schema.ID) (pkg.VMNetworkInfo, error) {
+	network := stubs.NewNetworkerStub(p.zbus)
+
+	netID := provision.NetworkID(userID, string(networkID))
 	subnet, err := network.GetSubnet(netID)
 	if err != nil {
 		return pkg.VMNetworkInfo{}, errors.Wrapf(err, "could not get network resource subnet")
 	}
 
-	if !subnet.Contains(cfg.IP) {
-		return pkg.VMNetworkInfo{}, fmt.Errorf("IP %s is not part of local nr subnet %s", cfg.IP.String(), subnet.String())
+	if !subnet.Contains(ip) {
+		return pkg.VMNetworkInfo{}, fmt.Errorf("IP %s is not part of local nr subnet %s", ip.String(), subnet.String())
 	}
 
 	privNet, err := network.GetNet(netID)
@@ -352,7 +385,7 @@ func (p *Provisioner) buildNetworkInfo(ctx context.Context, rversion int, userID
 	}
 
 	addrCIDR := net.IPNet{
-		IP:   cfg.IP,
+		IP:   ip,
 		Mask: subnet.Mask,
 	}
 
@@ -361,7 +394,7 @@ func (p *Provisioner) buildNetworkInfo(ctx context.Context, rversion int, userID
 		return pkg.VMNetworkInfo{}, errors.Wrap(err, "could not get network resource default gateway")
 	}
 
-	privIP6, err := network.GetIPv6From4(netID, cfg.IP)
+	privIP6, err := network.GetIPv6From4(netID, ip)
 	if err != nil {
 		return pkg.VMNetworkInfo{}, errors.Wrap(err, "could not convert private ipv4 to ipv6")
 	}
@@ -385,11 +418,11 @@ func (p *Provisioner) buildNetworkInfo(ctx context.Context, rversion int, userID
 		networkInfo.NewStyle = true
 	}
 
-	if cfg.PublicIP != 0 {
+	if publicIP != 0 {
 		// A public ip is set, load the reservation, extract the ip and make a config
 		// for it
 
-		pubIP, pubGw, err := p.getPubIPConfig(cfg.PublicIP)
+		pubIP, pubGw, err := p.getPubIPConfig(publicIP)
 		if err != nil {
 			return pkg.VMNetworkInfo{}, errors.Wrap(err, "could not get public ip config")
 		}
@@ -0,0 +1,35 @@
+package primitives
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+func TestVMsInNetwork(t *testing.T) {
+	require := require.New(t)
+
+	reservation := func(id string, typ provision.ReservationType, data interface{}) *provision.Reservation {
+		b, err := json.Marshal(data)
+		require.NoError(err)
+		return &provision.Reservation{ID: id, Type: typ, User: "1", Data: b}
+	}
+
+	netID := provision.NetworkID("1", "net")
+	reservations := []*provision.Reservation{
+		reservation("1-1", KubernetesReservation, Kubernetes{NetworkID: "net"}),
+		reservation("1-2", KubernetesReservation, Kubernetes{NetworkID: "other"}),
+		reservation("1-3", ContainerReservation, Container{Network: Network{NetworkID: "net"}}),
+	}
+
+	// the containers don't use the network tap
+	require.False(vmsInNetwork(reservations, "1-1", netID))
+	require.True(vmsInNetwork(reservations, "1-2", netID))
+
+	reservations = append(reservations, reservation("1-4", VirtualMachineReservation, VirtualMachine{NetworkID: "net"}))
+	require.True(vmsInNetwork(reservations, "1-1", netID))
+	require.False(vmsInNetwork(reservations, "1-1", pkg.NetID("unknown")))
+}
@@ -0,0 +1,488 @@
+package primitives
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/shirou/gopsutil/host"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+const (
+	// DefaultMeterInterval is how often the usage of
+	// the deployed reservations is recorded
+	DefaultMeterInterval = 10 * time.Minute
+	// DefaultMeterRetention is how long the usage records are kept
+	DefaultMeterRetention = 180 * 24 * time.Hour
+
+	meterDayFormat = "2006-01-02"
+	meterExt       = ".jsonl"
+	meterLastFile  = "last"
+)
+
+// MeterRecord is the usage of a reservation during a period. Memory
+// and storage are in bytes
+type MeterRecord struct {
+	ID    string                    `json:"id"`
+	User  string                    `json:"user"`
+	Type  provision.ReservationType `json:"type"`
+	Start time.Time                 `json:"start"`
+	End   time.Time                 `json:"end"`
+	CRU   uint64                    `json:"cru,omitempty"`
+	MRU   uint64                    `json:"mru,omitempty"`
+	SRU   uint64                    `json:"sru,omitempty"`
+	HRU   uint64                    `json:"hru,omitempty"`
+	IPV4U uint64                    `json:"ipv4u,omitempty"`
+}
+
+// usage returns the usage of the record between from and to
+func (r *MeterRecord) usage(from, to time.Time) (u pkg.MeteredUsage, ok bool) {
+	start, end := r.Start, r.End
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+
+	if !end.After(start) {
+		return u, false
+	}
+
+	hours := end.Sub(start).Hours()
+	gib := float64(gib)
+
+	cru := float64(r.CRU)
+	mru := float64(r.MRU) / gib
+	sru := float64(r.SRU) / gib
+	hru := float64(r.HRU) / gib
+
+	// same conversion as the explorer
+	cu := math.Min(mru/4, cru/2)
+	su := hru/1200 + sru/300
+
+	return pkg.MeteredUsage{
+		CRU:   cru * hours,
+		MRU:   mru * hours,
+		SRU:   sru * hours,
+		HRU:   hru * hours,
+		IPV4U: float64(r.IPV4U) * hours,
+		CU:    cu * hours,
+		SU:    su * hours,
+	}, true
+}
+
+// Meter implements provision.Meter. It periodically records the resource
+// units used by each deployed reservation in one file per day. The usage
+// of a decommissioned reservation since the last sample is recorded when
+// it is decommissioned. The time of the last sample is persisted, so a
+// restart of the meter doesn't lose the usage since that sample
+type Meter struct {
+	root     string
+	nodeID   string
+	cache    provision.ReservationCache
+	counters *Counters
+	signer   provision.Signer
+
+	// Interval is how often the usage is recorded
+	Interval time.Duration
+	// Retention is how long the records are kept
+	Retention time.Duration
+
+	m sync.Mutex
+
+	// sampleM makes sure a reservation is not recorded by a sample
+	// and when it is decommissioned for the same period
+	sampleM sync.Mutex
+	last    time.Time
+}
+
+var _ provision.Meter = (*Meter)(nil)
+
+// NewMeter creates a meter that records the usage of the reservations
+// in cache in root. counters is used to compute the resource units of
+// the reservations and signer to sign the usage reports
+func NewMeter(root, nodeID string, cache provision.ReservationCache, counters *Counters, signer provision.Signer) (*Meter, error) {
+	if err := os.MkdirAll(root, 0770); err != nil {
+		return nil, errors.Wrapf(err, "failed to create metering directory %s", root)
+	}
+
+	return &Meter{
+		root:      root,
+		nodeID:    nodeID,
+		cache:     cache,
+		counters:  counters,
+		signer:    signer,
+		Interval:  DefaultMeterInterval,
+		Retention: DefaultMeterRetention,
+	}, nil
+}
+
+// Run records the usage of the deployed reservations until ctx is done
+func (m *Meter) Run(ctx context.Context) {
+	m.sampleM.Lock()
+	m.last = m.loadLast(time.Now())
+	m.sampleM.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.Interval):
+		}
+
+		now := time.Now()
+		if err := m.sample(now); err != nil {
+			log.Error().Err(err).Msg("failed to record reservations usage")
+		}
+
+		if err := m.rotate(now); err != nil {
+			log.Error().Err(err).Msg("failed to remove old usage records")
+		}
+	}
+}
+
+// loadLast returns the time of the last sample before the meter
+// was restarted. Nothing was consumed before the node booted
+func (m *Meter) loadLast(now time.Time) time.Time {
+	data, err := ioutil.ReadFile(filepath.Join(m.root, meterLastFile))
+	if os.IsNotExist(err) {
+		return now
+	} else if err != nil {
+		log.Error().Err(err).Msg("failed to read time of last usage sample")
+		return now
+	}
+
+	var last time.Time
+	if err := last.UnmarshalText(data); err != nil {
+		log.Error().Err(err).Msg("invalid time of last usage sample")
+		return now
+	}
+
+	boot, err := host.BootTime()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get node boot time")
+		return now
+	}
+
+	if booted := time.Unix(int64(boot), 0); last.Before(booted) {
+		last = booted
+	}
+
+	if last.After(now) {
+		return now
+	}
+
+	return last
+}
+
+// saveLast persists the time of the last sample
+func (m *Meter) saveLast() error {
+	data, err := m.last.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(m.root, meterLastFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0660); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Decommissioned implements provision.Meter
+func (m *Meter) Decommissioned(r *provision.Reservation, remove func() error) error {
+	return m.decommissioned(r, remove, time.Now())
+}
+
+func (m *Meter) decommissioned(r *provision.Reservation, remove func() error, now time.Time) error {
+	// the reservation is removed under the sample lock, otherwise a sample
+	// taken in between would advance the time of the last sample without
+	// recording the usage of r
+	m.sampleM.Lock()
+	defer m.sampleM.Unlock()
+
+	if err := remove(); err != nil {
+		return err
+	}
+
+	if r.Result.State != provision.StateOk {
+		return nil
+	}
+
+	if m.last.IsZero() {
+		// the meter is not running
+		return nil
+	}
+
+	record, ok, err := m.record(r, m.last, now)
+	if err != nil || !ok {
+		return err
+	}
+
+	return m.write(now, []MeterRecord{record})
+}
+
+// sample records the usage of the deployed reservations since the last sample
+func (m *Meter) sample(now time.Time) error {
+	m.sampleM.Lock()
+	defer m.sampleM.Unlock()
+
+	reservations, err := m.cache.List()
+	if err != nil {
+		return errors.Wrap(err, "failed to list deployed reservations")
+	}
+
+	var records []MeterRecord
+	for _, r := range reservations {
+		if r.Result.State != provision.StateOk {
+			continue
+		}
+
+		record, ok, err := m.record(r, m.last, now)
+		if err != nil {
+			log.Error().Err(err).Str("id", r.ID).Msg("failed to compute reservation usage")
+			continue
+		}
+
+		if ok {
+			records = append(records, record)
+		}
+	}
+
+	if err := m.write(now, records); err != nil {
+		return err
+	}
+
+	m.last = now
+	if err := m.saveLast(); err != nil {
+		log.Error().Err(err).Msg("failed to persist time of last usage sample")
+	}
+
+	return nil
+}
+
+// record returns the usage record of r between from and to. ok is
+// false if r didn't run during that period or has no resource units
+func (m *Meter) record(r *provision.Reservation, from, to time.Time) (record MeterRecord, ok bool, err error) {
+	if r.Created.After(from) {
+		from = r.Created
+	}
+	if r.Expiry().Before(to) {
+		to = r.Expiry()
+	}
+
+	if !to.After(from) {
+		return record, false, nil
+	}
+
+	u, err := m.counters.reservationUnits(r)
+	if err != nil {
+		return record, false, err
+	}
+
+	record = MeterRecord{
+		ID:    r.ID,
+		User:  r.User,
+		Type:  r.Type,
+		Start: from.UTC(),
+		End:   to.UTC(),
+		CRU:   u.CRU,
+		MRU:   u.MRU,
+		SRU:   u.SRU,
+		HRU:   u.HRU,
+	}
+
+	if r.Type == PublicIPReservation {
+		record.IPV4U = 1
+	}
+
+	if record.CRU == 0 && record.MRU == 0 && record.SRU == 0 && record.HRU == 0 && record.IPV4U == 0 {
+		return record, false, nil
+	}
+
+	return record, true, nil
+}
+
+// write appends records to the file of the day of now
+func (m *Meter) write(now time.Time, records []MeterRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	path := m.path(now)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0660)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open usage records file %s", path)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	enc := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return errors.Wrap(err, "failed to write usage record")
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write usage records")
+	}
+
+	return file.Sync()
+}
+
+// rotate removes the files older than the retention
+func (m *Meter) rotate(now time.Time) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	infos, err := ioutil.ReadDir(m.root)
+	if err != nil {
+		return err
+	}
+
+	oldest := now.Add(-m.Retention).UTC().Format(meterDayFormat)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, meterExt) {
+			continue
+		}
+
+		// days sort the same way as strings
+		if strings.TrimSuffix(name, meterExt) >= oldest {
+			continue
+		}
+
+		log.Info().Str("file", name).Msg("removing old usage records")
+		if err := os.Remove(filepath.Join(m.root, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Report implements provision.Meter
+func (m *Meter) Report(user string, from, to time.Time) (pkg.UsageReport, error) {
+	report := pkg.UsageReport{
+		NodeID: m.nodeID,
+		User:   user,
+		From:   from.UTC(),
+		To:     to.UTC(),
+	}
+
+	if !to.After(from) {
+		return report, fmt.Errorf("invalid time range, %s is not after %s", to, from)
+	}
+
+	workloads := make(map[string]*pkg.WorkloadUsage)
+	err := m.scan(from, to, func(record *MeterRecord) {
+		if record.User != user {
+			return
+		}
+
+		usage, ok := record.usage(from, to)
+		if !ok {
+			return
+		}
+
+		wl, ok := workloads[record.ID]
+		if !ok {
+			wl = &pkg.WorkloadUsage{ID: record.ID, Type: string(record.Type)}
+			workloads[record.ID] = wl
+		}
+
+		wl.Usage.Add(usage)
+		report.Total.Add(usage)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	report.Workloads = make([]pkg.WorkloadUsage, 0, len(workloads))
+	for _, wl := range workloads {
+		report.Workloads = append(report.Workloads, *wl)
+	}
+	sort.Slice(report.Workloads, func(i, j int) bool {
+		return report.Workloads[i].ID < report.Workloads[j].ID
+	})
+
+	data, err := report.SignedBytes()
+	if err != nil {
+		return report, errors.Wrap(err, "failed to encode usage report")
+	}
+
+	report.Signature, err = m.signer.Sign(data)
+	if err != nil {
+		return report, errors.Wrap(err, "failed to sign usage report")
+	}
+
+	return report, nil
+}
+
+// scan calls fn for all the records in the files that can
+// contain records overlapping the range from, to
+func (m *Meter) scan(from, to time.Time, fn func(record *MeterRecord)) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	// a record is stored in the file of the day it ends, so the previous
+	// day can hold records that end within the range, and the next day
+	// records that start within the range
+	day := from.UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	last := to.Add(24 * time.Hour)
+	for ; !day.After(last); day = day.Add(24 * time.Hour) {
+		if err := m.scanFile(m.path(day), fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Meter) scanFile(path string, fn func(record *MeterRecord)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to open usage records file %s", path)
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	for dec.More() {
+		var record MeterRecord
+		if err := dec.Decode(&record); err != nil {
+			// a crash can leave a partially written record at the end
+			log.Error().Err(err).Str("path", path).Msg("invalid usage record, skipping the rest of the file")
+			return nil
+		}
+
+		fn(&record)
+	}
+
+	return nil
+}
+
+func (m *Meter) path(t time.Time) string {
+	return filepath.Join(m.root, t.UTC().Format(meterDayFormat)+meterExt)
+}
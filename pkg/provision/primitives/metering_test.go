@@ -0,0 +1,214 @@
+package primitives
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+type testListCache struct {
+	provision.ReservationCache
+	reservations []*provision.Reservation
+}
+
+func (c *testListCache) List() ([]*provision.Reservation, error) {
+	return c.reservations, nil
+}
+
+type testSigner ed25519.PrivateKey
+
+func (s testSigner) Sign(b []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(s), b), nil
+}
+
+func TestMeter(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "meter-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	mustMarshal := func(v interface{}) json.RawMessage {
+		b, err := json.Marshal(v)
+		require.NoError(err)
+		return b
+	}
+
+	start := time.Date(2020, 12, 31, 23, 0, 0, 0, time.UTC)
+	cache := &testListCache{
+		reservations: []*provision.Reservation{
+			{
+				ID:       "1-1",
+				User:     "1",
+				Type:     ContainerReservation,
+				Created:  start,
+				Duration: 24 * time.Hour,
+				Data: mustMarshal(Container{
+					Capacity: ContainerCapacity{CPU: 2, Memory: 4096},
+				}),
+				Result: provision.Result{State: provision.StateOk},
+			},
+			{
+				ID:       "2-1",
+				User:     "1",
+				Type:     VolumeReservation,
+				Created:  start,
+				Duration: 90 * time.Minute,
+				Data:     mustMarshal(Volume{Size: 300, Type: pkg.SSDDevice}),
+				Result:   provision.Result{State: provision.StateOk},
+			},
+			{
+				ID:       "3-1",
+				User:     "2",
+				Type:     VolumeReservation,
+				Created:  start,
+				Duration: 24 * time.Hour,
+				Data:     mustMarshal(Volume{Size: 1, Type: pkg.HDDDevice}),
+				Result:   provision.Result{State: provision.StateOk},
+			},
+		},
+	}
+
+	meter, err := NewMeter(root, "node", cache, &Counters{}, testSigner(priv))
+	require.NoError(err)
+
+	// the second sample crosses midnight
+	meter.last = start
+	require.NoError(meter.sample(start.Add(30 * time.Minute)))
+	require.NoError(meter.sample(start.Add(2 * time.Hour)))
+
+	_, err = os.Stat(filepath.Join(root, "2020-12-31.jsonl"))
+	require.NoError(err)
+	_, err = os.Stat(filepath.Join(root, "2021-01-01.jsonl"))
+	require.NoError(err)
+
+	report, err := meter.Report("1", start, start.Add(2*time.Hour))
+	require.NoError(err)
+	require.Equal("node", report.NodeID)
+	require.Len(report.Workloads, 2)
+
+	container := report.Workloads[0]
+	require.Equal("1-1", container.ID)
+	require.InDelta(4, container.Usage.CRU, 0.001)
+	require.InDelta(8, container.Usage.MRU, 0.001)
+	require.InDelta(2, container.Usage.CU, 0.001)
+
+	// the volume expired after 90 minutes
+	volume := report.Workloads[1]
+	require.Equal("2-1", volume.ID)
+	require.InDelta(450, volume.Usage.SRU, 0.001)
+	require.InDelta(1.5, volume.Usage.SU, 0.001)
+
+	require.InDelta(2+1.5, report.Total.CU+report.Total.SU, 0.001)
+
+	data, err := report.SignedBytes()
+	require.NoError(err)
+	require.True(ed25519.Verify(pub, data, report.Signature))
+
+	// only the part of the records within the range is accounted for
+	report, err = meter.Report("1", start.Add(30*time.Minute), start.Add(time.Hour))
+	require.NoError(err)
+	require.InDelta(1, report.Workloads[0].Usage.CRU, 0.001)
+
+	// the record crossing midnight is stored in the file of the next day
+	report, err = meter.Report("1", start.Add(30*time.Minute), start.Add(45*time.Minute))
+	require.NoError(err)
+	require.InDelta(0.5, report.Workloads[0].Usage.CRU, 0.001)
+
+	// old files are removed
+	meter.Retention = 24 * time.Hour
+	require.NoError(meter.rotate(start.Add(48 * time.Hour)))
+	_, err = os.Stat(filepath.Join(root, "2020-12-31.jsonl"))
+	require.True(os.IsNotExist(err))
+}
+
+func TestMeterDecommissioned(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "meter-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(err)
+
+	data, err := json.Marshal(Container{Capacity: ContainerCapacity{CPU: 2, Memory: 4096}})
+	require.NoError(err)
+
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	container := &provision.Reservation{
+		ID:       "1-1",
+		User:     "1",
+		Type:     ContainerReservation,
+		Created:  start,
+		Duration: 24 * time.Hour,
+		Data:     data,
+		Result:   provision.Result{State: provision.StateOk},
+	}
+
+	// the container is decommissioned between two samples
+	cache := &testListCache{reservations: []*provision.Reservation{container}}
+	meter, err := NewMeter(root, "node", cache, &Counters{}, testSigner(priv))
+	require.NoError(err)
+
+	meter.last = start
+	require.NoError(meter.sample(start.Add(10 * time.Minute)))
+	remove := func() error {
+		cache.reservations = nil
+		return nil
+	}
+	require.NoError(meter.decommissioned(container, remove, start.Add(15*time.Minute)))
+	require.NoError(meter.sample(start.Add(20 * time.Minute)))
+
+	report, err := meter.Report("1", start, start.Add(time.Hour))
+	require.NoError(err)
+	require.Len(report.Workloads, 1)
+	require.InDelta(0.5, report.Workloads[0].Usage.CRU, 0.001)
+
+	// nothing is recorded if the reservation could not be removed
+	failing := func() error { return fmt.Errorf("cache is not writable") }
+	require.Error(meter.decommissioned(container, failing, start.Add(25*time.Minute)))
+
+	report, err = meter.Report("1", start, start.Add(time.Hour))
+	require.NoError(err)
+	require.InDelta(0.5, report.Workloads[0].Usage.CRU, 0.001)
+}
+
+func TestMeterLast(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "meter-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	meter, err := NewMeter(root, "node", &testListCache{}, &Counters{}, nil)
+	require.NoError(err)
+
+	now := time.Now()
+	require.Equal(now, meter.loadLast(now))
+
+	// the time of the last sample survives a restart
+	last := now.Add(-time.Second).Round(0)
+	require.NoError(meter.sample(last))
+
+	meter, err = NewMeter(root, "node", &testListCache{}, &Counters{}, nil)
+	require.NoError(err)
+	require.True(last.Equal(meter.loadLast(now)))
+
+	// but nothing is consumed before the node booted
+	meter.last = time.Unix(0, 0)
+	require.NoError(meter.saveLast())
+	require.True(meter.loadLast(now).After(time.Unix(0, 0)))
+}
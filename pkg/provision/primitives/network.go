@@ -27,6 +27,10 @@ func (p *Provisioner) networkProvisionImpl(ctx context.Context, reservation *pro
 	nr.NetID = provision.NetworkID(reservation.User, nr.Name)
 
 	mgr := stubs.NewNetworkerStub(p.zbus)
+	if err := mgr.Ready(); err != nil {
+		return provision.Retryable(errors.Wrap(err, "networkd is not ready"))
+	}
+
 	log.Debug().Str("network", fmt.Sprintf("%+v", nr)).Msg("provision network")
 
 	_, err := mgr.CreateNR(nr)
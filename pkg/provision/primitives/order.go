@@ -1,6 +1,10 @@
 package primitives
 
-import "github.com/threefoldtech/zos/pkg/provision"
+import (
+	"time"
+
+	"github.com/threefoldtech/zos/pkg/provision"
+)
 
 const (
 	// ContainerReservation type
@@ -19,6 +23,10 @@ const (
 	KubernetesReservation provision.ReservationType = "kubernetes"
 	// PublicIPReservation type
 	PublicIPReservation provision.ReservationType = "public_ip"
+	// VirtualMachineReservation type
+	VirtualMachineReservation provision.ReservationType = "virtual_machine"
+	// JobReservation type is a container that runs to completion
+	JobReservation provision.ReservationType = "job"
 )
 
 // ProvisionOrder is used to sort the workload type
@@ -32,4 +40,28 @@ var ProvisionOrder = map[provision.ReservationType]int{
 	ContainerReservation:       5,
 	KubernetesReservation:      6,
 	PublicIPReservation:        7,
+	VirtualMachineReservation:  8,
+	JobReservation:             9,
+}
+
+// RetryDeadlines is how long the provision engine retries
+// each workload type when it fails with a transient error
+var RetryDeadlines = map[provision.ReservationType]time.Duration{
+	DebugReservation:           0,
+	NetworkReservation:         2 * time.Minute,
+	NetworkResourceReservation: 2 * time.Minute,
+	ZDBReservation:             3 * time.Minute,
+	VolumeReservation:          time.Minute,
+	ContainerReservation:       5 * time.Minute,
+	KubernetesReservation:      10 * time.Minute,
+	PublicIPReservation:        2 * time.Minute,
+	VirtualMachineReservation:  10 * time.Minute,
+	JobReservation:             5 * time.Minute,
+}
+
+// PersistentTypes are the workload types holding user data. They are
+// kept during the grace period that follows the reservation expiration
+var PersistentTypes = map[provision.ReservationType]bool{
+	VolumeReservation: true,
+	ZDBReservation:    true,
 }
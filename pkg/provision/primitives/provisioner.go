@@ -11,16 +11,20 @@ import (
 // the different primitives workloads defined by this package
 type Provisioner struct {
 	cache provision.ReservationCache
+	users provision.UserKeyGetter
 	zbus  zbus.Client
 
 	Provisioners    map[provision.ReservationType]provision.ProvisionerFunc
 	Decommissioners map[provision.ReservationType]provision.DecomissionerFunc
+	Updaters        map[provision.ReservationType]provision.UpdaterFunc
 }
 
 // NewProvisioner creates a new 0-OS provisioner
-func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client) *Provisioner {
+// users is used to retrieve the users public keys needed to decrypt reservation secrets
+func NewProvisioner(cache provision.ReservationCache, users provision.UserKeyGetter, zbus zbus.Client) *Provisioner {
 	p := &Provisioner{
 		cache: cache,
+		users: users,
 		zbus:  zbus,
 	}
 	p.Provisioners = map[provision.ReservationType]provision.ProvisionerFunc{
@@ -32,6 +36,8 @@ func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client) *Provisi
 		DebugReservation:           p.debugProvision,
 		KubernetesReservation:      p.kubernetesProvision,
 		PublicIPReservation:        p.publicIPProvision,
+		VirtualMachineReservation:  p.virtualMachineProvision,
+		JobReservation:             p.containerProvision,
 	}
 	p.Decommissioners = map[provision.ReservationType]provision.DecomissionerFunc{
 		ContainerReservation:       p.containerDecommission,
@@ -42,6 +48,13 @@ func NewProvisioner(cache provision.ReservationCache, zbus zbus.Client) *Provisi
 		DebugReservation:           p.debugDecommission,
 		KubernetesReservation:      p.kubernetesDecomission,
 		PublicIPReservation:        p.publicIPDecomission,
+		VirtualMachineReservation:  p.virtualMachineDecomission,
+		JobReservation:             p.containerDecommission,
+	}
+	p.Updaters = map[provision.ReservationType]provision.UpdaterFunc{
+		ContainerReservation: p.containerUpdate,
+		VolumeReservation:    p.volumeUpdate,
+		ZDBReservation:       p.zdbUpdate,
 	}
 
 	return p
@@ -13,6 +13,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/zos/pkg/network/ifaceutil"
 	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/provision/common"
 	"github.com/threefoldtech/zos/pkg/stubs"
 )
 
@@ -48,7 +49,7 @@ func (p *Provisioner) publicIPProvisionImpl(ctx context.Context, reservation *pr
 	}
 
 	tapName := fmt.Sprintf("p-%s", reservation.ID) // TODO: clean this up, needs to come form networkd
-	fName := filterName(reservation.ID)
+	fName := common.PublicIPFilterName(reservation.ID)
 	mac := ifaceutil.HardwareAddrFromInputBytes(config.IP.IP.To4())
 
 	predictedIPv6, err := predictedSlaac(pubIP6Base.IP, mac.String())
@@ -66,17 +67,13 @@ func (p *Provisioner) publicIPProvisionImpl(ctx context.Context, reservation *pr
 func (p *Provisioner) publicIPDecomission(ctx context.Context, reservation *provision.Reservation) error {
 	// Disconnect the public interface from the network if one exists
 	network := stubs.NewNetworkerStub(p.zbus)
-	fName := filterName(reservation.ID)
-	if err := teardownFilters(ctx, fName); err != nil {
+	fName := common.PublicIPFilterName(reservation.ID)
+	if err := common.TeardownPublicIPFilters(ctx, fName); err != nil {
 		log.Error().Err(err).Msg("could not remove filter rules")
 	}
 	return network.DisconnectPubTap(reservation.ID)
 }
 
-func filterName(reservationID string) string {
-	return fmt.Sprintf("r-%s", reservationID)
-}
-
 func setupFilters(ctx context.Context, fName string, iface string, ip string, ipv6 string, mac string) error {
 	cmd := exec.CommandContext(ctx, "/bin/sh", "-c",
 		fmt.Sprintf(`# add vm
@@ -104,32 +101,6 @@ nft 'add rule bridge filter %[1]s ip version 4 udp sport 67 drop'`, fName, iface
 	return nil
 }
 
-func teardownFilters(ctx context.Context, fName string) error {
-	cmd := exec.CommandContext(ctx, "/bin/sh", "-c",
-		fmt.Sprintf(`# in bridge table
-nft 'flush chain bridge filter %[1]s'
-# jump to chain rule
-a=$( nft -a list table bridge filter | awk '/jump %[1]s/{ print $NF}' )
-nft 'delete rule bridge filter forward handle '${a}
-# chain itself
-a=$( nft -a list table bridge filter | awk '/chain %[1]s/{ print $NF}' )
-nft 'delete chain bridge filter handle '${a}
-
-# in arp table
-nft 'flush chain arp filter %[1]s'
-# jump to chain rule 
-a=$( nft -a list table arp filter | awk '/jump %[1]s/{ print $NF}' )
-nft 'delete rule arp filter input handle '${a}
-# chain itself
-a=$( nft -a list table arp filter | awk '/chain %[1]s/{ print $NF}' )
-nft 'delete chain arp filter handle '${a}`, fName))
-
-	if err := cmd.Run(); err != nil {
-		return errors.Wrap(err, "could not setup firewall rules for public ip")
-	}
-	return nil
-}
-
 // modified version of: https://github.com/MalteJ/docker/blob/f09b7897d2a54f35a0b26f7cbe750b3c9383a553/daemon/networkdriver/bridge/driver.go#L585
 func predictedSlaac(base net.IP, mac string) (string, error) {
 	// TODO: get pub ipv6 prefix
@@ -0,0 +1,191 @@
+package primitives
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+// QuotaLimits are the limits of a user in the policy file.
+// memory and storage are in GiB. A limit that is not set
+// is not enforced
+type QuotaLimits struct {
+	CRU       *uint64 `toml:"cru"`
+	MRU       *uint64 `toml:"mru"`
+	SRU       *uint64 `toml:"sru"`
+	HRU       *uint64 `toml:"hru"`
+	IPV4U     *uint64 `toml:"ipv4u"`
+	Workloads *uint64 `toml:"workloads"`
+}
+
+// quota converts the limits to a pkg.Quota
+func (l *QuotaLimits) quota() *pkg.Quota {
+	if l == nil {
+		return nil
+	}
+
+	bytes := func(v *uint64) *uint64 {
+		if v == nil {
+			return nil
+		}
+		b := *v * gib
+		return &b
+	}
+
+	return &pkg.Quota{
+		CRU:       l.CRU,
+		MRU:       bytes(l.MRU),
+		SRU:       bytes(l.SRU),
+		HRU:       bytes(l.HRU),
+		IPV4U:     l.IPV4U,
+		Workloads: l.Workloads,
+	}
+}
+
+// QuotaPolicy is the content of the quota policy file of the node
+//
+//	# applies to all the users without their own limits
+//	[default]
+//	cru = 4
+//	mru = 8
+//
+//	[users."12"]
+//	cru = 8
+//	mru = 16
+//	ipv4u = 1
+type QuotaPolicy struct {
+	Default *QuotaLimits           `toml:"default"`
+	Users   map[string]QuotaLimits `toml:"users"`
+}
+
+// Quota returns the quota applied to user, nil if the user is not limited
+func (p *QuotaPolicy) Quota(user string) *pkg.Quota {
+	if limits, ok := p.Users[user]; ok {
+		return limits.quota()
+	}
+
+	return p.Default.quota()
+}
+
+// LoadQuotaPolicy reads the quota policy file at path
+func LoadQuotaPolicy(path string) (QuotaPolicy, error) {
+	var policy QuotaPolicy
+	if _, err := toml.DecodeFile(path, &policy); err != nil {
+		return policy, errors.Wrapf(err, "failed to load quota policy %s", path)
+	}
+
+	return policy, nil
+}
+
+// QuotaChecker implements provision.QuotaChecker. It compares the resources
+// requested by a reservation plus what its user already reserved according
+// to the counters with the quota of the user. The policy file is read again
+// when it changes, so quotas can be changed without restarting the node
+type QuotaChecker struct {
+	counters *Counters
+	path     string
+
+	m       sync.Mutex
+	policy  QuotaPolicy
+	modTime time.Time
+}
+
+var _ provision.QuotaChecker = (*QuotaChecker)(nil)
+
+// NewQuotaChecker creates a quota checker that enforces the policy in the file at path
+func NewQuotaChecker(counters *Counters, path string) (*QuotaChecker, error) {
+	q := &QuotaChecker{
+		counters: counters,
+		path:     path,
+	}
+
+	if err := q.reload(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// reload reads the policy file again if it has been modified
+func (q *QuotaChecker) reload() error {
+	info, err := os.Stat(q.path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat quota policy %s", q.path)
+	}
+
+	if info.ModTime().Equal(q.modTime) {
+		return nil
+	}
+
+	policy, err := LoadQuotaPolicy(q.path)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("path", q.path).Msg("quota policy loaded")
+	q.policy = policy
+	q.modTime = info.ModTime()
+
+	return nil
+}
+
+func (q *QuotaChecker) quota(user string) *pkg.Quota {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if err := q.reload(); err != nil {
+		log.Error().Err(err).Msg("failed to reload quota policy, keeping the current one")
+	}
+
+	return q.policy.Quota(user)
+}
+
+// Check implements provision.QuotaChecker
+func (q *QuotaChecker) Check(r *provision.Reservation) error {
+	quota := q.quota(r.User)
+	if quota == nil {
+		return nil
+	}
+
+	u, err := q.counters.reservationUnits(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to compute reservation resource units")
+	}
+
+	usage := q.counters.UserUsage(r.User)
+	usage.Quota = quota
+	usage.Workloads++
+	usage.Used.CRU += u.CRU
+	usage.Used.MRU += u.MRU
+	usage.Used.SRU += u.SRU
+	usage.Used.HRU += u.HRU
+	if r.Type == PublicIPReservation {
+		usage.Used.IPV4U++
+	}
+
+	if exceeded := quota.Exceeded(usage.Used, usage.Workloads); len(exceeded) > 0 {
+		return pkg.ErrQuotaExceeded{Usage: usage, Exceeded: exceeded}
+	}
+
+	return nil
+}
+
+// Usage implements provision.QuotaChecker
+func (q *QuotaChecker) Usage() []pkg.UserUsage {
+	users := q.counters.Users()
+
+	usages := make([]pkg.UserUsage, 0, len(users))
+	for _, user := range users {
+		usage := q.counters.UserUsage(user)
+		usage.Quota = q.quota(user)
+		usages = append(usages, usage)
+	}
+
+	return usages
+}
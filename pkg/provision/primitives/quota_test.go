@@ -0,0 +1,91 @@
+package primitives
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+)
+
+const testPolicy = `
+[default]
+cru = 2
+workloads = 3
+
+[users."12"]
+mru = 1
+ipv4u = 0
+`
+
+func TestQuotaChecker(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "quota-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "quota.toml")
+	require.NoError(ioutil.WriteFile(path, []byte(testPolicy), 0644))
+
+	counters := &Counters{}
+	checker, err := NewQuotaChecker(counters, path)
+	require.NoError(err)
+
+	container := func(user string, cpu uint, memory uint64) *provision.Reservation {
+		data, err := json.Marshal(Container{
+			Capacity: ContainerCapacity{CPU: cpu, Memory: memory},
+		})
+		require.NoError(err)
+
+		return &provision.Reservation{User: user, Type: ContainerReservation, Data: data}
+	}
+
+	// default quota
+	r := container("1", 2, 512)
+	require.NoError(checker.Check(r))
+	require.NoError(counters.Increment(r))
+
+	err = checker.Check(container("1", 1, 512))
+	var quotaErr pkg.ErrQuotaExceeded
+	require.True(errors.As(err, &quotaErr))
+	require.Equal([]string{"cru"}, quotaErr.Exceeded)
+	require.Equal(uint64(3), quotaErr.Usage.Used.CRU)
+	require.Equal("quota of user 1 exceeded: too much cru requested", err.Error())
+
+	// user quota replaces the default one
+	require.NoError(checker.Check(container("12", 8, 1024)))
+	err = checker.Check(container("12", 1, 2048))
+	require.True(errors.As(err, &quotaErr))
+	require.Equal([]string{"mru"}, quotaErr.Exceeded)
+
+	err = checker.Check(&provision.Reservation{User: "12", Type: PublicIPReservation, Data: []byte("{}")})
+	require.True(errors.As(err, &quotaErr))
+	require.Equal([]string{"ipv4u"}, quotaErr.Exceeded)
+
+	usage := checker.Usage()
+	require.Len(usage, 1)
+	require.Equal("1", usage[0].User)
+	require.Equal(uint64(1), usage[0].Workloads)
+	require.Equal(uint64(2), usage[0].Used.CRU)
+	require.Equal(uint64(512*mib), usage[0].Used.MRU)
+	require.NotNil(usage[0].Quota)
+	require.Equal(uint64(2), *usage[0].Quota.CRU)
+	require.Nil(usage[0].Quota.MRU)
+
+	require.NoError(counters.Decrement(r))
+	require.Empty(checker.Usage())
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	limit := func(v uint64) *uint64 { return &v }
+
+	quota := pkg.Quota{MRU: limit(2 * gib), Workloads: limit(1)}
+	require.Empty(t, quota.Exceeded(pkg.ResourceUnits{CRU: 100, MRU: 2 * gib}, 1))
+	require.Equal(t, []string{"mru", "workloads"}, quota.Exceeded(pkg.ResourceUnits{MRU: 3 * gib}, 2))
+}
@@ -242,6 +242,25 @@ func Test_processKubernetes(t *testing.T) {
 	}
 }
 
+func Test_processVirtualMachine(t *testing.T) {
+	r := &provision.Reservation{
+		Type: VirtualMachineReservation,
+		Data: mustMarshalJSON(t, VirtualMachine{
+			CPU:      2,
+			Memory:   2048,
+			DiskSize: 10 * 1024,
+		}),
+	}
+
+	u, err := processVirtualMachine(r)
+	require.NoError(t, err)
+	assert.Equal(t, resourceUnits{
+		CRU: 2,
+		MRU: 2 * gib,
+		SRU: 10 * gib,
+	}, u)
+}
+
 func mustMarshalJSON(t *testing.T, v interface{}) []byte {
 	b, err := json.Marshal(v)
 	require.NoError(t, err)
@@ -0,0 +1,137 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+// containerUpdate changes the cpu and memory of a running container.
+// Any other change of the container configuration requires a new deployment
+func (p *Provisioner) containerUpdate(ctx context.Context, old, new *provision.Reservation) (interface{}, error) {
+	var (
+		containerClient = stubs.NewContainerModuleStub(p.zbus)
+		tenantNS        = fmt.Sprintf("ns%s", new.User)
+		containerID     = pkg.ContainerID(new.ID)
+	)
+
+	var oldConfig, config Container
+	if err := json.Unmarshal(old.Data, &oldConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to decode old reservation schema")
+	}
+	if err := json.Unmarshal(new.Data, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := validateContainerUpdate(oldConfig, config); err != nil {
+		return nil, err
+	}
+
+	if err := containerClient.Update(tenantNS, containerID, config.Capacity.CPU, config.Capacity.Memory*mib); err != nil {
+		return nil, errors.Wrapf(err, "failed to update container %s", containerID)
+	}
+
+	// nothing changes on the container itself so the
+	// result of the deployment is still valid
+	return old.Result.Data, nil
+}
+
+// validateContainerUpdate makes sure only the cpu and memory of
+// a container are changed
+func validateContainerUpdate(old, new Container) error {
+	if new.Capacity.Memory < 1024 {
+		return fmt.Errorf("amount of memory allocated for the container cannot be lower then 1024 megabytes")
+	}
+
+	if new.Capacity.CPU == 0 {
+		return fmt.Errorf("cannot create a container with 0 CPU allocated")
+	}
+
+	old.Capacity.CPU, old.Capacity.Memory = 0, 0
+	new.Capacity.CPU, new.Capacity.Memory = 0, 0
+	if !reflect.DeepEqual(old, new) {
+		return fmt.Errorf("only the cpu and memory of a container can be updated")
+	}
+
+	return nil
+}
+
+// volumeUpdate changes the size of a volume
+func (p *Provisioner) volumeUpdate(ctx context.Context, old, new *provision.Reservation) (interface{}, error) {
+	storageClient := stubs.NewStorageModuleStub(p.zbus)
+
+	var oldConfig, config Volume
+	if err := json.Unmarshal(old.Data, &oldConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to decode old reservation schema")
+	}
+	if err := json.Unmarshal(new.Data, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if oldConfig.Type != config.Type {
+		return nil, fmt.Errorf("cannot change the disk type of a volume from %s to %s", oldConfig.Type, config.Type)
+	}
+
+	if _, err := storageClient.UpdateFilesystem(provision.FilesystemName(*new), config.Size*gigabyte); err != nil {
+		return nil, errors.Wrapf(err, "failed to update volume %s", new.ID)
+	}
+
+	return VolumeResult{
+		ID: new.ID,
+	}, nil
+}
+
+// zdbUpdate changes the size, password or visibility of a 0-db namespace
+func (p *Provisioner) zdbUpdate(ctx context.Context, old, new *provision.Reservation) (interface{}, error) {
+	var (
+		storage = stubs.NewZDBAllocaterStub(p.zbus)
+		nsID    = new.ID
+	)
+
+	var oldConfig, config ZDB
+	if err := json.Unmarshal(old.Data, &oldConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to decode old reservation schema")
+	}
+	if err := json.Unmarshal(new.Data, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if oldConfig.Mode != config.Mode || oldConfig.DiskType != config.DiskType {
+		return nil, fmt.Errorf("only the size, password and visibility of a 0-db namespace can be updated")
+	}
+
+	var err error
+	config.PlainPassword, err = p.decryptSecret(config.Password, new.User, new.Version)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt namespace password")
+	}
+
+	allocation, err := storage.Find(nsID)
+	if err != nil && strings.Contains(err.Error(), "not found") {
+		return nil, fmt.Errorf("namespace %s is not deployed", nsID)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.ensureZdbContainer(ctx, allocation, config.Mode); err != nil {
+		return nil, errors.Wrap(err, "failed to find namespace zdb container")
+	}
+
+	containerID := pkg.ContainerID(allocation.VolumeID)
+	log.Info().Str("id", nsID).Str("container", string(containerID)).Msg("updating 0-db namespace")
+
+	// the namespace already exists so this only applies the new settings
+	if err := p.createZDBNamespace(containerID, nsID, config); err != nil {
+		return nil, errors.Wrap(err, "failed to update zdb namespace")
+	}
+
+	return old.Result.Data, nil
+}
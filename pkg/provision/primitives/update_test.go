@@ -0,0 +1,50 @@
+package primitives
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/threefoldtech/zos/pkg"
+)
+
+func TestValidateContainerUpdate(t *testing.T) {
+	old := Container{
+		FList: "https://hub.grid.tf/tf-official-apps/ubuntu.flist",
+		Env:   map[string]string{"KEY": "value"},
+		Network: Network{
+			NetworkID: "net",
+			IPs:       nil,
+		},
+		Capacity: ContainerCapacity{
+			CPU:      1,
+			Memory:   1024,
+			DiskType: pkg.SSDDevice,
+			DiskSize: 256,
+		},
+	}
+
+	t.Run("capacity", func(t *testing.T) {
+		new := old
+		new.Capacity.CPU = 2
+		new.Capacity.Memory = 2048
+		assert.NoError(t, validateContainerUpdate(old, new))
+	})
+
+	t.Run("not enough memory", func(t *testing.T) {
+		new := old
+		new.Capacity.Memory = 512
+		assert.Error(t, validateContainerUpdate(old, new))
+	})
+
+	t.Run("disk size", func(t *testing.T) {
+		new := old
+		new.Capacity.DiskSize = 512
+		assert.Error(t, validateContainerUpdate(old, new))
+	})
+
+	t.Run("flist", func(t *testing.T) {
+		new := old
+		new.FList = "https://hub.grid.tf/tf-official-apps/alpine.flist"
+		assert.Error(t, validateContainerUpdate(old, new))
+	})
+}
@@ -0,0 +1,323 @@
+package primitives
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/threefoldtech/zos/pkg"
+	"github.com/threefoldtech/zos/pkg/provision"
+	"github.com/threefoldtech/zos/pkg/stubs"
+)
+
+// files expected in the flist of a virtual machine image
+const (
+	vmKernelFile = "kernel"
+	vmInitrdFile = "initrd"
+	vmImageFile  = "image.raw"
+)
+
+// VirtualMachineResult result returned by a virtual machine reservation
+type VirtualMachineResult struct {
+	ID       string `json:"id"`
+	IP       string `json:"ip"`
+	PublicIP string `json:"public_ip,omitempty"`
+}
+
+// VirtualMachine reservation data
+type VirtualMachine struct {
+	// FList of the image of the vm. The flist must contain an uncompressed
+	// linux kernel `kernel`, a raw disk image `image.raw` with the root
+	// filesystem, and optionally an `initrd`.
+	// Docs: docs/vm/readme.md
+	FList string `json:"flist"`
+	// CPU is the number of vCpu of the vm
+	CPU uint8 `json:"cpu"`
+	// Memory of the vm in MiB
+	Memory uint64 `json:"memory"`
+	// DiskSize is the size of the root disk of the vm in MiB. It must
+	// be at least the size of the image
+	DiskSize uint64 `json:"disk_size"`
+
+	// NetworkID of the network namepsace in which to run the VM. The network
+	// must be provisioned previously.
+	NetworkID pkg.NetID `json:"network_id"`
+	// IP of the VM. The IP must be part of the subnet available in the network
+	// resource defined by the networkID on this node
+	IP net.IP `json:"ip"`
+	// SSHKeys is a list of ssh keys to add to the VM. They are passed to the
+	// vm as `ssh_authorized_keys` kernel arguments, like for the kubernetes VMs
+	SSHKeys []string `json:"ssh_keys"`
+	// PublicIP points to a reservation for a public ip
+	PublicIP schema.ID `json:"public_ip"`
+}
+
+// Validate checks the vm definition
+func (v *VirtualMachine) Validate() error {
+	if len(v.FList) == 0 {
+		return fmt.Errorf("flist is required")
+	}
+
+	if v.CPU == 0 || v.CPU > 32 {
+		return fmt.Errorf("invalid cpu must be between 1 and 32")
+	}
+
+	if v.Memory < 512 {
+		return fmt.Errorf("invalid memory must not be less than 512M")
+	}
+
+	if v.DiskSize == 0 {
+		return fmt.Errorf("disk size is required")
+	}
+
+	if len(v.NetworkID) == 0 {
+		return fmt.Errorf("network id is required")
+	}
+
+	if v.IP == nil {
+		return fmt.Errorf("ip is required")
+	}
+
+	return nil
+}
+
+func (p *Provisioner) virtualMachineProvision(ctx context.Context, reservation *provision.Reservation) (interface{}, error) {
+	return p.virtualMachineProvisionImpl(ctx, reservation)
+}
+
+func (p *Provisioner) virtualMachineProvisionImpl(ctx context.Context, reservation *provision.Reservation) (result VirtualMachineResult, err error) {
+	var (
+		storage = stubs.NewVDiskModuleStub(p.zbus)
+		network = stubs.NewNetworkerStub(p.zbus)
+		flist   = stubs.NewFlisterStub(p.zbus)
+		vm      = stubs.NewVMModuleStub(p.zbus)
+
+		config VirtualMachine
+
+		needsInstall = true
+	)
+
+	if err := json.Unmarshal(reservation.Data, &config); err != nil {
+		return result, errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if err := config.Validate(); err != nil {
+		return result, errors.Wrap(err, "invalid vm definition")
+	}
+
+	netID := provision.NetworkID(reservation.User, string(config.NetworkID))
+
+	result.ID = reservation.ID
+	result.IP = config.IP.String()
+
+	if _, err = vm.Inspect(reservation.ID); err == nil {
+		// vm is already running, nothing to do here
+		return result, nil
+	}
+
+	// check if public ipv4 is supported, should this be requested
+	if config.PublicIP != 0 && !network.PublicIPv4Support() {
+		return result, errors.New("public ipv4 is requested, but not supported on this node")
+	}
+
+	imagePath, err := ensureFList(flist, "vm", config.FList)
+	if err != nil {
+		// the image is chosen by the user, only retry if the hub is not reachable
+		return result, flistError(errors.Wrap(err, "could not mount vm image flist"))
+	}
+
+	kernel := filepath.Join(imagePath, vmKernelFile)
+	if _, err = os.Stat(kernel); err != nil {
+		return result, errors.Wrapf(err, "vm image flist has no %s", vmKernelFile)
+	}
+
+	var initrd string
+	if _, err := os.Stat(filepath.Join(imagePath, vmInitrdFile)); err == nil {
+		initrd = filepath.Join(imagePath, vmInitrdFile)
+	}
+
+	var diskPath string
+	diskName := fmt.Sprintf("%s-%s", provision.FilesystemName(*reservation), "vda")
+	if storage.Exists(diskName) {
+		needsInstall = false
+		info, err := storage.Inspect(diskName)
+		if err != nil {
+			return result, errors.Wrap(err, "could not get path to existing disk")
+		}
+		diskPath = info.Path
+	} else {
+		diskPath, err = storage.Allocate(diskName, int64(config.DiskSize))
+		if err != nil {
+			return result, errors.Wrap(err, "failed to reserve filesystem for vm")
+		}
+	}
+	// clean up the disk anyway, even if it has already been installed.
+	defer func() {
+		if err != nil {
+			_ = storage.Deallocate(diskName)
+		}
+	}()
+
+	if needsInstall {
+		if err = writeImage(filepath.Join(imagePath, vmImageFile), diskPath); err != nil {
+			return result, errors.Wrap(err, "failed to install vm image")
+		}
+	}
+
+	// the vm is not running, so a tap device left for the reservation
+	// is stale and can be replaced
+	exists, err := network.TapExists(reservation.ID)
+	if err != nil {
+		return result, errors.Wrap(err, "could not check if tap device exists")
+	}
+
+	if exists {
+		if err = network.RemoveTap(reservation.ID); err != nil {
+			return result, errors.Wrap(err, "could not remove stale tap device")
+		}
+	}
+
+	var iface string
+	iface, err = network.SetupTap(netID, reservation.ID)
+	if err != nil {
+		return result, errors.Wrap(err, "could not set up tap device")
+	}
+
+	defer func() {
+		if err != nil {
+			_ = network.RemoveTap(reservation.ID)
+		}
+	}()
+
+	var pubIface string
+	if config.PublicIP != 0 {
+		pubIface, err = network.SetupPubTap(pubIPResID(config.PublicIP))
+		if err != nil {
+			return result, errors.Wrap(err, "could not set up tap device for public network")
+		}
+
+		defer func() {
+			if err != nil {
+				_ = network.RemovePubTap(pubIPResID(config.PublicIP))
+			}
+		}()
+	}
+
+	var netInfo pkg.VMNetworkInfo
+	netInfo, err = p.buildNetworkInfo(ctx, reservation.Version, reservation.User, iface, pubIface, config.NetworkID, config.IP, config.PublicIP)
+	if err != nil {
+		return result, errors.Wrap(err, "could not generate network info")
+	}
+
+	for _, ifc := range netInfo.Ifaces {
+		if ifc.Public {
+			result.PublicIP = ifc.IP4AddressCIDR.IP.String()
+		}
+	}
+
+	cmdline := "console=ttyS0 reboot=k panic=1"
+	for _, key := range config.SSHKeys {
+		cmdline = fmt.Sprintf("%s ssh_authorized_keys=\"%s\"", cmdline, key)
+	}
+
+	machine := pkg.VM{
+		Name:        reservation.ID,
+		CPU:         config.CPU,
+		Memory:      int64(config.Memory),
+		Network:     netInfo,
+		KernelImage: kernel,
+		InitrdImage: initrd,
+		KernelArgs:  cmdline,
+		Disks: []pkg.VMDisk{
+			{Path: diskPath, ReadOnly: false, Root: true},
+		},
+	}
+
+	err = vm.Run(machine)
+	if err != nil {
+		// attempt to delete the vm, should the process still be lingering
+		vm.Delete(reservation.ID)
+	}
+
+	return result, err
+}
+
+// writeImage copies the raw disk image at src at the start of the disk at dst
+func writeImage(src, dst string) error {
+	image, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open vm image")
+	}
+	defer image.Close()
+
+	disk, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to open vm disk")
+	}
+	defer disk.Close()
+
+	imageInfo, err := image.Stat()
+	if err != nil {
+		return err
+	}
+
+	diskInfo, err := disk.Stat()
+	if err != nil {
+		return err
+	}
+
+	if imageInfo.Size() > diskInfo.Size() {
+		return fmt.Errorf("vm image of %d bytes does not fit in a disk of %d bytes", imageInfo.Size(), diskInfo.Size())
+	}
+
+	if _, err := io.Copy(disk, image); err != nil {
+		return errors.Wrap(err, "failed to write vm image")
+	}
+
+	return disk.Sync()
+}
+
+func (p *Provisioner) virtualMachineDecomission(ctx context.Context, reservation *provision.Reservation) error {
+	var (
+		storage = stubs.NewVDiskModuleStub(p.zbus)
+		network = stubs.NewNetworkerStub(p.zbus)
+		vm      = stubs.NewVMModuleStub(p.zbus)
+
+		cfg VirtualMachine
+	)
+
+	if err := json.Unmarshal(reservation.Data, &cfg); err != nil {
+		return errors.Wrap(err, "failed to decode reservation schema")
+	}
+
+	if _, err := vm.Inspect(reservation.ID); err == nil {
+		if err := vm.Delete(reservation.ID); err != nil {
+			return errors.Wrapf(err, "failed to delete vm %s", reservation.ID)
+		}
+	}
+
+	if err := network.RemoveTap(reservation.ID); err != nil {
+		return errors.Wrap(err, "could not clean up tap device")
+	}
+
+	if cfg.PublicIP != 0 {
+		if err := network.RemovePubTap(pubIPResID(cfg.PublicIP)); err != nil {
+			return errors.Wrap(err, "could not clean up public tap device")
+		}
+	}
+
+	diskName := fmt.Sprintf("%s-%s", provision.FilesystemName(*reservation), "vda")
+	if storage.Exists(diskName) {
+		if err := storage.Deallocate(diskName); err != nil {
+			return errors.Wrap(err, "could not remove vDisk")
+		}
+	}
+
+	return nil
+}
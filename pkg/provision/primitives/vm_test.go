@@ -0,0 +1,60 @@
+package primitives
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualMachineValidate(t *testing.T) {
+	vm := VirtualMachine{
+		FList:     "https://hub.grid.tf/tf-official-vms/ubuntu-20.04.flist",
+		CPU:       1,
+		Memory:    1024,
+		DiskSize:  5 * 1024,
+		NetworkID: "net",
+		IP:        net.ParseIP("10.1.1.2"),
+	}
+	require.NoError(t, vm.Validate())
+
+	invalid := vm
+	invalid.FList = ""
+	require.Error(t, invalid.Validate())
+
+	invalid = vm
+	invalid.Memory = 256
+	require.Error(t, invalid.Validate())
+
+	invalid = vm
+	invalid.IP = nil
+	require.Error(t, invalid.Validate())
+}
+
+func TestWriteImage(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ioutil.TempDir("", "vm-")
+	require.NoError(err)
+	defer os.RemoveAll(root)
+
+	image := filepath.Join(root, vmImageFile)
+	require.NoError(ioutil.WriteFile(image, []byte("image"), 0644))
+
+	disk := filepath.Join(root, "disk")
+	require.NoError(ioutil.WriteFile(disk, make([]byte, 16), 0644))
+
+	require.NoError(writeImage(image, disk))
+
+	data, err := ioutil.ReadFile(disk)
+	require.NoError(err)
+	require.Len(data, 16)
+	require.Equal("image", string(data[:5]))
+
+	// the image must fit in the disk
+	require.NoError(ioutil.WriteFile(disk, make([]byte, 2), 0644))
+	require.Error(writeImage(image, disk))
+}
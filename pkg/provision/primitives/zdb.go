@@ -66,7 +66,7 @@ func (p *Provisioner) zdbProvisionImpl(ctx context.Context, reservation *provisi
 	}
 
 	var err error
-	config.PlainPassword, err = decryptSecret(config.Password, reservation.User, reservation.Version, p.zbus)
+	config.PlainPassword, err = p.decryptSecret(config.Password, reservation.User, reservation.Version)
 	if err != nil {
 		return ZDBResult{}, errors.Wrap(err, "failed to decrypt namespace password")
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -15,6 +16,11 @@ import (
 // ReservationType type
 type ReservationType string
 
+// NoExpiry is the duration of the reservations that never expire on the node.
+// The reservations of the explorer use it, when their capacity pool runs out
+// they are received again with ToDelete set
+const NoExpiry = time.Duration(math.MaxInt64)
+
 // Reservation struct
 type Reservation struct {
 	// ID of the reservation
@@ -34,6 +40,10 @@ type Reservation struct {
 	// Signature is the signature to the reservation
 	// it contains all the field of this struct except the signature itself and the Result field
 	Signature []byte `json:"signature,omitempty"`
+	// Verified is set by the reservation sources that already checked the
+	// signature of the user (the explorer), the engine doesn't check it again.
+	// It is never stored or read from the reservation json
+	Verified bool `json:"-"`
 
 	// This flag is set to true when a reservation needs to be deleted
 	// before its expiration time
@@ -100,23 +110,24 @@ func (r *Reservation) SplitID() (reservation uint64, workload uint64, err error)
 	return
 }
 
+// Expires returns false if the reservation has no expiration on the node,
+// see NoExpiry
+func (r *Reservation) Expires() bool {
+	return r.Duration != NoExpiry
+}
+
+// Expiry returns the time at which the reservation expires
+func (r *Reservation) Expiry() time.Time {
+	return r.Created.Add(r.Duration)
+}
+
 // Expired returns a boolean depending if the reservation
 // has expire or not at the time of the function call
 func (r *Reservation) Expired() bool {
-	expire := r.Created.Add(r.Duration)
-	return time.Now().After(expire)
+	return time.Now().After(r.Expiry())
 }
 
 func (r *Reservation) validate() error {
-	// TODO: during testnet phase seems we don't need to verify this
-	// if err := Verify(r); err != nil {
-	// 	log.Warn().
-	// 		Err(err).
-	// 		Str("id", string(r.ID)).
-	// 		Msg("verification of reservation signature failed")
-	// 	return errors.Wrapf(err, "verification of reservation %s signature failed", r.ID)
-	// }
-
 	if r.Duration <= 0 {
 		return fmt.Errorf("reservation %s has not duration", r.ID)
 	}
@@ -0,0 +1,134 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/zbus"
+)
+
+// DefaultRetryDeadline is how long the engine retries a provision that
+// failed with a retryable error, for the reservation types that don't
+// have a deadline in EngineOps.RetryDeadlines
+const DefaultRetryDeadline = 2 * time.Minute
+
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+// Retryable marks err as a transient error. Provisioners use it to let the engine
+// know the provision can succeed if tried again (for example when the hub
+// is not reachable or a system module is not ready yet)
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return retryableError{err: err}
+}
+
+// remoteTimeouts are parts of the messages of the timeout errors. Errors returned
+// over zbus are only known by their message
+var remoteTimeouts = []string{
+	context.DeadlineExceeded.Error(),
+	"i/o timeout",
+	"Client.Timeout exceeded",
+	"TLS handshake timeout",
+}
+
+// IsRetryable returns true if err is a transient error. Errors marked with Retryable,
+// timeouts (including the ones returned by zbus calls) and temporary network errors
+// are retryable, all other errors are permanent
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.As(err, &retryableError{}) {
+		return true
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var remote *zbus.RemoteError
+	if errors.As(err, &remote) {
+		for _, timeout := range remoteTimeouts {
+			if strings.Contains(remote.Message, timeout) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+
+	return false
+}
+
+// retry calls op until it succeeds, returns a permanent error or the deadline
+// is reached. Retryable errors are retried with an exponential backoff.
+// A deadline of 0 disables the retries
+func retry(ctx context.Context, deadline time.Duration, op func() error, notify backoff.Notify) error {
+	if deadline <= 0 {
+		return op()
+	}
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = 30 * time.Second
+	bo.MaxElapsedTime = deadline
+
+	return backoff.RetryNotify(func() error {
+		err := op()
+		if err != nil && !IsRetryable(err) {
+			return backoff.Permanent(err)
+		}
+
+		return err
+	}, backoff.WithContext(bo, ctx), notify)
+}
+
+// recovered turns the value p recovered from a panic of a provisioner into an
+// error. The zbus stubs panic when the call to the module fails, failing to reach
+// the message broker is a transient error
+func recovered(p interface{}) error {
+	err, ok := p.(error)
+	if !ok {
+		return fmt.Errorf("provisioner panicked: %v", p)
+	}
+
+	var rtErr runtime.Error
+	if errors.As(err, &rtErr) {
+		log.Error().Err(err).Msg("provisioner panicked")
+		return errors.Wrap(err, "provisioner panicked")
+	}
+
+	err = errors.Wrap(err, "zbus call failed")
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Retryable(err)
+	}
+
+	return err
+}
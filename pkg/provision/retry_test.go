@@ -0,0 +1,115 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/zbus"
+)
+
+func TestIsRetryable(t *testing.T) {
+	require := require.New(t)
+
+	require.False(IsRetryable(nil))
+	require.False(IsRetryable(fmt.Errorf("invalid flist")))
+	require.True(IsRetryable(Retryable(fmt.Errorf("hub unreachable"))))
+	require.True(IsRetryable(errors.Wrap(Retryable(fmt.Errorf("hub unreachable")), "failed to mount flist")))
+	require.True(IsRetryable(errors.Wrap(context.DeadlineExceeded, "zbus call")))
+	require.Nil(Retryable(nil))
+
+	// errors returned over zbus are only known by their message
+	require.True(IsRetryable(&zbus.RemoteError{Message: "failed to create disk: context deadline exceeded"}))
+	require.True(IsRetryable(errors.Wrap(&zbus.RemoteError{Message: "read tcp 10.1.1.1:443: i/o timeout"}, "failed to mount flist")))
+	require.False(IsRetryable(&zbus.RemoteError{Message: "invalid disk size"}))
+}
+
+func TestProvisionForwardPanic(t *testing.T) {
+	require := require.New(t)
+
+	engine := &Engine{retryDeadlines: map[ReservationType]time.Duration{"container": time.Minute}}
+	r := &Reservation{ID: "1-1", Type: "container"}
+
+	t.Run("transport", func(t *testing.T) {
+		calls := 0
+		_, err := engine.provisionForward(context.Background(), func(ctx context.Context, r *Reservation) (interface{}, error) {
+			calls++
+			if calls < 2 {
+				// what a zbus stub does when the broker is not reachable
+				panic(&net.OpError{Op: "dial", Net: "unix", Err: syscall.ECONNREFUSED})
+			}
+			return "ok", nil
+		}, r, nil)
+
+		require.NoError(err)
+		require.Equal(2, calls)
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		calls := 0
+		_, err := engine.provisionForward(context.Background(), func(ctx context.Context, r *Reservation) (interface{}, error) {
+			calls++
+			panic(fmt.Errorf("unknown object"))
+		}, r, nil)
+
+		require.EqualError(err, "zbus call failed: unknown object")
+		require.Equal(1, calls)
+	})
+
+	t.Run("bug", func(t *testing.T) {
+		_, err := engine.provisionForward(context.Background(), func(ctx context.Context, r *Reservation) (interface{}, error) {
+			var m map[string]int
+			m["crash"]++
+			return nil, nil
+		}, r, nil)
+
+		require.Error(err)
+		require.False(IsRetryable(err))
+	})
+}
+
+func TestRetry(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	t.Run("transient", func(t *testing.T) {
+		calls := 0
+		err := retry(ctx, time.Minute, func() error {
+			calls++
+			if calls < 3 {
+				return Retryable(fmt.Errorf("not ready"))
+			}
+			return nil
+		}, nil)
+
+		require.NoError(err)
+		require.Equal(3, calls)
+	})
+
+	t.Run("permanent", func(t *testing.T) {
+		calls := 0
+		err := retry(ctx, time.Minute, func() error {
+			calls++
+			return fmt.Errorf("invalid")
+		}, nil)
+
+		require.EqualError(err, "invalid")
+		require.Equal(1, calls)
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		calls := 0
+		err := retry(ctx, 0, func() error {
+			calls++
+			return Retryable(fmt.Errorf("not ready"))
+		}, nil)
+
+		require.Error(err)
+		require.Equal(1, calls)
+	})
+}
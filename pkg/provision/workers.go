@@ -0,0 +1,115 @@
+package provision
+
+import (
+	"sync"
+)
+
+// scheduler dispatches reservation jobs to a pool of workers.
+// It makes sure jobs that share a key are executed in order
+type scheduler struct {
+	keys ReservationKeysFunc
+	jobs chan *scheduledJob
+
+	// last holds the done channel of the last job
+	// scheduled for each key
+	last map[string]chan struct{}
+	wg   sync.WaitGroup
+}
+
+type scheduledJob struct {
+	fn   func()
+	deps []chan struct{}
+	done chan struct{}
+}
+
+func newScheduler(workers int, keys ReservationKeysFunc) *scheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	s := &scheduler{
+		keys: keys,
+		jobs: make(chan *scheduledJob),
+		last: make(map[string]chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+
+	return s
+}
+
+func (s *scheduler) worker() {
+	for job := range s.jobs {
+		// wait for all the jobs this one depends on. Since dependencies
+		// are always scheduled before the job itself, they are either done
+		// or being processed by another worker.
+		for _, dep := range job.deps {
+			<-dep
+		}
+
+		job.fn()
+		close(job.done)
+		s.wg.Done()
+	}
+}
+
+// Schedule queues fn to be executed for reservation r. Schedule must
+// always be called from the same goroutine
+func (s *scheduler) Schedule(r *Reservation, fn func()) {
+	job := &scheduledJob{
+		fn:   fn,
+		done: make(chan struct{}),
+	}
+
+	for _, key := range s.jobKeys(r) {
+		if dep, ok := s.last[key]; ok {
+			job.deps = append(job.deps, dep)
+		}
+		s.last[key] = job.done
+	}
+
+	s.wg.Add(1)
+	s.jobs <- job
+
+	s.prune()
+}
+
+// Wait blocks until all scheduled jobs are done
+func (s *scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Close waits for all the scheduled jobs to finish and
+// stops the workers
+func (s *scheduler) Close() {
+	s.Wait()
+	close(s.jobs)
+}
+
+func (s *scheduler) jobKeys(r *Reservation) []string {
+	// a reservation always depends on itself
+	keys := []string{r.ID}
+	if r.Reference != "" {
+		keys = append(keys, r.Reference)
+	}
+
+	if s.keys != nil {
+		keys = append(keys, s.keys(r)...)
+	}
+
+	return keys
+}
+
+// prune removes the keys for which the last job is already
+// done, so the map doesn't grow forever
+func (s *scheduler) prune() {
+	for key, done := range s.last {
+		select {
+		case <-done:
+			delete(s.last, key)
+		default:
+		}
+	}
+}
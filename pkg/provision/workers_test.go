@@ -0,0 +1,97 @@
+package provision
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	require := require.New(t)
+
+	keys := func(r *Reservation) []string {
+		return []string{string(r.Type)}
+	}
+
+	s := newScheduler(4, keys)
+	defer s.Close()
+
+	var (
+		m     sync.Mutex
+		order []string
+	)
+
+	record := func(id string, d time.Duration) func() {
+		return func() {
+			time.Sleep(d)
+			m.Lock()
+			defer m.Unlock()
+			order = append(order, id)
+		}
+	}
+
+	// 1-1 is slow, but 1-2 shares the same key so it must wait for it
+	s.Schedule(&Reservation{ID: "1-1", Type: "network"}, record("1-1", 50*time.Millisecond))
+	s.Schedule(&Reservation{ID: "1-2", Type: "network"}, record("1-2", 0))
+	s.Wait()
+
+	require.Equal([]string{"1-1", "1-2"}, order)
+}
+
+func TestSchedulerParallel(t *testing.T) {
+	require := require.New(t)
+
+	s := newScheduler(2, nil)
+	defer s.Close()
+
+	var (
+		m     sync.Mutex
+		order []string
+	)
+
+	record := func(id string, d time.Duration) func() {
+		return func() {
+			time.Sleep(d)
+			m.Lock()
+			defer m.Unlock()
+			order = append(order, id)
+		}
+	}
+
+	// no common keys, so 1-2 doesn't wait for the slow 1-1
+	s.Schedule(&Reservation{ID: "1-1"}, record("1-1", 50*time.Millisecond))
+	s.Schedule(&Reservation{ID: "1-2"}, record("1-2", 0))
+	s.Wait()
+
+	require.Equal([]string{"1-2", "1-1"}, order)
+}
+
+func TestSchedulerSameReservation(t *testing.T) {
+	require := require.New(t)
+
+	s := newScheduler(2, nil)
+	defer s.Close()
+
+	var (
+		m     sync.Mutex
+		order []string
+	)
+
+	record := func(id string, d time.Duration) func() {
+		return func() {
+			time.Sleep(d)
+			m.Lock()
+			defer m.Unlock()
+			order = append(order, id)
+		}
+	}
+
+	// provision and decommission of the same reservation are never concurrent
+	s.Schedule(&Reservation{ID: "1-1"}, record("provision", 50*time.Millisecond))
+	s.Schedule(&Reservation{ID: "1-1", ToDelete: true}, record("decommission", 0))
+	s.Wait()
+
+	require.Equal([]string{"provision", "decommission"}, order)
+}
@@ -152,6 +152,11 @@ type VolumeAllocater interface {
 	// space which has been reserved for this filesystem will be reclaimed.
 	ReleaseFilesystem(name string) error
 
+	// UpdateFilesystem changes the size limit of the named filesystem. Growing the
+	// filesystem fails with `ErrNotEnoughSpace` if the pool it lives in doesn't have
+	// enough space left. The filesystem cannot be shrinked below its current usage
+	UpdateFilesystem(name string, size uint64) (Filesystem, error)
+
 	// ListFilesystems return all the filesystem managed by storeaged present on the nodes
 	// this can be an expensive call on server with a lot of disk, don't use it in a
 	// intensive loop
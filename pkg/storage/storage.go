@@ -412,6 +412,58 @@ func (s *Module) ReleaseFilesystem(name string) error {
 	return nil
 }
 
+// UpdateFilesystem changes the size limit of the filesystem with the given name
+func (s *Module) UpdateFilesystem(name string, size uint64) (pkg.Filesystem, error) {
+	log.Info().Msgf("Updating volume %v to size %d", name, size)
+
+	pool, fs, err := s.path(name)
+	if err != nil {
+		return pkg.Filesystem{}, err
+	}
+
+	if size < fs.Usage.Used {
+		return pkg.Filesystem{}, fmt.Errorf("cannot set volume size to %d, %d bytes are already used", size, fs.Usage.Used)
+	}
+
+	if size > fs.Usage.Size {
+		usage, err := pool.Usage()
+		if err != nil {
+			return pkg.Filesystem{}, errors.Wrapf(err, "failed to get usage of pool %s", pool.Name())
+		}
+
+		reserved, err := pool.Reserved()
+		if err != nil {
+			return pkg.Filesystem{}, errors.Wrapf(err, "failed to get size of pool %s", pool.Name())
+		}
+
+		// Make sure growing this filesystem would not bring us over the disk limit
+		if reserved+size-fs.Usage.Size > usage.Size {
+			return pkg.Filesystem{}, pkg.ErrNotEnoughSpace{DeviceType: pool.Type()}
+		}
+	}
+
+	volumes, err := pool.Volumes()
+	if err != nil {
+		return pkg.Filesystem{}, err
+	}
+
+	for _, volume := range volumes {
+		if volume.Name() != name {
+			continue
+		}
+
+		if err := volume.Limit(size); err != nil {
+			log.Error().Err(err).Str("volume", volume.Path()).Msg("failed to set volume size limit")
+			return pkg.Filesystem{}, err
+		}
+
+		fs.Usage.Size = size
+		return fs, nil
+	}
+
+	return pkg.Filesystem{}, errors.Wrapf(os.ErrNotExist, "subvolume '%s' not found", name)
+}
+
 // ListFilesystems return all the filesystem managed by storeaged present on the nodes
 func (s *Module) ListFilesystems() ([]pkg.Filesystem, error) {
 	fss := make([]pkg.Filesystem, 0, 10)
@@ -22,6 +22,38 @@ func NewContainerModuleStub(client zbus.Client) *ContainerModuleStub {
 	}
 }
 
+func (s *ContainerModuleStub) Attach(arg0 string, arg1 pkg.ContainerID, arg2 pkg.AccessToken, arg3 string) (ret0 pkg.ExecSession, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3}
+	result, err := s.client.Request(s.module, s.object, "Attach", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ContainerModuleStub) Console(arg0 string, arg1 pkg.ContainerID, arg2 pkg.AccessToken) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "Console", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *ContainerModuleStub) Delete(arg0 string, arg1 pkg.ContainerID) (ret0 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Delete", args...)
@@ -35,6 +67,22 @@ func (s *ContainerModuleStub) Delete(arg0 string, arg1 pkg.ContainerID) (ret0 er
 	return
 }
 
+func (s *ContainerModuleStub) Exec(arg0 string, arg1 pkg.ContainerID, arg2 pkg.AccessToken, arg3 []string, arg4 []string, arg5 bool) (ret0 pkg.ExecSession, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3, arg4, arg5}
+	result, err := s.client.Request(s.module, s.object, "Exec", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *ContainerModuleStub) Inspect(arg0 string, arg1 pkg.ContainerID) (ret0 pkg.Container, ret1 error) {
 	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "Inspect", args...)
@@ -98,3 +146,16 @@ func (s *ContainerModuleStub) Run(arg0 string, arg1 pkg.Container) (ret0 pkg.Con
 	}
 	return
 }
+
+func (s *ContainerModuleStub) Update(arg0 string, arg1 pkg.ContainerID, arg2 uint, arg3 uint64) (ret0 error) {
+	args := []interface{}{arg0, arg1, arg2, arg3}
+	result, err := s.client.Request(s.module, s.object, "Update", args...)
+	if err != nil {
+		panic(err)
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
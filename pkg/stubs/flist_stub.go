@@ -86,6 +86,22 @@ func (s *FlisterStub) NamedMount(arg0 string, arg1 string, arg2 string, arg3 pkg
 	return
 }
 
+func (s *FlisterStub) NamedMounts() (ret0 []string, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "NamedMounts", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *FlisterStub) NamedUmount(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "NamedUmount", args...)
@@ -286,7 +286,7 @@ func (s *NetworkerStub) RemovePubTap(arg0 string) (ret0 error) {
 	return
 }
 
-func (s *NetworkerStub) RemoveTap(arg0 pkg.NetID) (ret0 error) {
+func (s *NetworkerStub) RemoveTap(arg0 string) (ret0 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "RemoveTap", args...)
 	if err != nil {
@@ -315,8 +315,8 @@ func (s *NetworkerStub) SetupPubTap(arg0 string) (ret0 string, ret1 error) {
 	return
 }
 
-func (s *NetworkerStub) SetupTap(arg0 pkg.NetID) (ret0 string, ret1 error) {
-	args := []interface{}{arg0}
+func (s *NetworkerStub) SetupTap(arg0 pkg.NetID, arg1 string) (ret0 string, ret1 error) {
+	args := []interface{}{arg0, arg1}
 	result, err := s.client.Request(s.module, s.object, "SetupTap", args...)
 	if err != nil {
 		panic(err)
@@ -331,7 +331,7 @@ func (s *NetworkerStub) SetupTap(arg0 pkg.NetID) (ret0 string, ret1 error) {
 	return
 }
 
-func (s *NetworkerStub) TapExists(arg0 pkg.NetID) (ret0 bool, ret1 error) {
+func (s *NetworkerStub) TapExists(arg0 string) (ret0 bool, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "TapExists", args...)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	zbus "github.com/threefoldtech/zbus"
 	pkg "github.com/threefoldtech/zos/pkg"
+	"time"
 )
 
 type ProvisionStub struct {
@@ -23,6 +24,22 @@ func NewProvisionStub(client zbus.Client) *ProvisionStub {
 	}
 }
 
+func (s *ProvisionStub) CheckCapacity(arg0 string, arg1 []uint8) (ret0 pkg.Admission, ret1 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "CheckCapacity", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *ProvisionStub) Counters(ctx context.Context) (<-chan pkg.ProvisionCounters, error) {
 	ch := make(chan pkg.ProvisionCounters)
 	recv, err := s.client.Stream(ctx, s.module, s.object, "Counters")
@@ -54,3 +71,119 @@ func (s *ProvisionStub) DecommissionCached(arg0 string, arg1 string) (ret0 error
 	}
 	return
 }
+
+func (s *ProvisionStub) Events(ctx context.Context) (<-chan pkg.ProvisionEvent, error) {
+	ch := make(chan pkg.ProvisionEvent)
+	recv, err := s.client.Stream(ctx, s.module, s.object, "Events")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer close(ch)
+		for event := range recv {
+			var obj pkg.ProvisionEvent
+			if err := event.Unmarshal(&obj); err != nil {
+				panic(err)
+			}
+			ch <- obj
+		}
+	}()
+	return ch, nil
+}
+
+func (s *ProvisionStub) JobExited(arg0 string, arg1 pkg.JobResult) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "JobExited", args...)
+	if err != nil {
+		panic(err)
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ProvisionStub) RegisterProvisioner(arg0 string, arg1 pkg.ExternalProvisionerID) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "RegisterProvisioner", args...)
+	if err != nil {
+		panic(err)
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ProvisionStub) UnregisterProvisioner(arg0 string) (ret0 error) {
+	args := []interface{}{arg0}
+	result, err := s.client.Request(s.module, s.object, "UnregisterProvisioner", args...)
+	if err != nil {
+		panic(err)
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ProvisionStub) Usage() (ret0 []pkg.UserUsage, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "Usage", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ProvisionStub) UsageReport(arg0 string, arg1 time.Time, arg2 time.Time) (ret0 pkg.UsageReport, ret1 error) {
+	args := []interface{}{arg0, arg1, arg2}
+	result, err := s.client.Request(s.module, s.object, "UsageReport", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ProvisionStub) VerifyToken(arg0 string, arg1 pkg.AccessToken) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "VerifyToken", args...)
+	if err != nil {
+		panic(err)
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
+
+func (s *ProvisionStub) WorkloadStopped(arg0 string, arg1 string) (ret0 error) {
+	args := []interface{}{arg0, arg1}
+	result, err := s.client.Request(s.module, s.object, "WorkloadStopped", args...)
+	if err != nil {
+		panic(err)
+	}
+	ret0 = new(zbus.RemoteError)
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	return
+}
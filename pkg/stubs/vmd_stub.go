@@ -63,6 +63,22 @@ func (s *VMModuleStub) Inspect(arg0 string) (ret0 pkg.VMInfo, ret1 error) {
 	return
 }
 
+func (s *VMModuleStub) List() (ret0 []string, ret1 error) {
+	args := []interface{}{}
+	result, err := s.client.Request(s.module, s.object, "List", args...)
+	if err != nil {
+		panic(err)
+	}
+	if err := result.Unmarshal(0, &ret0); err != nil {
+		panic(err)
+	}
+	ret1 = new(zbus.RemoteError)
+	if err := result.Unmarshal(1, &ret1); err != nil {
+		panic(err)
+	}
+	return
+}
+
 func (s *VMModuleStub) Logs(arg0 string) (ret0 string, ret1 error) {
 	args := []interface{}{arg0}
 	result, err := s.client.Request(s.module, s.object, "Logs", args...)
@@ -387,6 +387,21 @@ func (m *Module) Logs(name string) (string, error) {
 	return m.tail(path)
 }
 
+// List returns the names of all the running machines
+func (m *Module) List() ([]string, error) {
+	machines, err := findAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list running machines")
+	}
+
+	names := make([]string, 0, len(machines))
+	for name := range machines {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
 // Inspect a machine by name
 func (m *Module) Inspect(name string) (pkg.VMInfo, error) {
 	if !m.Exists(name) {
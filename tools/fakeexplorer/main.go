@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/threefoldtech/zos/pkg/explorer/fake"
+)
+
+// fakeexplorer runs an in memory explorer. Point the node daemons
+// to it with ZOS_BCDB_URL=http://<listen> (or the bcdb kernel
+// parameter in dev mode). Farms, users and workloads are created
+// with the /fake endpoints, for example:
+//
+//	curl -X POST -d '{"id": 2, "name": "farm"}' http://localhost:8080/fake/farms
+//	curl -X POST -d '{"name": "user", "pubkey": "<hex>"}' http://localhost:8080/fake/users
+//	curl -X POST -d @workload.json http://localhost:8080/fake/workloads
+//	curl -X DELETE http://localhost:8080/fake/workloads/1
+//
+// The workloads must be signed by the private key of their customer,
+// the same way as for the explorer
+func main() {
+	var (
+		listen string
+		farm   int64
+		debug  bool
+	)
+
+	flag.StringVar(&listen, "listen", ":8080", "address to listen on")
+	flag.Int64Var(&farm, "farm", 1, "id of a farm created on start so nodes can register right away, 0 to disable")
+	flag.BoolVar(&debug, "debug", false, "log all requests")
+	flag.Parse()
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if debug {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	server := fake.NewServer()
+	if farm != 0 {
+		server.AddFarm(directory.Farm{ID: schema.ID(farm), Name: "fake"})
+	}
+
+	log.Info().Str("listen", listen).Msg("fake explorer started")
+	if err := http.ListenAndServe(listen, server.Handler()); err != nil {
+		log.Fatal().Err(err).Msg("fake explorer stopped")
+	}
+}